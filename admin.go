@@ -0,0 +1,81 @@
+package main
+
+// admin.go implements the admin endpoints of the HTTP API, which save the
+// engine's transposition table to disk and load it back, so a long analysis
+// can be resumed after the server restarts. The endpoints are only enabled
+// when an admin token is configured, and every request must present it.
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The default file the transposition table is saved to.
+const DefaultHashFile = "blunder.hash"
+
+// The token admin requests must present, and the file the transposition
+// table is saved to and loaded from.
+var adminToken string
+var hashFilePath = DefaultHashFile
+
+// Reject requests which don't present the admin token as a bearer token.
+func requireAdminToken(c *gin.Context) {
+	if adminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "admin endpoints are disabled, since no admin token is configured",
+		})
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid admin token",
+		})
+		return
+	}
+	c.Next()
+}
+
+// Get the JSON representation of the engine's transposition table. The
+// caller must hold the engine search's mutex.
+func hashToJSON() gin.H {
+	return gin.H{
+		"path":     hashFilePath,
+		"sizeMB":   engineSearch.TT.SizeInMB(),
+		"hashfull": engineSearch.TT.Hashfull(),
+	}
+}
+
+func setupAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/admin", requireAdminToken)
+
+	admin.POST("/hash/save", func(c *gin.Context) {
+		engineSearchMutex.Lock()
+		defer engineSearchMutex.Unlock()
+
+		if err := engineSearch.TT.SaveFile(hashFilePath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to save the hash: " + err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, hashToJSON())
+	})
+
+	admin.POST("/hash/load", func(c *gin.Context) {
+		engineSearchMutex.Lock()
+		defer engineSearchMutex.Unlock()
+
+		if err := engineSearch.TT.LoadFile(hashFilePath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to load the hash: " + err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, hashToJSON())
+	})
+}
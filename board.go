@@ -0,0 +1,119 @@
+package main
+
+// board.go implements the /chess/board.svg and /chess/board.png endpoints,
+// which draw a position as a board diagram that can be embedded in a page.
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"romanziske/engine"
+	"romanziske/render"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Determine if the string is a move in UCI format, without checking if it's
+// legal in any position.
+func isCoordinateMove(moveAsString string) bool {
+	if len(moveAsString) != 4 && len(moveAsString) != 5 {
+		return false
+	}
+	for index := 0; index < 4; index += 2 {
+		if moveAsString[index] < 'a' || moveAsString[index] > 'h' ||
+			moveAsString[index+1] < '1' || moveAsString[index+1] > '8' {
+			return false
+		}
+	}
+	return len(moveAsString) == 4 || strings.ContainsRune("qrbn", rune(moveAsString[4]))
+}
+
+// Get the render options from the query parameters of the request. If any of
+// them are invalid, an error message is returned instead.
+func boardOptionsFromQuery(c *gin.Context, pos *engine.Position) (render.Options, string) {
+	opts := render.DefaultOptions()
+
+	if sizeStr, ok := c.GetQuery("size"); ok {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size < render.MinSize || size > render.MaxSize {
+			return opts, "size parameter must be an integer between " +
+				strconv.Itoa(render.MinSize) + " and " + strconv.Itoa(render.MaxSize)
+		}
+		opts.Size = size
+	}
+
+	opts.Flipped = c.Query("flip") == "true"
+	opts.Coordinates = c.DefaultQuery("coords", "true") != "false"
+
+	// The last move led to the position, so it can't be checked for
+	// legality, only that it names two squares.
+	if lastMoveStr, ok := c.GetQuery("lastmove"); ok {
+		if !isCoordinateMove(lastMoveStr) {
+			return opts, "lastmove parameter is not a move in UCI format"
+		}
+		opts.LastMove = engine.NewMove(
+			engine.CoordinateToPos(lastMoveStr[:2]),
+			engine.CoordinateToPos(lastMoveStr[2:4]),
+			engine.Quiet, engine.NoFlag,
+		)
+	}
+
+	// The arrows are a principal variation, so each move must be legal in
+	// the position reached by the moves before it. The moves can be
+	// separated by either commas or spaces, like the moves of /chess/apply.
+	arrowsStr := strings.Replace(c.Query("arrows"), ",", " ", -1)
+	line := pos.Copy()
+	for index, moveAsString := range strings.Fields(arrowsStr) {
+		if index == render.MaxArrows {
+			return opts, "arrows parameter can have at most " + strconv.Itoa(render.MaxArrows) + " moves"
+		}
+
+		move, legal := engine.ParseLegalMove(&line, moveAsString)
+		if !legal {
+			return opts, "illegal move " + moveAsString + " in arrows parameter"
+		}
+		opts.Arrows = append(opts.Arrows, move)
+		line.MakeMove(move)
+	}
+
+	return opts, ""
+}
+
+// Add a route drawing the position given by the request as a board diagram,
+// in the format of the given render function.
+func setupBoardRoute(r *gin.Engine, path, contentType string, draw func(io.Writer, *engine.Position, render.Options) error) {
+	r.GET(path, func(c *gin.Context) {
+		var pos engine.Position
+		if !loadFENQuery(c, &pos) {
+			return
+		}
+
+		opts, errorMessage := boardOptionsFromQuery(c, &pos)
+		if errorMessage != "" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": errorMessage,
+			})
+			return
+		}
+
+		var buffer bytes.Buffer
+		if err := draw(&buffer, &pos, opts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "failed to render board: " + err.Error(),
+			})
+			return
+		}
+
+		// The same query always draws the same board, so it can be
+		// cached by the dashboards embedding it.
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.Data(http.StatusOK, contentType, buffer.Bytes())
+	})
+}
+
+func setupBoardRoutes(r *gin.Engine) {
+	setupBoardRoute(r, "/chess/board.svg", "image/svg+xml", render.SVG)
+	setupBoardRoute(r, "/chess/board.png", "image/png", render.PNG)
+}
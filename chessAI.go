@@ -8,9 +8,13 @@ package main
 import "C"
 
 import (
-	"net/http"
+	"context"
+	"flag"
+	"log"
+	"os"
 	"romanziske/engine"
-	"strconv"
+	"romanziske/lichess"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -36,49 +40,125 @@ var stopSearch bool = false
 
 var tt = NewTranspositionTable()
 
+// The engine's own search, used by the HTTP API. Only one search can run
+// at a time, so access to it, and to the NNUE based search, is guarded by
+// a mutex.
+var engineSearch engine.Search
+var engineSearchMutex sync.Mutex
+
+func init() {
+	engineSearch.TT.Resize(engine.DefaultTTSize)
+	engineSearch.Report = ignoreSearchInfo
+}
+
 func main() {
-	r := setupRouter()
-	// Listen and Server in 0.0.0.0:8080
-	r.Run(":8080")
+	bookPath := flag.String("book", "", "path to a polyglot opening book used by the HTTP API")
+	flag.StringVar(&adminToken, "admin-token", os.Getenv("BLUNDER_ADMIN_TOKEN"), "token enabling the admin endpoints of the HTTP API")
+	flag.StringVar(&hashFilePath, "hash-file", DefaultHashFile, "file the admin endpoints save the transposition table to")
+	flag.Parse()
+
+	// Run one of the engine's protocols if asked to, otherwise
+	// serve the HTTP API.
+	switch flag.Arg(0) {
+	case "cli":
+		engine.RunCommLoop()
+	case "uci":
+		var inter engine.UCIInterface
+		inter.UCILoop()
+	case "xboard":
+		var inter engine.XBoardInterface
+		inter.XBoardLoop()
+	case "bot":
+		runBot(flag.Args()[1:])
+	case "bench":
+		runBench(flag.Args()[1:])
+	default:
+		if *bookPath != "" {
+			book, err := engine.LoadPolyglotFile(*bookPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			openingBook = book
+		}
+
+		r := setupRouter()
+		// Listen and Server in 0.0.0.0:8080
+		r.Run(":8080")
+	}
 }
 
-func setupRouter() *gin.Engine {
-	r := gin.Default()
+// Play on Lichess as a bot, using the API token of a bot account given
+// either as a flag or through the LICHESS_TOKEN environment variable.
+func runBot(args []string) {
+	flags := flag.NewFlagSet("bot", flag.ExitOnError)
+	token := flags.String("token", os.Getenv("LICHESS_TOKEN"), "API token of the bot account")
+	bookPath := flags.String("book", "", "path to a polyglot opening book")
+	hashSize := flags.Uint64("hash", engine.DefaultTTSize, "size of the transposition table in MB")
+	minInitial := flags.Int("min-initial", 0, "minimum initial clock time, in seconds, of accepted challenges")
+	maxInitial := flags.Int("max-initial", 0, "maximum initial clock time, in seconds, of accepted challenges")
+	rated := flags.Bool("rated", true, "accept rated challenges")
+	casual := flags.Bool("casual", true, "accept casual challenges")
+	moveOverhead := flags.Int64("move-overhead", lichess.DefaultMoveOverhead, "time in milliseconds kept in reserve for each move")
+	flags.Parse(args)
+
+	if *token == "" {
+		log.Fatal("no API token given, use -token or set LICHESS_TOKEN")
+	}
 
-	r.GET("/chess/evaluate", func(c *gin.Context) {
-		fenStr, ok := c.GetQuery("fen")
+	bot := lichess.NewBot(lichess.NewClient(*token), *hashSize)
+	bot.Logger = log.New(os.Stderr, "bot: ", log.LstdFlags)
+	bot.MoveOverhead = *moveOverhead
+	bot.Rules = lichess.ChallengeRules{
+		MinInitial:   *minInitial,
+		MaxInitial:   *maxInitial,
+		AcceptRated:  *rated,
+		AcceptCasual: *casual,
+	}
 
-		if !ok {
-			c.JSON(http.StatusUnprocessableEntity, gin.H{
-				"error": "fen parameter is missing",
-			})
-			return
+	if *bookPath != "" {
+		book, err := engine.LoadPolyglotFile(*bookPath)
+		if err != nil {
+			log.Fatal(err)
 		}
+		bot.Book = book
+	}
 
-		maxTimeStr, ok := c.GetQuery("time")
+	if err := bot.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
 
-		if !ok {
-			c.JSON(http.StatusUnprocessableEntity, gin.H{
-				"error": "time parameter is missing",
-			})
-			return
-		}
+// Run the engine's bench, to get the signature of its search and a measure
+// of its speed.
+func runBench(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	depth := flags.Uint("depth", uint(engine.DefaultBenchDepth), "depth each position is searched to")
+	flags.Parse(args)
 
-		maxTimeInt, _ := strconv.Atoi(maxTimeStr)
+	if *depth == 0 || *depth > uint(engine.MaxPly) {
+		log.Fatalf("bench depth should be between 1 and %d", engine.MaxPly)
+	}
+	engine.RunBench(uint8(*depth))
+}
 
-		move := search(fenStr, maxTimeInt)
-		elapsed := time.Since(start)
-		c.JSON(http.StatusOK, gin.H{
-			"bestMove": move.String(),
-			"time":     elapsed.String(),
-		})
-	})
+func setupRouter() *gin.Engine {
+	r := gin.Default()
 
+	setupEvaluateRoute(r)
+	setupPositionRoutes(r)
+	setupPerftRoute(r)
+	setupBoardRoutes(r)
+	setupGameRoutes(r)
+	setupAdminRoutes(r)
 	return r
 }
 
-func search(fenStr string, searchTime int) engine.Move {
+// Search the position using the NNUE based search, for at most the given
+// number of milliseconds and the given depth. The best move found is returned,
+// along with its score and the last depth that was completely searched.
+func search(fenStr string, searchTime int64, depth int) (engine.Move, int, int) {
 	stopSearch = false
+	nodes = 0
 
 	//load NNUE
 	C.nnue_init(C.CString("./NNUE/networks/nn.nnue"))
@@ -88,23 +168,57 @@ func search(fenStr string, searchTime int) engine.Move {
 	pos.LoadFEN(fenStr)
 
 	start = time.Now()
-	maxTime = start.Add(time.Duration(searchTime) * time.Second)
-	return iterativeDeepening(pos, 20)
+	maxTime = start.Add(time.Duration(searchTime) * time.Millisecond)
+	return iterativeDeepening(pos, depth)
 }
 
-func iterativeDeepening(pos engine.Position, depth int) engine.Move {
+// Run the engine's own search with the given limits, on the position it's
+// been given. The caller must hold the engine search's mutex.
+func runEngineSearch(limits searchLimits) engine.Move {
+	// Scores in the transposition table from a different skill level were
+	// found using a different evaluation, so they can't be reused.
+	if limits.skill != engineSearch.Skill {
+		engineSearch.TT.Clear()
+		engineSearch.Skill = limits.skill
+	}
+
+	engineSearch.Timer.TimeLeft = engine.NoValue
+	engineSearch.Timer.Increment = engine.NoValue
+	engineSearch.Timer.MovesToGo = engine.NoValue
+	engineSearch.Timer.SetHardTimeForMove(limits.moveTime)
+
+	engineSearch.SpecifiedDepth = limits.depth
+	engineSearch.SpecifiedNodes = limits.nodes
+	engineSearch.SearchMoves = nil
+
+	return engineSearch.Search()
+}
+
+// Discard the statistics of a search iteration, so nothing is printed
+// while serving the HTTP API.
+func ignoreSearchInfo(info engine.SearchInfo) {}
+
+func iterativeDeepening(pos engine.Position, depth int) (engine.Move, int, int) {
 	var bestMove engine.Move
+	var bestValue, completedDepth int
 	for level := 1; level <= depth; level++ {
-		bestMove = root(pos, level, -MAXVALUE, MAXVALUE)
+		move, value := root(pos, level, -MAXVALUE, MAXVALUE)
+
+		// The first iteration is always used, so there's a move to play
+		// even if it was cut short.
+		if stopSearch && level > 1 {
+			break
+		}
 
+		bestMove, bestValue, completedDepth = move, value, level
 		if stopSearch {
 			break
 		}
 	}
-	return bestMove
+	return bestMove, bestValue, completedDepth
 }
 
-func root(pos engine.Position, depth int, alpha int, beta int) engine.Move {
+func root(pos engine.Position, depth int, alpha int, beta int) (engine.Move, int) {
 	nodes += 1
 	bestValue := -MAXVALUE
 
@@ -112,17 +226,19 @@ func root(pos engine.Position, depth int, alpha int, beta int) engine.Move {
 		checkTime()
 	}
 
-	moves := engine.GenMoves(&pos)
+	// A checkmated or stalemated side has no move to return.
+	moves := engine.GenLegalMoves(&pos)
+	if moves.Count == 0 {
+		return engine.NullMove, bestValue
+	}
+
 	bestMove := moves.Moves[0]
 	ScoreMoves(pos, &moves)
 	for index := 0; index < int(moves.Count); index++ {
 		SortMoves(index, &moves)
 		move := moves.Moves[index]
 
-		if !pos.MakeMove(move) {
-			pos.UnmakeMove(move)
-			continue
-		}
+		pos.MakeMove(move)
 
 		value := -negamax(pos, depth-1, -beta, -alpha)
 		pos.UnmakeMove(move)
@@ -142,11 +258,11 @@ func root(pos engine.Position, depth int, alpha int, beta int) engine.Move {
 		}
 
 		if stopSearch {
-			return bestMove
+			return bestMove, bestValue
 		}
 	}
 
-	return bestMove
+	return bestMove, bestValue
 }
 
 func negamax(pos engine.Position, depth int, alpha int, beta int) int {
@@ -171,16 +287,13 @@ func negamax(pos engine.Position, depth int, alpha int, beta int) int {
 	}
 
 	value := -MAXVALUE
-	moves := engine.GenMoves(&pos)
+	moves := engine.GenLegalMoves(&pos)
 	ScoreMoves(pos, &moves)
 	for index := 0; index < int(moves.Count); index++ {
 		SortMoves(index, &moves)
 		move := moves.Moves[index]
 
-		if !pos.MakeMove(move) {
-			pos.UnmakeMove(move)
-			continue
-		}
+		pos.MakeMove(move)
 
 		value = Max(value, -negamax(pos, depth-1, -beta, -alpha))
 		pos.UnmakeMove(move)
@@ -216,7 +329,7 @@ func negamax(pos engine.Position, depth int, alpha int, beta int) int {
 
 	var flag int
 	if value <= tempAlpha {
-		flag = ExactFlag
+		flag = UpperFlag
 	} else if value >= beta {
 		flag = LowerFlag
 	} else {
@@ -249,7 +362,7 @@ func quiesce(pos engine.Position, depth int, alpha int, beta int) int {
 		return alpha
 	}
 
-	moves := engine.GenMoves(&pos)
+	moves := engine.GenLegalMoves(&pos)
 	ScoreMoves(pos, &moves)
 	for index := 0; index < int(moves.Count); index++ {
 		SortMoves(index, &moves)
@@ -260,10 +373,7 @@ func quiesce(pos engine.Position, depth int, alpha int, beta int) int {
 			continue
 		}
 
-		if !pos.MakeMove(move) {
-			pos.UnmakeMove(move)
-			continue
-		}
+		pos.MakeMove(move)
 
 		value := -quiesce(pos, depth-1, -beta, -alpha)
 		pos.UnmakeMove(move)
@@ -0,0 +1,25 @@
+package engine
+
+import "testing"
+
+// The bench is only useful as a signature if searching the same positions
+// always searches the same number of nodes, even after other searches have
+// left their state behind.
+func TestBenchDeterministic(t *testing.T) {
+	positions := BenchPositions[:8]
+	first := Bench(positions, 6)
+	second := Bench(positions, 6)
+
+	if first.Nodes == 0 {
+		t.Fatalf("bench searched no nodes")
+	}
+
+	for index := range positions {
+		if first.PositionNodes[index] != second.PositionNodes[index] {
+			t.Errorf(
+				"bench searched %d nodes in position %s the first time, but %d the second time",
+				first.PositionNodes[index], positions[index], second.PositionNodes[index],
+			)
+		}
+	}
+}
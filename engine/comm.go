@@ -27,11 +27,14 @@ const (
 	HelpMessage     = `
 Options:
 - uci: Start the UCI protocol
+- xboard: Start the XBoard protocol
 - perft <DEPTH>: Run perft up to <DEPTH>
 - dperft <DEPTH>: Run divide perft up to <DEPTH>
+- perftsuite <FILE>: Check the node counts of a perft suite in the EPD format, narrowing down any mismatches
 - fen <FEN>: Load a fen string given by <FEN>
 - print: Display the current board state
-- eval: Display the static evaluation of the current position
+- bench <DEPTH>: Search the bench positions to <DEPTH>, or the default depth if none is given
+- eval: Display the static evaluation of the current position, broken down into its terms
 - options: Display this help message
 - quit: Quit the program
 
@@ -52,7 +55,7 @@ func perftCommand(pos *Position, command string) {
 			fmt.Println("\nNodes:", nodes)
 			elapsed := time.Since(start)
 			fmt.Printf("Time: %vms\n", elapsed.Milliseconds())
-			fmt.Printf("Nps: %d\n", int(float64(nodes)/elapsed.Seconds()))
+			fmt.Printf("Nps: %d\n", NodesPerSecond(nodes, elapsed))
 		} else {
 			fmt.Printf("Depth limit for perft is %d", PerftDepthLimit)
 		}
@@ -75,7 +78,7 @@ func dividePerftCommand(pos *Position, command string) {
 			fmt.Println("\nNodes:", nodes)
 			elapsed := time.Since(start)
 			fmt.Printf("Time: %vms\n", elapsed.Milliseconds())
-			fmt.Printf("Nps: %d\n\n", int(float64(nodes)/elapsed.Seconds()))
+			fmt.Printf("Nps: %d\n\n", NodesPerSecond(nodes, elapsed))
 		} else {
 			fmt.Printf("Depth limit for perft is %d\n", PerftDepthLimit)
 		}
@@ -85,6 +88,56 @@ func dividePerftCommand(pos *Position, command string) {
 	}
 }
 
+// Run the perft suite command in the command line mode
+func perftSuiteCommand(command string) {
+	command = strings.TrimPrefix(command, "perftsuite ")
+	command = strings.TrimSpace(command)
+
+	perftTests, err := LoadPerftSuite(command)
+	if err != nil {
+		fmt.Println("Couldn't load the perft suite:", err)
+		return
+	}
+
+	start := time.Now()
+	mismatches := CheckPerftSuite(perftTests, NewPerftTable(DefaultPerftTableSize), 0)
+	elapsed := time.Since(start)
+
+	fmt.Println()
+	for _, mismatch := range mismatches {
+		fmt.Println(mismatch)
+	}
+
+	fmt.Printf("Positions: %d\n", len(perftTests))
+	fmt.Printf("Mismatches: %d\n", len(mismatches))
+	fmt.Printf("Time: %vms\n\n", elapsed.Milliseconds())
+}
+
+// Run the bench command in the command line mode
+func benchCommand(command string) {
+	command = strings.TrimPrefix(command, "bench")
+	command = strings.TrimSpace(command)
+
+	depth := int(DefaultBenchDepth)
+	if command != "" {
+		var err error
+		depth, err = strconv.Atoi(command)
+		if err != nil {
+			fmt.Println("Bench depth should be an integer")
+			return
+		}
+	}
+
+	if depth < 1 || depth > MaxPly {
+		fmt.Printf("Bench depth should be between 1 and %d\n", MaxPly)
+		return
+	}
+
+	fmt.Println()
+	RunBench(uint8(depth))
+	fmt.Println()
+}
+
 // Run the fen command in the command line mode
 func fenCommand(pos *Position, command string) {
 	command = strings.TrimPrefix(command, "fen ")
@@ -113,10 +166,14 @@ func RunCommLoop() {
 		command, _ := reader.ReadString('\n')
 		command = strings.Replace(command, "\r\n", "\n", -1)
 
-		if strings.HasPrefix(command, "perft") {
+		if strings.HasPrefix(command, "perftsuite ") {
+			perftSuiteCommand(command)
+		} else if strings.HasPrefix(command, "perft") {
 			perftCommand(&inter.Search.Pos, command)
 		} else if strings.HasPrefix(command, "dperft ") {
 			dividePerftCommand(&inter.Search.Pos, command)
+		} else if command == "bench\n" || strings.HasPrefix(command, "bench ") {
+			benchCommand(command)
 		} else if strings.HasPrefix(command, "fen ") {
 			fenCommand(&inter.Search.Pos, command)
 		} else if command == "print\n" {
@@ -124,10 +181,14 @@ func RunCommLoop() {
 		} else if command == "uci\n" {
 			inter.UCILoop()
 			break
+		} else if command == "xboard\n" {
+			var xboardInter XBoardInterface
+			xboardInter.XBoardLoop()
+			break
 		} else if command == "options\n" {
 			fmt.Print(HelpMessage)
 		} else if command == "eval\n" {
-			fmt.Println(EvaluatePos(&inter.Search.Pos), "cp")
+			fmt.Print(TraceEvaluation(&inter.Search.Pos))
 		} else if command == "quit\n" {
 			break
 		} else {
@@ -0,0 +1,318 @@
+package engine
+
+// endgames.go implements the evaluation of endgames the general evaluation
+// doesn't understand. Endgames are recognized by their material signature,
+// the number of each kind of piece each side has. Some endgames have their own
+// evaluation function, which replaces the general evaluation, and others have
+// a scale factor function, which scales the end-game score of the general
+// evaluation down when the stronger side can't make progress.
+
+import (
+	"strings"
+)
+
+const (
+	// The score of an endgame which is a known win, before the bonuses
+	// for making progress towards the win. It's well below the scores
+	// of checkmates, so the search still prefers finding the mate.
+	KnownWin int16 = 2000
+
+	// The scale factors the end-game score is multiplied by, out of
+	// ScaleFactorNormal.
+	ScaleFactorDraw   int16 = 0
+	ScaleFactorNormal int16 = 64
+
+	// The highest sum of phase values a position can have to be
+	// recognized as an endgame.
+	EndgamePhaseLimit int16 = 6
+)
+
+// The scale factors for endgames with bishops of opposite colors, when the
+// bishops are the only pieces left, and when there are other pieces left.
+var OppositeBishopsScaleFactor int16 = 22
+var OppositeBishopsWithPiecesScaleFactor int16 = 48
+
+// The bonuses for driving the weak king towards the edge of the board, or to
+// the right corner, and for bringing the strong king close to it.
+var PushToEdgeBonus int16 = 20
+var PushToCornerBonus int16 = 24
+var PushCloseBonus int16 = 10
+
+// A material signature, which holds the number of pieces of each type for
+// each side, in four bits each.
+type MaterialKey uint64
+
+// An evaluation function for an endgame, which scores the position from the
+// perspective of the strong side, or returns false if it has no knowledge of
+// the position, in which case the general evaluation is used.
+type endgameEvaluator func(pos *Position, strong uint8) (int16, bool)
+
+// A scale factor function for an endgame, which returns the scale factor for
+// the strong side's end-game score.
+type scaleFunction func(pos *Position, strong uint8) int16
+
+// An endgame recognized by its material signature, with the side which has
+// the advantage in it.
+type Endgame struct {
+	Name     string
+	strong   uint8
+	evaluate endgameEvaluator
+	scale    scaleFunction
+}
+
+// The endgames with specialized evaluation or scale factor functions, indexed
+// by their material signatures.
+var Endgames = map[MaterialKey]Endgame{}
+
+// Get the shift of the four bits holding the number of pieces of the given
+// type and color in a material signature.
+func materialShift(pieceType, color uint8) uint {
+	return uint(color*5+pieceType) * 4
+}
+
+// Get the material signature of a position.
+func materialKey(pos *Position) (key MaterialKey) {
+	for color := Black; color <= White; color++ {
+		for pieceType := Pawn; pieceType <= Queen; pieceType++ {
+			count := MaterialKey(pos.PieceBB[color][pieceType].CountBits())
+			key |= count << materialShift(pieceType, color)
+		}
+	}
+	return key
+}
+
+// Parse a material signature written as the pieces of the strong side and of
+// the weak side, separated by a "v", such as "KRvKP", where strong is the color
+// of the strong side.
+func parseMaterialKey(signature string, strong uint8) (key MaterialKey) {
+	sides := strings.Split(signature, "v")
+	for index, pieces := range sides {
+		color := strong
+		if index == 1 {
+			color = strong ^ 1
+		}
+
+		for _, char := range pieces {
+			if char != 'K' {
+				pieceType := CharToPiece[byte(char)].Type
+				key += 1 << materialShift(pieceType, color)
+			}
+		}
+	}
+	return key
+}
+
+// Add an endgame to the table of endgames, for both sides as the strong side.
+func addEndgame(signature string, evaluate endgameEvaluator, scale scaleFunction) {
+	for strong := Black; strong <= White; strong++ {
+		Endgames[parseMaterialKey(signature, strong)] = Endgame{
+			Name:     signature,
+			strong:   strong,
+			evaluate: evaluate,
+			scale:    scale,
+		}
+	}
+}
+
+// Look up the endgame of the position, if it's one with a specialized evaluation
+// or scale factor function.
+func probeEndgame(pos *Position) (Endgame, bool) {
+	if pos.Phase > EndgamePhaseLimit {
+		return Endgame{}, false
+	}
+	endgame, ok := Endgames[materialKey(pos)]
+	return endgame, ok
+}
+
+// Get the scale factor for the end-game score of a position, given the endgame
+// it's in, which is empty if it's not a recognized endgame, and the side with
+// the better end-game score.
+func scaleFactor(pos *Position, endgame *Endgame, strong uint8) int16 {
+	if endgame.scale != nil && endgame.strong == strong {
+		return endgame.scale(pos, strong)
+	}
+
+	// Endgames with bishops of opposite colors are often drawn, even when
+	// one side has a pawn or two more.
+	whiteBishops, blackBishops := pos.PieceBB[White][Bishop], pos.PieceBB[Black][Bishop]
+	if pos.Phase <= EndgamePhaseLimit && whiteBishops.CountBits() == 1 && blackBishops.CountBits() == 1 &&
+		sqIsDark(whiteBishops.Msb()) != sqIsDark(blackBishops.Msb()) {
+
+		if pos.Phase == 2*BishopPhase {
+			return OppositeBishopsScaleFactor
+		}
+		return OppositeBishopsWithPiecesScaleFactor
+	}
+
+	return ScaleFactorNormal
+}
+
+// Get how close a square is to the edge of the board, from 0 in the center,
+// to 6 in the corners.
+func pushToEdge(sq uint8) int16 {
+	file, rank := int16(FileOf(sq)), int16(RankOf(sq))
+	fileDistance := 3 - min16(file, 7-file)
+	rankDistance := 3 - min16(rank, 7-rank)
+	return fileDistance + rankDistance
+}
+
+// Get how close two squares are, from 0 on opposite corners, to 7 when
+// they're next to each other.
+func pushClose(sq1, sq2 uint8) int16 {
+	return 7 - distance(sq1, sq2)
+}
+
+// Evaluate king, bishop and knight against king. The strong side can only mate
+// in a corner the bishop controls, so the weak king is driven towards it.
+func evalKBNK(pos *Position, strong uint8) (int16, bool) {
+	strongKingSq := pos.PieceBB[strong][King].Msb()
+	weakKingSq := pos.PieceBB[strong^1][King].Msb()
+
+	corner1, corner2 := uint8(A8), uint8(H1)
+	if sqIsDark(pos.PieceBB[strong][Bishop].Msb()) {
+		corner1, corner2 = A1, H8
+	}
+
+	cornerDistance := min16(distance(weakKingSq, corner1), distance(weakKingSq, corner2))
+	score := KnownWin + PieceValueEG[Knight] + PieceValueEG[Bishop]
+	score += (7-cornerDistance)*PushToCornerBonus + pushClose(strongKingSq, weakKingSq)*PushCloseBonus
+	return score, true
+}
+
+// Evaluate king and queen against king and rook, which is a win for the queen,
+// though it takes many moves. The weak king is driven to the edge.
+func evalKQKR(pos *Position, strong uint8) (int16, bool) {
+	strongKingSq := pos.PieceBB[strong][King].Msb()
+	weakKingSq := pos.PieceBB[strong^1][King].Msb()
+
+	score := PieceValueEG[Queen] - PieceValueEG[Rook]
+	score += pushToEdge(weakKingSq)*PushToEdgeBonus + pushClose(strongKingSq, weakKingSq)*PushCloseBonus
+	return score, true
+}
+
+// Evaluate king and rook against king and pawn. The rook wins if the strong king
+// is in front of the pawn, or if the weak king and pawn are too far apart, and
+// it's often a draw if the pawn is far advanced with its king supporting it.
+func evalKRKP(pos *Position, strong uint8) (int16, bool) {
+	weak := strong ^ 1
+	strongKingSq := pos.PieceBB[strong][King].Msb()
+	weakKingSq := pos.PieceBB[weak][King].Msb()
+	rookSq := pos.PieceBB[strong][Rook].Msb()
+	pawnSq := pos.PieceBB[weak][Pawn].Msb()
+
+	pushSq := uint8(int8(pawnSq) + pawnPush(weak))
+	queeningSq := FileOf(pawnSq) + 56
+	if weak == Black {
+		queeningSq = FileOf(pawnSq)
+	}
+
+	strongToMove := int16(0)
+	weakToMove := int16(0)
+	if pos.SideToMove == strong {
+		strongToMove = 1
+	} else {
+		weakToMove = 1
+	}
+
+	// The ranks of the kings relative to the strong side.
+	strongKingRank := FlipRank[strong][RankOf(strongKingSq)]
+	weakKingRank := FlipRank[strong][RankOf(weakKingSq)]
+
+	inFront := FileOf(strongKingSq) == FileOf(pawnSq) &&
+		FlipRank[weak][RankOf(strongKingSq)] > FlipRank[weak][RankOf(pawnSq)]
+
+	if inFront {
+		return PieceValueEG[Rook] - distance(strongKingSq, pawnSq), true
+	}
+
+	if distance(weakKingSq, pawnSq) >= 3+weakToMove && distance(weakKingSq, rookSq) >= 3 {
+		return PieceValueEG[Rook] - distance(strongKingSq, pawnSq), true
+	}
+
+	if weakKingRank <= Rank3 && distance(weakKingSq, pawnSq) == 1 &&
+		strongKingRank >= Rank4 && distance(strongKingSq, pawnSq) > 2+strongToMove {
+		return 80 - 8*distance(strongKingSq, pawnSq), true
+	}
+
+	return 200 - 8*(distance(strongKingSq, pushSq)-distance(weakKingSq, pushSq)-distance(pawnSq, queeningSq)), true
+}
+
+// Evaluate king and pawns against king using the rule of the square: a pawn
+// the weak king can't catch promotes, as long as its own king isn't in the
+// way. Otherwise, the general evaluation is used.
+func evalKPsK(pos *Position, strong uint8) (int16, bool) {
+	weak := strong ^ 1
+	strongKingSq := pos.PieceBB[strong][King].Msb()
+	weakKingSq := pos.PieceBB[weak][King].Msb()
+
+	weakToMove := int16(0)
+	if pos.SideToMove == weak {
+		weakToMove = 1
+	}
+
+	pawns := pos.PieceBB[strong][Pawn]
+	for pawns != 0 {
+		sq := pawns.PopBit()
+		rank := FlipRank[strong][RankOf(sq)]
+
+		queeningSq := FileOf(sq) + 56
+		if strong == Black {
+			queeningSq = FileOf(sq)
+		}
+
+		// Pawns which haven't moved can advance two squares at once.
+		pawnDistance := min16(int16(Rank8-rank), 5)
+		if FileOf(strongKingSq) == FileOf(sq) && FlipRank[strong][RankOf(strongKingSq)] > rank {
+			continue
+		}
+
+		if distance(weakKingSq, queeningSq)-weakToMove > pawnDistance {
+			score := KnownWin + PieceValueEG[Pawn]*int16(pos.PieceBB[strong][Pawn].CountBits()) + int16(rank)*PushCloseBonus
+			return score, true
+		}
+	}
+
+	return 0, false
+}
+
+// Scale down the end-game score of king, bishop and rook pawns against king when
+// the bishop doesn't control the queening corner, and the weak king can reach
+// it, which is a draw.
+func scaleKBPsK(pos *Position, strong uint8) int16 {
+	pawns := pos.PieceBB[strong][Pawn]
+	for _, file := range []uint8{FileA, FileH} {
+		if pawns&^MaskFile[file] != 0 {
+			continue
+		}
+
+		queeningSq := file + 56
+		if strong == Black {
+			queeningSq = file
+		}
+
+		bishopSq := pos.PieceBB[strong][Bishop].Msb()
+		weakKingSq := pos.PieceBB[strong^1][King].Msb()
+		if sqIsDark(bishopSq) != sqIsDark(queeningSq) && distance(weakKingSq, queeningSq) <= 1 {
+			return ScaleFactorDraw
+		}
+	}
+	return ScaleFactorNormal
+}
+
+// Scale down the end-game score of king and two knights against king, which
+// can't be won without help from the weak side.
+func scaleKNNK(pos *Position, strong uint8) int16 {
+	return ScaleFactorDraw
+}
+
+func init() {
+	addEndgame("KBNvK", evalKBNK, nil)
+	addEndgame("KQvKR", evalKQKR, nil)
+	addEndgame("KRvKP", evalKRKP, nil)
+	addEndgame("KNNvK", nil, scaleKNNK)
+
+	for pawns := 1; pawns <= 8; pawns++ {
+		addEndgame("K"+strings.Repeat("P", pawns)+"vK", evalKPsK, nil)
+		addEndgame("KB"+strings.Repeat("P", pawns)+"vK", nil, scaleKBPsK)
+	}
+}
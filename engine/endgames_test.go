@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"testing"
+)
+
+// Evaluate the position given by a FEN string.
+func evaluateFEN(fen string) int16 {
+	var pos Position
+	pos.LoadFEN(fen)
+	return EvaluatePos(&pos)
+}
+
+// Test that the material signatures of positions are recognized as the
+// endgames they are, for either side as the strong side.
+func TestMaterialKey(t *testing.T) {
+	tests := []struct {
+		fen       string
+		signature string
+		strong    uint8
+	}{
+		{"8/8/8/4k3/8/8/8/KBN5 w - - 0 1", "KBNvK", White},
+		{"kbn5/8/8/4K3/8/8/8/8 w - - 0 1", "KBNvK", Black},
+		{"8/8/8/4k3/8/8/2p5/K6R w - - 0 1", "KRvKP", White},
+		{"8/1p6/8/4k3/8/8/P1P5/K7 b - - 0 1", "KPvKPP", Black},
+	}
+
+	for _, test := range tests {
+		var pos Position
+		pos.LoadFEN(test.fen)
+		if key := parseMaterialKey(test.signature, test.strong); materialKey(&pos) != key {
+			t.Errorf("%s: material key is 0x%x instead of 0x%x for %s", test.fen, materialKey(&pos), key, test.signature)
+		}
+	}
+}
+
+// Test that the endgame evaluation functions and scale factors score endgames
+// as expected, and the same for either side as the strong side.
+func TestEndgames(t *testing.T) {
+	tests := []struct {
+		name     string
+		fen      string
+		min, max int16
+	}{
+		{"KBNK with the king in the right corner", "8/8/8/8/3KN3/8/8/k1B5 w - - 0 1", 2700, Checkmate},
+		{"KBNK with the king in the wrong corner", "k7/8/8/8/3KN3/8/8/2B5 w - - 0 1", KnownWin, 2700},
+		{"KQKR", "8/8/2k5/8/8/2r5/8/3QK3 w - - 0 1", PieceValueEG[Queen] - PieceValueEG[Rook], KnownWin},
+		{"KRKP with the king in front of the pawn", "8/8/8/8/8/8/2p5/k1K4R w - - 0 1", 400, KnownWin},
+		{"KRKP with the pawn supported far advanced", "K7/8/8/8/8/8/1kp5/7R w - - 0 1", -100, 100},
+		{"KPK with the pawn outside the square", "7k/8/8/P7/8/8/8/7K w - - 0 1", KnownWin, Checkmate},
+		{"KPK with the pawn inside the square", "8/2k5/8/P7/8/8/8/7K w - - 0 1", -KnownWin, KnownWin},
+		{"KBPK with the wrong bishop", "k7/8/8/8/8/8/P7/K1B5 w - - 0 1", -50, 50},
+		{"KBPK with the right bishop", "k7/8/8/8/8/8/P7/KB6 w - - 0 1", 300, KnownWin},
+		{"KNNK", "8/8/4k3/8/8/8/8/KNN5 w - - 0 1", -50, 50},
+	}
+
+	for _, test := range tests {
+		score := evaluateFEN(test.fen)
+		if score < test.min || score > test.max {
+			t.Errorf("%s: scored %d, instead of between %d and %d", test.name, score, test.min, test.max)
+		}
+
+		if mirroredScore := evaluateFEN(mirrorFEN(test.fen)); mirroredScore != score {
+			t.Errorf("%s: scored %d, but the mirrored position scored %d", test.name, score, mirroredScore)
+		}
+	}
+}
+
+// Test that endgames with bishops of opposite colors are scaled down.
+func TestOppositeBishopsScaleFactor(t *testing.T) {
+	var pos Position
+	pos.LoadFEN("8/5k2/3b4/8/3P4/8/4B3/4K3 w - - 0 1")
+	if trace := TraceEvaluation(&pos); trace.ScaleFactor != OppositeBishopsScaleFactor {
+		t.Errorf("scale factor with opposite colored bishops is %d instead of %d", trace.ScaleFactor, OppositeBishopsScaleFactor)
+	}
+
+	pos.LoadFEN("8/5k2/4b3/8/3P4/8/4B3/4K3 w - - 0 1")
+	if trace := TraceEvaluation(&pos); trace.ScaleFactor != ScaleFactorNormal {
+		t.Errorf("scale factor with same colored bishops is %d instead of %d", trace.ScaleFactor, ScaleFactorNormal)
+	}
+}
@@ -0,0 +1,118 @@
+package engine
+
+// evalcache.go implements the two caches which save the evaluation from redoing
+// work during a search. The pawn hash table stores the scores of the pawn
+// structure, indexed by the zobrist hash of the pawns, since pawns move far less
+// often than the other pieces. The eval cache stores the static evaluation of
+// whole positions, which are reached again through transpositions, re-searches,
+// and the quiescence search.
+
+const (
+	// The number of entries in the pawn hash table (384KB) and in the
+	// eval cache (512KB). Both must be powers of two.
+	PawnTableSize = 1 << 14
+	EvalCacheSize = 1 << 16
+
+	// The bits of an eval cache entry which hold the score. The other
+	// bits hold the upper bits of the position's hash.
+	evalCacheScoreMask uint64 = 0xffff
+)
+
+// An entry of the pawn hash table, which stores the pawn structure scores of
+// each side for the pawns with the given hash, and which of them are passed.
+type PawnEntry struct {
+	Hash        uint64
+	MGScores    [2]int16
+	EGScores    [2]int16
+	PassedPawns Bitboard
+}
+
+// A hash table of pawn structure scores.
+type PawnTable struct {
+	entries []PawnEntry
+}
+
+// Allocate the pawn hash table, if it hasn't been already.
+func (pt *PawnTable) allocate() {
+	if pt.entries == nil {
+		pt.entries = make([]PawnEntry, PawnTableSize)
+	}
+}
+
+// Get the entry for the given pawn hash, or nil if the table doesn't have one.
+// A nil or unallocated table never has an entry.
+func (pt *PawnTable) Probe(hash uint64) *PawnEntry {
+	if pt == nil || pt.entries == nil {
+		return nil
+	}
+
+	entry := &pt.entries[hash&uint64(len(pt.entries)-1)]
+	if entry.Hash != hash {
+		return nil
+	}
+	return entry
+}
+
+// Store the pawn structure scores of each side, and the passed pawns, for the
+// given pawn hash.
+func (pt *PawnTable) Store(hash uint64, mgScores, egScores [2]int16, passedPawns Bitboard) {
+	if pt == nil || pt.entries == nil {
+		return
+	}
+
+	entry := &pt.entries[hash&uint64(len(pt.entries)-1)]
+	entry.Hash = hash
+	entry.MGScores = mgScores
+	entry.EGScores = egScores
+	entry.PassedPawns = passedPawns
+}
+
+// Clear the pawn hash table.
+func (pt *PawnTable) Clear() {
+	for idx := range pt.entries {
+		pt.entries[idx] = PawnEntry{}
+	}
+}
+
+// A cache of static evaluations. Each entry packs the upper 48 bits of a
+// position's hash together with its score into a single 64-bit word. The lower
+// 16 bits of the hash are used to index the cache, so they don't need to be
+// stored.
+type EvalCache struct {
+	entries []uint64
+}
+
+// Allocate the eval cache, if it hasn't been already.
+func (ec *EvalCache) allocate() {
+	if ec.entries == nil {
+		ec.entries = make([]uint64, EvalCacheSize)
+	}
+}
+
+// Get the cached score of the position with the given hash, if there is one.
+func (ec *EvalCache) Probe(hash uint64) (int16, bool) {
+	if ec.entries == nil {
+		return 0, false
+	}
+
+	entry := ec.entries[hash&uint64(len(ec.entries)-1)]
+	if entry&^evalCacheScoreMask != hash&^evalCacheScoreMask {
+		return 0, false
+	}
+	return int16(uint16(entry & evalCacheScoreMask)), true
+}
+
+// Store the score of the position with the given hash.
+func (ec *EvalCache) Store(hash uint64, score int16) {
+	if ec.entries == nil {
+		return
+	}
+	ec.entries[hash&uint64(len(ec.entries)-1)] = (hash &^ evalCacheScoreMask) | uint64(uint16(score))
+}
+
+// Clear the eval cache.
+func (ec *EvalCache) Clear() {
+	for idx := range ec.entries {
+		ec.entries[idx] = 0
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"testing"
+)
+
+// Test that the zobrist hash and the pawn hash of a position, which are updated
+// incrementally as moves are made and unmade, match the hashes generated from
+// scratch.
+func TestIncrementalHashes(t *testing.T) {
+	var pos Position
+	for _, perftTest := range loadPerftSuite() {
+		pos.LoadFEN(perftTest.FEN)
+		checkHashes(t, &pos, 3)
+	}
+}
+
+func checkHashes(t *testing.T, pos *Position, depth uint8) {
+	if hash := Zobrist.GenHash(pos); pos.Hash != hash {
+		t.Fatalf("%s: hash is 0x%x instead of 0x%x", pos.GenFEN(), pos.Hash, hash)
+	}
+
+	if pawnHash := Zobrist.GenPawnHash(pos); pos.PawnHash != pawnHash {
+		t.Fatalf("%s: pawn hash is 0x%x instead of 0x%x", pos.GenFEN(), pos.PawnHash, pawnHash)
+	}
+
+	if depth == 0 {
+		return
+	}
+
+	moves := GenMoves(pos)
+	for index := uint8(0); index < moves.Count; index++ {
+		move := moves.Moves[index]
+		if pos.MakeMove(move) {
+			checkHashes(t, pos, depth-1)
+		}
+		pos.UnmakeMove(move)
+	}
+}
+
+// Test that evaluating positions using the pawn hash table and the eval cache
+// gives the same scores as evaluating them from scratch.
+func TestCachedEvaluation(t *testing.T) {
+	var search Search
+	search.pawnTable.allocate()
+	search.evalCache.allocate()
+
+	for _, perftTest := range loadPerftSuite() {
+		search.Pos.LoadFEN(perftTest.FEN)
+		checkCachedEvaluation(t, &search, 2)
+	}
+}
+
+func checkCachedEvaluation(t *testing.T, search *Search, depth uint8) {
+	pos := &search.Pos
+	expected := EvaluatePos(pos)
+
+	// Evaluate each position twice, so the second evaluation comes
+	// from the caches.
+	for try := 0; try < 2; try++ {
+		if score := evaluatePos(pos, &search.pawnTable, nil); score != expected {
+			t.Fatalf("%s: evaluation with the pawn hash table is %d instead of %d", pos.GenFEN(), score, expected)
+		}
+
+		if score := search.evaluate(); score != expected {
+			t.Fatalf("%s: evaluation with the eval cache is %d instead of %d", pos.GenFEN(), score, expected)
+		}
+	}
+
+	if depth == 0 {
+		return
+	}
+
+	moves := GenMoves(pos)
+	for index := uint8(0); index < moves.Count; index++ {
+		move := moves.Moves[index]
+		if pos.MakeMove(move) {
+			checkCachedEvaluation(t, search, depth-1)
+		}
+		pos.UnmakeMove(move)
+	}
+}
+
+// Test that the eval cache stores negative scores, and doesn't return the
+// score of a different position indexing the same entry.
+func TestEvalCache(t *testing.T) {
+	var cache EvalCache
+	cache.allocate()
+
+	hash := uint64(0x123456789abcdef0)
+	cache.Store(hash, -Inf)
+
+	if score, ok := cache.Probe(hash); !ok || score != -Inf {
+		t.Errorf("probing returned %d, %v instead of %d, true", score, ok, -Inf)
+	}
+
+	if _, ok := cache.Probe(hash ^ (1 << 40)); ok {
+		t.Errorf("probing a different position with the same index returned a score")
+	}
+}
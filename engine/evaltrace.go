@@ -0,0 +1,201 @@
+package engine
+
+// evaltrace.go implements tracing the evaluation, which breaks the score of a
+// position down into each of the evaluation's terms, for each side and for the
+// middle-game and end-game, and shows how the two are blended by the phase of
+// the game. It's meant for debugging and tuning the evaluation, so it doesn't
+// use the pawn hash table or the eval cache.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The terms of the evaluation a trace breaks the score down into.
+const (
+	TermMaterial uint8 = iota
+	TermPSQT
+	TermKnightMobility
+	TermBishopMobility
+	TermRookMobility
+	TermQueenMobility
+	TermPassedPawns
+	TermCandidatePassers
+	TermPasserKingDistance
+	TermIsolatedPawns
+	TermDoubledPawns
+	TermBackwardPawns
+	TermConnectedPawns
+	TermKnightOutposts
+	TermBishopPair
+	TermRookFiles
+	TermRookOn7th
+	TermThreats
+	TermHangingPieces
+	TermKingSafety
+	TermSpace
+	TermTempo
+	NumEvalTerms
+)
+
+// The names of the terms of the evaluation.
+var EvalTermNames = [NumEvalTerms]string{
+	"material",
+	"psqt",
+	"knightMobility",
+	"bishopMobility",
+	"rookMobility",
+	"queenMobility",
+	"passedPawns",
+	"candidatePassers",
+	"passerKingDistance",
+	"isolatedPawns",
+	"doubledPawns",
+	"backwardPawns",
+	"connectedPawns",
+	"knightOutposts",
+	"bishopPair",
+	"rookFiles",
+	"rookOn7th",
+	"threats",
+	"hangingPieces",
+	"kingSafety",
+	"space",
+	"tempo",
+}
+
+// The names of the colors, as displayed in a trace.
+var traceColorNames = [2]string{"Black", "White"}
+
+// The breakdown of the king safety points of a side, which are counted up for
+// the enemy attacking its king, and used to index the king attack table.
+type KingSafetyTrace struct {
+	// The number of enemy pieces attacking the king zone.
+	Attackers uint8
+
+	// The points for where the king stands, for the enemy's attacks on the
+	// king zone, and for the semi-open files next to the king.
+	SquarePoints       uint16
+	ZoneAttackPoints   uint16
+	SemiOpenFilePoints uint16
+
+	// The total points, capped at the size of the king attack table.
+	Points uint16
+}
+
+// A breakdown of the evaluation of a position.
+type EvalTrace struct {
+	// The middle-game and end-game scores of each term for each side.
+	MGScores [NumEvalTerms][2]int16
+	EGScores [NumEvalTerms][2]int16
+
+	KingSafety [2]KingSafetyTrace
+
+	// The total middle-game and end-game scores, from White's perspective,
+	// and the phase used to blend them, from 0 (middle-game) to 256 (end-game).
+	MGScore int16
+	EGScore int16
+	Phase   int16
+
+	// The specialized endgame function used to evaluate the position, or
+	// to scale the end-game score, if any, and the scale factor applied to
+	// the end-game score, out of ScaleFactorNormal. If ExactEndgame is set,
+	// the endgame function replaced the evaluation, and none of the terms
+	// were evaluated.
+	Endgame      string
+	ExactEndgame bool
+	ScaleFactor  int16
+
+	// The score of the position, from the perspective of the side to move.
+	SideToMove uint8
+	Score      int16
+}
+
+// Evaluate a position, breaking the score down into each term of the evaluation.
+func TraceEvaluation(pos *Position) (trace EvalTrace) {
+	evaluatePos(pos, nil, &trace)
+	return trace
+}
+
+// Record the material and piece-square table scores of each side in the trace.
+// The position keeps these updated together, so they're computed from scratch.
+func traceMaterial(pos *Position, trace *EvalTrace) {
+	for sq := uint8(0); sq < 64; sq++ {
+		piece := pos.Squares[sq]
+		if piece.Type != NoType {
+			trace.MGScores[TermMaterial][piece.Color] += PieceValueMG[piece.Type]
+			trace.EGScores[TermMaterial][piece.Color] += PieceValueEG[piece.Type]
+			trace.MGScores[TermPSQT][piece.Color] += PSQT_MG[piece.Type][FlipSq[piece.Color][sq]]
+			trace.EGScores[TermPSQT][piece.Color] += PSQT_EG[piece.Type][FlipSq[piece.Color][sq]]
+		}
+	}
+}
+
+// Get the score of the position from White's perspective.
+func (trace *EvalTrace) WhiteScore() int16 {
+	if trace.SideToMove == White {
+		return trace.Score
+	}
+	return -trace.Score
+}
+
+// Display the trace as a table of the terms, followed by the king safety
+// points and how the scores are blended into the final score.
+func (trace EvalTrace) String() string {
+	var sb strings.Builder
+	if trace.ExactEndgame {
+		sb.WriteString(fmt.Sprintf("Evaluated by the %s endgame function\n", trace.Endgame))
+		sb.WriteString(fmt.Sprintf("Evaluation: %d cp (side to move's perspective, %s to move)\n", trace.Score, traceColorNames[trace.SideToMove]))
+		return sb.String()
+	}
+
+	separator := "--------------------+---------------+---------------+---------------\n"
+
+	sb.WriteString("               Term |     White     |     Black     |     Total\n")
+	sb.WriteString("                    |    MG     EG  |    MG     EG  |    MG     EG\n")
+	sb.WriteString(separator)
+
+	var totals [2][2]int16
+	for term := uint8(0); term < NumEvalTerms; term++ {
+		mg, eg := trace.MGScores[term], trace.EGScores[term]
+		sb.WriteString(fmt.Sprintf(
+			"%19s | %5d  %5d  | %5d  %5d  | %5d  %5d\n",
+			EvalTermNames[term], mg[White], eg[White], mg[Black], eg[Black],
+			mg[White]-mg[Black], eg[White]-eg[Black],
+		))
+
+		for _, color := range []uint8{White, Black} {
+			totals[color][0] += mg[color]
+			totals[color][1] += eg[color]
+		}
+	}
+
+	sb.WriteString(separator)
+	sb.WriteString(fmt.Sprintf(
+		"%19s | %5d  %5d  | %5d  %5d  | %5d  %5d\n\n",
+		"total", totals[White][0], totals[White][1], totals[Black][0], totals[Black][1],
+		trace.MGScore, trace.EGScore,
+	))
+
+	sb.WriteString("King safety points (king square + zone attacks + semi-open files):\n")
+	for _, color := range []uint8{White, Black} {
+		kingSafety := trace.KingSafety[color]
+		sb.WriteString(fmt.Sprintf(
+			"  %s king: %d + %d + %d = %d, from %d attackers\n",
+			traceColorNames[color], kingSafety.SquarePoints, kingSafety.ZoneAttackPoints,
+			kingSafety.SemiOpenFilePoints, kingSafety.Points, kingSafety.Attackers,
+		))
+	}
+
+	sb.WriteString(fmt.Sprintf("\nPhase: %d/256 (0 is the middle-game, 256 the end-game)\n", trace.Phase))
+	if trace.Endgame != "" {
+		sb.WriteString(fmt.Sprintf("Endgame: %s\n", trace.Endgame))
+	}
+	sb.WriteString(fmt.Sprintf("Scale factor: %d/%d\n", trace.ScaleFactor, ScaleFactorNormal))
+	sb.WriteString(fmt.Sprintf(
+		"Score: (%d * (256 - %d) + %d * %d/%d * %d) / 256 = %d cp (White's perspective)\n",
+		trace.MGScore, trace.Phase, trace.EGScore, trace.ScaleFactor, ScaleFactorNormal, trace.Phase, trace.WhiteScore(),
+	))
+	sb.WriteString(fmt.Sprintf("Evaluation: %d cp (side to move's perspective, %s to move)\n", trace.Score, traceColorNames[trace.SideToMove]))
+	return sb.String()
+}
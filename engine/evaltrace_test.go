@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"testing"
+)
+
+// Test that the terms of a trace add up to the scores it reports, and that
+// tracing the evaluation gives the same score as evaluating normally.
+func TestTraceEvaluation(t *testing.T) {
+	var pos Position
+	for _, perftTest := range loadPerftSuite() {
+		pos.LoadFEN(perftTest.FEN)
+		checkTrace(t, &pos, 2)
+	}
+}
+
+func checkTrace(t *testing.T, pos *Position, depth uint8) {
+	trace := TraceEvaluation(pos)
+	if score := EvaluatePos(pos); trace.Score != score {
+		t.Fatalf("%s: traced score is %d instead of %d", pos.GenFEN(), trace.Score, score)
+	}
+
+	var mgScore, egScore int16
+	for term := uint8(0); term < NumEvalTerms; term++ {
+		mgScore += trace.MGScores[term][White] - trace.MGScores[term][Black]
+		egScore += trace.EGScores[term][White] - trace.EGScores[term][Black]
+	}
+
+	if mgScore != trace.MGScore || egScore != trace.EGScore {
+		t.Fatalf(
+			"%s: terms add up to mg=%d eg=%d instead of mg=%d eg=%d",
+			pos.GenFEN(), mgScore, egScore, trace.MGScore, trace.EGScore,
+		)
+	}
+
+	if depth == 0 {
+		return
+	}
+
+	moves := GenMoves(pos)
+	for index := uint8(0); index < moves.Count; index++ {
+		move := moves.Moves[index]
+		if pos.MakeMove(move) {
+			checkTrace(t, pos, depth-1)
+		}
+		pos.UnmakeMove(move)
+	}
+}
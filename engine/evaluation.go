@@ -25,6 +25,27 @@ type Eval struct {
 	KingZones        [2]KingZone
 	KingAttackPoints [2]uint16
 	KingAttackers    [2]uint8
+
+	// The squares attacked by each side, by its pawns and by all of its
+	// pieces, and the passed pawns of both sides.
+	PawnAttacks [2]Bitboard
+	Attacks     [2]Bitboard
+	PassedPawns Bitboard
+
+	// The trace each term of the evaluation is recorded in, if the
+	// evaluation is being traced.
+	Trace *EvalTrace
+}
+
+// Add the given middle-game and end-game scores of a term to a side's
+// score, recording them in the trace if there is one.
+func (eval *Eval) add(term uint8, color uint8, mg, eg int16) {
+	eval.MGScores[color] += mg
+	eval.EGScores[color] += eg
+	if eval.Trace != nil {
+		eval.Trace.MGScores[term][color] += mg
+		eval.Trace.EGScores[term][color] += eg
+	}
 }
 
 type KingZone struct {
@@ -37,6 +58,8 @@ var IsolatedPawnMasks [8]Bitboard
 var DoubledPawnMasks [2][64]Bitboard
 var PassedPawnMasks [2][64]Bitboard
 var KnightOutpustMasks [2][64]Bitboard
+var BackwardPawnMasks [2][64]Bitboard
+var SpaceMasks [2]Bitboard
 
 var PieceValueMG [6]int16 = [6]int16{83, 328, 365, 473, 968}
 var PieceValueEG [6]int16 = [6]int16{98, 273, 303, 522, 976}
@@ -51,9 +74,40 @@ var IsolatedPawnPenatlyEG int16 = 5
 var DoubledPawnPenatlyMG int16 = 1
 var DoubledPawnPenatlyEG int16 = 17
 
+var ConnectedPawnBonusMG [8]int16 = [8]int16{0, 4, 6, 10, 18, 32, 56, 0}
+var ConnectedPawnBonusEG [8]int16 = [8]int16{0, 2, 4, 8, 14, 28, 48, 0}
+var CandidatePasserBonusMG [8]int16 = [8]int16{0, 2, 4, 8, 14, 24, 0, 0}
+var CandidatePasserBonusEG [8]int16 = [8]int16{0, 4, 8, 14, 24, 40, 0, 0}
+
+var BackwardPawnPenaltyMG int16 = 8
+var BackwardPawnPenaltyEG int16 = 10
+
+var PasserOwnKingDistanceEG int16 = 3
+var PasserEnemyKingDistanceEG int16 = 6
+
 var KnightOutpostBonusMG int16 = 41
 var KnightOutpostBonusEG int16 = 8
 
+var BishopPairBonusMG int16 = 22
+var BishopPairBonusEG int16 = 58
+
+var RookOpenFileBonusMG int16 = 28
+var RookOpenFileBonusEG int16 = 8
+var RookSemiOpenFileBonusMG int16 = 12
+var RookSemiOpenFileBonusEG int16 = 6
+var RookOn7thBonusMG int16 = 8
+var RookOn7thBonusEG int16 = 24
+
+var ThreatByPawnMG int16 = 48
+var ThreatByPawnEG int16 = 36
+var HangingPieceMG int16 = 24
+var HangingPieceEG int16 = 12
+
+var SpaceBonusMG int16 = 2
+
+var TempoBonusMG int16 = 12
+var TempoBonusEG int16 = 6
+
 var MinorAttackOuterRing int16 = 1
 var MinorAttackInnerRing int16 = 3
 var RookAttackOuterRing int16 = 1
@@ -278,20 +332,64 @@ var FlipRank [2][8]int = [2][8]int{
 // Evaluate a position and give a score, from the perspective of the side to move (
 // more positive if it's good for the side to move, otherwise more negative).
 func EvaluatePos(pos *Position) int16 {
+	return evaluatePos(pos, nil, nil)
+}
+
+// Evaluate a position, looking up the pawn structure scores in the given
+// pawn hash table, and recording each term of the evaluation in the given
+// trace, if there are any.
+func evaluatePos(pos *Position, pawnTable *PawnTable, trace *EvalTrace) int16 {
+	// Endgames with a specialized evaluation function don't need the
+	// general evaluation.
+	endgame, isEndgame := probeEndgame(pos)
+	if isEndgame && endgame.evaluate != nil {
+		if score, ok := endgame.evaluate(pos, endgame.strong); ok {
+			if pos.SideToMove != endgame.strong {
+				score = -score
+			}
+
+			if trace != nil {
+				trace.SideToMove = pos.SideToMove
+				trace.Endgame = endgame.Name
+				trace.ExactEndgame = true
+				trace.Score = score
+			}
+			return score
+		}
+	}
+
+	// Start from the material and piece-square table scores, which the
+	// position keeps updated as moves are made.
 	var eval Eval
+	eval.MGScores = pos.MGScores
+	eval.EGScores = pos.EGScores
 	eval.KingZones[White] = KingZones[pos.PieceBB[White][King].Msb()]
 	eval.KingZones[Black] = KingZones[pos.PieceBB[Black][King].Msb()]
 
-	phase := TotalPhase
-	allBB := pos.SideBB[pos.SideToMove] | pos.SideBB[pos.SideToMove^1]
+	if trace != nil {
+		eval.Trace = trace
+		traceMaterial(pos, trace)
+	}
+
+	pawnsBB := pos.PieceBB[White][Pawn] | pos.PieceBB[Black][Pawn]
+	evalPawns(pos, pawnTable, &eval)
+
+	for color := Black; color <= White; color++ {
+		pawns := pos.PieceBB[color][Pawn]
+		for pawns != 0 {
+			eval.PawnAttacks[color] |= PawnAttacks[color][pawns.PopBit()]
+		}
+		eval.Attacks[color] = eval.PawnAttacks[color] | KingMoves[pos.PieceBB[color][King].Msb()]
+	}
+
+	phase := TotalPhase - pos.Phase
+	allBB := (pos.SideBB[pos.SideToMove] | pos.SideBB[pos.SideToMove^1]) &^ pawnsBB
 
 	for allBB != 0 {
 		sq := allBB.PopBit()
 		piece := pos.Squares[sq]
 
 		switch piece.Type {
-		case Pawn:
-			evalPawn(pos, piece.Color, sq, &eval)
 		case Knight:
 			evalKnight(pos, piece.Color, sq, &eval)
 		case Bishop:
@@ -301,56 +399,189 @@ func EvaluatePos(pos *Position) int16 {
 		case Queen:
 			evalQueen(pos, piece.Color, sq, &eval)
 		}
-
-		phase -= PhaseValues[piece.Type]
 	}
 
 	evalKing(pos, White, pos.PieceBB[White][King].Msb(), &eval)
 	evalKing(pos, Black, pos.PieceBB[Black][King].Msb(), &eval)
 
+	for color := Black; color <= White; color++ {
+		if pos.PieceBB[color][Bishop].CountBits() >= 2 {
+			eval.add(TermBishopPair, color, BishopPairBonusMG, BishopPairBonusEG)
+		}
+
+		evalPassedPawns(pos, color, &eval)
+		evalThreats(pos, color, &eval)
+		evalSpace(pos, color, &eval)
+	}
+
+	eval.add(TermTempo, pos.SideToMove, TempoBonusMG, TempoBonusEG)
+
+	// Scale down the end-game score if the side it favors can't make progress.
+	strong := White
+	if eval.EGScores[Black] > eval.EGScores[White] {
+		strong = Black
+	}
+
+	scale := scaleFactor(pos, &endgame, strong)
+
 	mgScore := eval.MGScores[pos.SideToMove] - eval.MGScores[pos.SideToMove^1]
 	egScore := eval.EGScores[pos.SideToMove] - eval.EGScores[pos.SideToMove^1]
+	egScore = int16(int32(egScore) * int32(scale) / int32(ScaleFactorNormal))
 
 	phase = (phase*256 + (TotalPhase / 2)) / TotalPhase
-	return int16(((int32(mgScore) * (int32(256) - int32(phase))) + (int32(egScore) * int32(phase))) / int32(256))
+	score := int16(((int32(mgScore) * (int32(256) - int32(phase))) + (int32(egScore) * int32(phase))) / int32(256))
+
+	if trace != nil {
+		trace.SideToMove = pos.SideToMove
+		trace.MGScore = eval.MGScores[White] - eval.MGScores[Black]
+		trace.EGScore = eval.EGScores[White] - eval.EGScores[Black]
+		trace.ScaleFactor = scale
+		if endgame.scale != nil && endgame.strong == strong {
+			trace.Endgame = endgame.Name
+		}
+		trace.Phase = phase
+		trace.Score = score
+	}
+	return score
+}
+
+// Evaluate the pawns of both sides. Since the score of the pawns only
+// depends on where the pawns are, it's looked up in the pawn hash table
+// when possible, and stored there otherwise.
+func evalPawns(pos *Position, pawnTable *PawnTable, eval *Eval) {
+	if entry := pawnTable.Probe(pos.PawnHash); entry != nil {
+		for color := Black; color <= White; color++ {
+			eval.MGScores[color] += entry.MGScores[color]
+			eval.EGScores[color] += entry.EGScores[color]
+		}
+		eval.PassedPawns = entry.PassedPawns
+		return
+	}
+
+	pawnEval := Eval{Trace: eval.Trace}
+	for color := Black; color <= White; color++ {
+		pawns := pos.PieceBB[color][Pawn]
+		for pawns != 0 {
+			evalPawn(pos, color, pawns.PopBit(), &pawnEval)
+		}
+
+		eval.MGScores[color] += pawnEval.MGScores[color]
+		eval.EGScores[color] += pawnEval.EGScores[color]
+	}
+
+	eval.PassedPawns = pawnEval.PassedPawns
+	pawnTable.Store(pos.PawnHash, pawnEval.MGScores, pawnEval.EGScores, pawnEval.PassedPawns)
 }
 
 // Evaluate the score of a pawn.
 func evalPawn(pos *Position, color, sq uint8, eval *Eval) {
-	eval.MGScores[color] += PieceValueMG[Pawn] + PSQT_MG[Pawn][FlipSq[color][sq]]
-	eval.EGScores[color] += PieceValueEG[Pawn] + PSQT_EG[Pawn][FlipSq[color][sq]]
-
 	usPawns := pos.PieceBB[color][Pawn]
 	enemyPawns := pos.PieceBB[color^1][Pawn]
 
 	file := FileOf(sq)
+	rank := FlipRank[color][RankOf(sq)]
+	isolated := false
 	doubled := false
 
 	// Evaluate isolated pawns.
 	if IsolatedPawnMasks[file]&usPawns == 0 {
-		eval.MGScores[color] -= IsolatedPawnPenatlyMG
-		eval.EGScores[color] -= IsolatedPawnPenatlyEG
+		isolated = true
+		eval.add(TermIsolatedPawns, color, -IsolatedPawnPenatlyMG, -IsolatedPawnPenatlyEG)
 	}
 
 	// Evaluate doubled pawns.
 	if DoubledPawnMasks[color][sq]&usPawns != 0 {
 		doubled = true
-		eval.MGScores[color] -= DoubledPawnPenatlyMG
-		eval.EGScores[color] -= DoubledPawnPenatlyEG
+		eval.add(TermDoubledPawns, color, -DoubledPawnPenatlyMG, -DoubledPawnPenatlyEG)
 	}
 
-	// Evaluate passed pawns.
-	if PassedPawnMasks[color][sq]&enemyPawns == 0 && !doubled {
-		eval.MGScores[color] += PassedPawnBonusMG[FlipRank[color][RankOf(sq)]]
-		eval.EGScores[color] += PassedPawnBonusEG[FlipRank[color][RankOf(sq)]]
+	// Evaluate connected pawns, which are either defended by a pawn, or
+	// have a pawn next to them.
+	sqBB := SquareBB[sq]
+	neighbors := ((sqBB & ClearFile[FileA]) << 1) | ((sqBB & ClearFile[FileH]) >> 1)
+	if PawnAttacks[color^1][sq]&usPawns != 0 || neighbors&usPawns != 0 {
+		eval.add(TermConnectedPawns, color, ConnectedPawnBonusMG[rank], ConnectedPawnBonusEG[rank])
+	}
+
+	// Evaluate backward pawns, which have no pawns on the files next to them
+	// able to defend them as they advance, and can't advance safely.
+	stopSq := uint8(int8(sq) + pawnPush(color))
+	if !isolated && BackwardPawnMasks[color][sq]&usPawns == 0 && PawnAttacks[color][stopSq]&enemyPawns != 0 {
+		eval.add(TermBackwardPawns, color, -BackwardPawnPenaltyMG, -BackwardPawnPenaltyEG)
+	}
+
+	if doubled {
+		return
+	}
+
+	// Evaluate passed pawns, and candidate passed pawns, which have no enemy
+	// pawns in front of them, and at least as many pawns on the files next
+	// to them to help them advance as there are enemy pawns to stop them.
+	sentries := PassedPawnMasks[color][sq] & enemyPawns
+	if sentries == 0 {
+		eval.PassedPawns |= sqBB
+		eval.add(TermPassedPawns, color, PassedPawnBonusMG[rank], PassedPawnBonusEG[rank])
+	} else if DoubledPawnMasks[color][sq]&enemyPawns == 0 &&
+		(BackwardPawnMasks[color][sq]&usPawns).CountBits() >= sentries.CountBits() {
+		eval.add(TermCandidatePassers, color, CandidatePasserBonusMG[rank], CandidatePasserBonusEG[rank])
 	}
 }
 
+// Evaluate the passed pawns of a side in the end-game, which are more valuable
+// the closer the side's king is to them, and the further away the enemy king.
+func evalPassedPawns(pos *Position, color uint8, eval *Eval) {
+	usKingSq := pos.PieceBB[color][King].Msb()
+	enemyKingSq := pos.PieceBB[color^1][King].Msb()
+
+	passed := eval.PassedPawns & pos.PieceBB[color][Pawn]
+	for passed != 0 {
+		sq := passed.PopBit()
+		rank := FlipRank[color][RankOf(sq)]
+		if rank < Rank4 {
+			continue
+		}
+
+		weight := int16(rank - Rank3)
+		stopSq := uint8(int8(sq) + pawnPush(color))
+		bonus := distance(enemyKingSq, stopSq)*PasserEnemyKingDistanceEG - distance(usKingSq, stopSq)*PasserOwnKingDistanceEG
+		eval.add(TermPasserKingDistance, color, 0, weight*bonus)
+	}
+}
+
+// Evaluate the threats a side makes: enemy pieces attacked by its pawns, and
+// enemy pieces it attacks which aren't defended.
+func evalThreats(pos *Position, color uint8, eval *Eval) {
+	enemyPieces := pos.SideBB[color^1] &^ (pos.PieceBB[color^1][Pawn] | pos.PieceBB[color^1][King])
+
+	threatenedByPawns := int16((enemyPieces & eval.PawnAttacks[color]).CountBits())
+	hanging := int16((enemyPieces & eval.Attacks[color] &^ eval.Attacks[color^1]).CountBits())
+
+	eval.add(TermThreats, color, threatenedByPawns*ThreatByPawnMG, threatenedByPawns*ThreatByPawnEG)
+	eval.add(TermHangingPieces, color, hanging*HangingPieceMG, hanging*HangingPieceEG)
+}
+
+// Evaluate the space a side controls in the center, behind its pawns. The
+// squares not attacked by enemy pawns are counted, and those behind the side's
+// own pawns are counted twice.
+func evalSpace(pos *Position, color uint8, eval *Eval) {
+	usPawns := pos.PieceBB[color][Pawn]
+	safe := SpaceMasks[color] &^ usPawns &^ eval.PawnAttacks[color^1]
+
+	behind := usPawns
+	for i := 0; i < 3; i++ {
+		if color == White {
+			behind |= behind << 8
+		} else {
+			behind |= behind >> 8
+		}
+	}
+
+	space := int16(safe.CountBits() + (safe & behind).CountBits())
+	eval.add(TermSpace, color, space*SpaceBonusMG, 0)
+}
+
 // Evaluate the score of a knight.
 func evalKnight(pos *Position, color, sq uint8, eval *Eval) {
-	eval.MGScores[color] += PieceValueMG[Knight] + PSQT_MG[Knight][FlipSq[color][sq]]
-	eval.EGScores[color] += PieceValueEG[Knight] + PSQT_EG[Knight][FlipSq[color][sq]]
-
 	usPawns := pos.PieceBB[color][Pawn]
 	enemyPawns := pos.PieceBB[color^1][Pawn]
 
@@ -358,16 +589,17 @@ func evalKnight(pos *Position, color, sq uint8, eval *Eval) {
 		PawnAttacks[color^1][sq]&usPawns != 0 &&
 		FlipRank[color][RankOf(sq)] >= Rank5 {
 
-		eval.MGScores[color] += KnightOutpostBonusMG
-		eval.EGScores[color] += KnightOutpostBonusEG
+		eval.add(TermKnightOutposts, color, KnightOutpostBonusMG, KnightOutpostBonusEG)
 	}
 
 	usBB := pos.SideBB[color]
-	moves := KnightMoves[sq] & ^usBB
+	attacks := KnightMoves[sq]
+	eval.Attacks[color] |= attacks
+
+	moves := attacks & ^usBB
 	mobility := int16(moves.CountBits())
 
-	eval.MGScores[color] += (mobility - 4) * PieceMobilityMG[Knight-1]
-	eval.EGScores[color] += (mobility - 4) * PieceMobilityEG[Knight-1]
+	eval.add(TermKnightMobility, color, (mobility-4)*PieceMobilityMG[Knight-1], (mobility-4)*PieceMobilityEG[Knight-1])
 
 	outerRingAttacks := moves & eval.KingZones[color^1].OuterRing
 	innerRingAttacks := moves & eval.KingZones[color^1].InnerRing
@@ -381,17 +613,16 @@ func evalKnight(pos *Position, color, sq uint8, eval *Eval) {
 
 // Evaluate the score of a bishop.
 func evalBishop(pos *Position, color, sq uint8, eval *Eval) {
-	eval.MGScores[color] += PieceValueMG[Bishop] + PSQT_MG[Bishop][FlipSq[color][sq]]
-	eval.EGScores[color] += PieceValueEG[Bishop] + PSQT_EG[Bishop][FlipSq[color][sq]]
-
 	usBB := pos.SideBB[color]
 	allBB := pos.SideBB[pos.SideToMove] | pos.SideBB[pos.SideToMove^1]
 
-	moves := genBishopMoves(sq, allBB) & ^usBB
+	attacks := genBishopMoves(sq, allBB)
+	eval.Attacks[color] |= attacks
+
+	moves := attacks & ^usBB
 	mobility := int16(moves.CountBits())
 
-	eval.MGScores[color] += (mobility - 7) * PieceMobilityMG[Bishop-1]
-	eval.EGScores[color] += (mobility - 7) * PieceMobilityEG[Bishop-1]
+	eval.add(TermBishopMobility, color, (mobility-7)*PieceMobilityMG[Bishop-1], (mobility-7)*PieceMobilityEG[Bishop-1])
 
 	outerRingAttacks := moves & eval.KingZones[color^1].OuterRing
 	innerRingAttacks := moves & eval.KingZones[color^1].InnerRing
@@ -405,17 +636,34 @@ func evalBishop(pos *Position, color, sq uint8, eval *Eval) {
 
 // Evaluate the score of a rook.
 func evalRook(pos *Position, color, sq uint8, eval *Eval) {
-	eval.MGScores[color] += PieceValueMG[Rook] + PSQT_MG[Rook][FlipSq[color][sq]]
-	eval.EGScores[color] += PieceValueEG[Rook] + PSQT_EG[Rook][FlipSq[color][sq]]
-
 	usBB := pos.SideBB[color]
 	allBB := pos.SideBB[pos.SideToMove] | pos.SideBB[pos.SideToMove^1]
 
-	moves := genRookMoves(sq, allBB) & ^usBB
+	attacks := genRookMoves(sq, allBB)
+	eval.Attacks[color] |= attacks
+
+	moves := attacks & ^usBB
 	mobility := int16(moves.CountBits())
 
-	eval.MGScores[color] += (mobility - 7) * PieceMobilityMG[Rook-1]
-	eval.EGScores[color] += (mobility - 7) * PieceMobilityEG[Rook-1]
+	eval.add(TermRookMobility, color, (mobility-7)*PieceMobilityMG[Rook-1], (mobility-7)*PieceMobilityEG[Rook-1])
+
+	// Evaluate rooks on open and semi-open files.
+	usPawns := pos.PieceBB[color][Pawn]
+	enemyPawns := pos.PieceBB[color^1][Pawn]
+	fileBB := MaskFile[FileOf(sq)]
+
+	if fileBB&(usPawns|enemyPawns) == 0 {
+		eval.add(TermRookFiles, color, RookOpenFileBonusMG, RookOpenFileBonusEG)
+	} else if fileBB&usPawns == 0 {
+		eval.add(TermRookFiles, color, RookSemiOpenFileBonusMG, RookSemiOpenFileBonusEG)
+	}
+
+	// Evaluate rooks on the 7th rank, where they attack pawns which haven't
+	// moved yet, or cut off the enemy king on the 8th rank.
+	enemyKingRank := FlipRank[color][RankOf(pos.PieceBB[color^1][King].Msb())]
+	if FlipRank[color][RankOf(sq)] == Rank7 && (enemyKingRank == Rank8 || MaskRank[RankOf(sq)]&enemyPawns != 0) {
+		eval.add(TermRookOn7th, color, RookOn7thBonusMG, RookOn7thBonusEG)
+	}
 
 	outerRingAttacks := moves & eval.KingZones[color^1].OuterRing
 	innerRingAttacks := moves & eval.KingZones[color^1].InnerRing
@@ -429,17 +677,16 @@ func evalRook(pos *Position, color, sq uint8, eval *Eval) {
 
 // Evaluate the score of a queen.
 func evalQueen(pos *Position, color, sq uint8, eval *Eval) {
-	eval.MGScores[color] += PieceValueMG[Queen] + PSQT_MG[Queen][FlipSq[color][sq]]
-	eval.EGScores[color] += PieceValueEG[Queen] + PSQT_EG[Queen][FlipSq[color][sq]]
-
 	usBB := pos.SideBB[color]
 	allBB := pos.SideBB[pos.SideToMove] | pos.SideBB[pos.SideToMove^1]
 
-	moves := (genBishopMoves(sq, allBB) | genRookMoves(sq, allBB)) & ^usBB
+	attacks := genBishopMoves(sq, allBB) | genRookMoves(sq, allBB)
+	eval.Attacks[color] |= attacks
+
+	moves := attacks & ^usBB
 	mobility := int16(moves.CountBits())
 
-	eval.MGScores[color] += (mobility - 14) * PieceMobilityMG[Queen-1]
-	eval.EGScores[color] += (mobility - 14) * PieceMobilityEG[Queen-1]
+	eval.add(TermQueenMobility, color, (mobility-14)*PieceMobilityMG[Queen-1], (mobility-14)*PieceMobilityEG[Queen-1])
 
 	outerRingAttacks := moves & eval.KingZones[color^1].OuterRing
 	innerRingAttacks := moves & eval.KingZones[color^1].InnerRing
@@ -453,35 +700,43 @@ func evalQueen(pos *Position, color, sq uint8, eval *Eval) {
 
 // Evaluate the score of a king.
 func evalKing(pos *Position, color, sq uint8, eval *Eval) {
-	eval.MGScores[color] += PSQT_MG[King][FlipSq[color][sq]]
-	eval.EGScores[color] += PSQT_EG[King][FlipSq[color][sq]]
-
-	enemyPoints := InitKingSafety[FlipSq[color][sq]] + eval.KingAttackPoints[color^1]
 	kingFile := MaskFile[FileOf(sq)]
 	usPawns := pos.PieceBB[color][Pawn]
 
 	// Evaluate semi-open files adjacent to the enemy king
 	leftFile := ((kingFile & ClearFile[FileA]) << 1)
 	rightFile := ((kingFile & ClearFile[FileH]) >> 1)
+	semiOpenFilePoints := uint16(0)
 
 	if kingFile&usPawns == 0 {
-		enemyPoints += uint16(SemiOpenFileNextToKingPenalty)
+		semiOpenFilePoints += uint16(SemiOpenFileNextToKingPenalty)
 	}
 
 	if leftFile != 0 && leftFile&usPawns == 0 {
-		enemyPoints += uint16(SemiOpenFileNextToKingPenalty)
+		semiOpenFilePoints += uint16(SemiOpenFileNextToKingPenalty)
 	}
 
 	if rightFile != 0 && rightFile&usPawns == 0 {
-		enemyPoints += uint16(SemiOpenFileNextToKingPenalty)
+		semiOpenFilePoints += uint16(SemiOpenFileNextToKingPenalty)
 	}
 
 	// Take all the king saftey points collected for the enemy,
 	// and see what kind of penatly we should get by indexing the
 	// non-linear king-saftey table.
+	enemyPoints := InitKingSafety[FlipSq[color][sq]] + eval.KingAttackPoints[color^1] + semiOpenFilePoints
 	enemyPoints = min_u16(enemyPoints, uint16(len(KingAttackTable)-1))
 	if eval.KingAttackers[color^1] >= 2 && pos.PieceBB[color^1][Queen] != 0 {
-		eval.MGScores[color] -= KingAttackTable[enemyPoints]
+		eval.add(TermKingSafety, color, -KingAttackTable[enemyPoints], 0)
+	}
+
+	if eval.Trace != nil {
+		eval.Trace.KingSafety[color] = KingSafetyTrace{
+			Attackers:          eval.KingAttackers[color^1],
+			SquarePoints:       InitKingSafety[FlipSq[color][sq]],
+			ZoneAttackPoints:   eval.KingAttackPoints[color^1],
+			SemiOpenFilePoints: semiOpenFilePoints,
+			Points:             enemyPoints,
+		}
 	}
 }
 
@@ -493,6 +748,12 @@ func min_u16(a, b uint16) uint16 {
 }
 
 func init() {
+	// Create the space masks, which hold the squares on the center files
+	// on a side's 2nd, 3rd, and 4th ranks.
+	centerFiles := MaskFile[FileC] | MaskFile[FileD] | MaskFile[FileE] | MaskFile[FileF]
+	SpaceMasks[White] = centerFiles & (MaskRank[Rank2] | MaskRank[Rank3] | MaskRank[Rank4])
+	SpaceMasks[Black] = centerFiles & (MaskRank[Rank7] | MaskRank[Rank6] | MaskRank[Rank5])
+
 	for sq := 0; sq < 64; sq++ {
 		// Create king zones.
 		sqBB := SquareBB[sq]
@@ -556,5 +817,18 @@ func init() {
 
 		KnightOutpustMasks[Black][sq] = blackKnightMask
 		PassedPawnMasks[Black][sq] = blackFrontSpan
+
+		// Create backward pawn masks, which hold the squares on the files
+		// next to a pawn, from its rank back.
+		BackwardPawnMasks[White][sq] = IsolatedPawnMasks[file]
+		BackwardPawnMasks[Black][sq] = IsolatedPawnMasks[file]
+
+		for r := rank + 1; r <= 7; r++ {
+			BackwardPawnMasks[White][sq] &= ClearRank[r]
+		}
+
+		for r := rank - 1; r >= 0; r-- {
+			BackwardPawnMasks[Black][sq] &= ClearRank[r]
+		}
 	}
 }
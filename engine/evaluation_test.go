@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// Get the FEN string of the given position with the colors swapped, by
+// mirroring the board vertically.
+func mirrorFEN(fen string) string {
+	fields := strings.Fields(fen)
+
+	ranks := strings.Split(fields[0], "/")
+	for i, j := 0, len(ranks)-1; i < j; i, j = i+1, j-1 {
+		ranks[i], ranks[j] = ranks[j], ranks[i]
+	}
+
+	swapCase := func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}
+
+	fields[0] = strings.Map(swapCase, strings.Join(ranks, "/"))
+	if fields[1] == "w" {
+		fields[1] = "b"
+	} else {
+		fields[1] = "w"
+	}
+
+	if fields[2] != "-" {
+		castling := []rune(strings.Map(swapCase, fields[2]))
+		var sorted string
+		for _, right := range "KQkq" {
+			for _, r := range castling {
+				if r == right {
+					sorted += string(r)
+				}
+			}
+		}
+		fields[2] = sorted
+	}
+
+	if fields[3] != "-" {
+		fields[3] = string(fields[3][0]) + string('1'+'8'-fields[3][1])
+	}
+	return strings.Join(fields, " ")
+}
+
+// Test that the evaluation is symmetric, scoring a position the same as the
+// position with the colors swapped.
+func TestEvaluationSymmetry(t *testing.T) {
+	var pos, mirrored Position
+	fens := append([]string{}, BenchPositions...)
+	for _, perftTest := range loadPerftSuite() {
+		fens = append(fens, perftTest.FEN)
+	}
+
+	for _, fen := range fens {
+		pos.LoadFEN(fen)
+		moves := GenMoves(&pos)
+
+		for index := uint8(0); index < moves.Count; index++ {
+			move := moves.Moves[index]
+			if pos.MakeMove(move) {
+				mirrored.LoadFEN(mirrorFEN(pos.GenFEN()))
+				if score, mirroredScore := EvaluatePos(&pos), EvaluatePos(&mirrored); score != mirroredScore {
+					t.Errorf("%s scored %d, but the mirrored position scored %d", pos.GenFEN(), score, mirroredScore)
+				}
+			}
+			pos.UnmakeMove(move)
+		}
+	}
+}
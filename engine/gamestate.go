@@ -0,0 +1,144 @@
+package engine
+
+// gamestate.go implements the rules which end a game: checkmate and stalemate,
+// the draws a player can claim by threefold repetition or the fifty-move rule,
+// and the draws which end the game automatically, by fivefold repetition, the
+// seventy-five-move rule, or a dead position. Unlike the search, which scores
+// any position reached before as a draw, these follow the rules exactly, so
+// they can be used to decide the result of a game.
+
+// The state of a game, as far as the rules are concerned.
+type GameState uint8
+
+const (
+	// Constants representing each state of a game. Only a draw by threefold
+	// repetition or the fifty-move rule must be claimed by a player, the
+	// other states end the game immediately.
+	StateOngoing GameState = iota
+	StateCheckmate
+	StateStalemate
+	StateThreefoldRepetition
+	StateFiftyMoveRule
+	StateFivefoldRepetition
+	StateSeventyFiveMoveRule
+	StateInsufficientMaterial
+
+	// The number of plies without a capture or pawn move after which a
+	// draw can be claimed, or the game is drawn automatically.
+	FiftyMoveRulePlies       = 100
+	SeventyFiveMoveRulePlies = 150
+)
+
+// The name of each game state.
+var gameStateNames = [...]string{
+	StateOngoing:              "ongoing",
+	StateCheckmate:            "checkmate",
+	StateStalemate:            "stalemate",
+	StateThreefoldRepetition:  "threefold repetition",
+	StateFiftyMoveRule:        "fifty-move rule",
+	StateFivefoldRepetition:   "fivefold repetition",
+	StateSeventyFiveMoveRule:  "seventy-five-move rule",
+	StateInsufficientMaterial: "insufficient material",
+}
+
+func (state GameState) String() string {
+	return gameStateNames[state]
+}
+
+// Determine if the game is over, or can be claimed to be over.
+func (state GameState) IsOver() bool {
+	return state != StateOngoing
+}
+
+// Determine if the game is drawn, or can be claimed to be drawn.
+func (state GameState) IsDraw() bool {
+	return state != StateOngoing && state != StateCheckmate
+}
+
+// Determine if the state is a draw that must be claimed by a player, rather
+// than one that ends the game immediately.
+func (state GameState) IsClaimable() bool {
+	return state == StateThreefoldRepetition || state == StateFiftyMoveRule
+}
+
+// Get the state of the game in the position. A checkmate or stalemate takes
+// precedence over every draw, so a move which checkmates the opponent still
+// wins the game even if it's the move that reaches the fifty or seventy-five
+// move limit. The draws which end the game automatically take precedence over
+// the draws which have to be claimed.
+func (pos *Position) GameState() GameState {
+	if GenLegalMoves(pos).Count == 0 {
+		if pos.InCheck() {
+			return StateCheckmate
+		}
+		return StateStalemate
+	}
+
+	repetitions := pos.RepetitionCount()
+	switch {
+	case repetitions >= 5:
+		return StateFivefoldRepetition
+	case pos.Rule50 >= SeventyFiveMoveRulePlies:
+		return StateSeventyFiveMoveRule
+	case pos.InsufficientMaterial():
+		return StateInsufficientMaterial
+	case repetitions >= 3:
+		return StateThreefoldRepetition
+	case pos.Rule50 >= FiftyMoveRulePlies:
+		return StateFiftyMoveRule
+	}
+	return StateOngoing
+}
+
+// Count how many times the current position has been reached since the
+// position was loaded, including the current occurrence. Only the positions
+// since the last capture or pawn move, with the same side to move, can be
+// the same position.
+func (pos *Position) RepetitionCount() int {
+	count := 1
+	oldest := len(pos.history) - 1 - int(pos.Rule50)
+	if oldest < 0 {
+		oldest = 0
+	}
+
+	for idx := len(pos.history) - 3; idx >= oldest; idx -= 2 {
+		if pos.history[idx] == pos.Hash {
+			count++
+		}
+	}
+	return count
+}
+
+// Determine if neither side has enough material left to checkmate the other,
+// which makes the position dead. This is when only kings are left, plus a
+// single knight or bishop, or any number of bishops which all stand on squares
+// of the same color.
+func (pos *Position) InsufficientMaterial() bool {
+	for color := Black; color <= White; color++ {
+		if pos.PieceBB[color][Pawn]|pos.PieceBB[color][Rook]|pos.PieceBB[color][Queen] != 0 {
+			return false
+		}
+	}
+
+	knights := pos.PieceBB[White][Knight] | pos.PieceBB[Black][Knight]
+	bishops := pos.PieceBB[White][Bishop] | pos.PieceBB[Black][Bishop]
+
+	if knights.CountBits()+bishops.CountBits() <= 1 {
+		return true
+	}
+
+	if knights != 0 {
+		return false
+	}
+
+	dark, light := 0, 0
+	for bishops != 0 {
+		sq := bishops.PopBit()
+		if (FileOf(sq)+RankOf(sq))%2 == 0 {
+			dark++
+		} else {
+			light++
+		}
+	}
+	return dark == 0 || light == 0
+}
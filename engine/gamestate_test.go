@@ -0,0 +1,96 @@
+package engine
+
+import "testing"
+
+// Play the given moves, in UCI format, in the position.
+func playMoves(pos *Position, moves ...string) {
+	for _, moveAsString := range moves {
+		pos.MakeMove(MoveFromCoord(pos, moveAsString))
+	}
+}
+
+func TestRepetitions(t *testing.T) {
+	var pos Position
+	pos.LoadFEN(FENStartPosition)
+	shuffle := []string{"g1f3", "g8f6", "f3g1", "f6g8"}
+
+	expected := []GameState{StateOngoing, StateThreefoldRepetition, StateThreefoldRepetition, StateFivefoldRepetition}
+	for cycle, state := range expected {
+		playMoves(&pos, shuffle...)
+		if pos.RepetitionCount() != cycle+2 {
+			t.Errorf("Counted %d repetitions instead of %d", pos.RepetitionCount(), cycle+2)
+		}
+		if pos.GameState() != state {
+			t.Errorf("Game state after %d repetitions is %v instead of %v", cycle+2, pos.GameState(), state)
+		}
+	}
+
+	// A pawn move means none of the earlier positions can be reached again.
+	playMoves(&pos, "e2e4", "e7e5")
+	if pos.RepetitionCount() != 1 || pos.GameState() != StateOngoing {
+		t.Errorf("Counted %d repetitions after pawn moves", pos.RepetitionCount())
+	}
+}
+
+func TestMoveRules(t *testing.T) {
+	tests := []struct {
+		fen   string
+		moves []string
+		state GameState
+	}{
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 98 80", []string{"a1a2"}, StateOngoing},
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 99 80", []string{"a1a2"}, StateFiftyMoveRule},
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 149 80", []string{"a1a2"}, StateSeventyFiveMoveRule},
+
+		// A checkmate on the move reaching the limit still wins the game.
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 99 80", []string{"a1a8"}, StateCheckmate},
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 149 80", []string{"a1a8"}, StateCheckmate},
+		{"7k/5Q2/8/8/8/8/8/6K1 w - - 99 80", []string{"f7g6"}, StateStalemate},
+
+		// A counter past 255 plies doesn't wrap around.
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 300 80", nil, StateSeventyFiveMoveRule},
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 255 80", []string{"a1a2"}, StateSeventyFiveMoveRule},
+	}
+
+	var pos Position
+	for _, test := range tests {
+		pos.LoadFEN(test.fen)
+		playMoves(&pos, test.moves...)
+		if state := pos.GameState(); state != test.state {
+			t.Errorf("Game state of %s after %v is %v instead of %v", test.fen, test.moves, state, test.state)
+		}
+	}
+
+	fen := "4k3/8/8/8/8/8/4P3/R3K3 w - - 300 80"
+	pos.LoadFEN(fen)
+	if pos.GenFEN() != fen {
+		t.Errorf("Loading %s gave %s", fen, pos.GenFEN())
+	}
+}
+
+func TestInsufficientMaterial(t *testing.T) {
+	tests := []struct {
+		fen          string
+		insufficient bool
+	}{
+		{"4k3/8/8/8/8/8/8/4K3 w - - 0 1", true},
+		{"4k3/8/8/8/8/8/8/4KN2 w - - 0 1", true},
+		{"4kb2/8/8/8/8/8/8/4K3 w - - 0 1", true},
+		{"4kb2/8/8/8/8/8/8/2B1K3 w - - 0 1", true},
+		{"4k3/8/8/8/8/8/8/2B1KB2 w - - 0 1", false},
+		{"4k3/8/8/8/8/8/8/3NKN2 w - - 0 1", false},
+		{"4kn2/8/8/8/8/8/8/4KB2 w - - 0 1", false},
+		{"4k3/8/8/8/8/8/4P3/4K3 w - - 0 1", false},
+	}
+
+	var pos Position
+	for _, test := range tests {
+		pos.LoadFEN(test.fen)
+		if pos.InsufficientMaterial() != test.insufficient {
+			t.Errorf("Insufficient material in %s is %v instead of %v", test.fen, !test.insufficient, test.insufficient)
+		}
+		if test.insufficient && pos.GameState() != StateInsufficientMaterial {
+			t.Errorf("Game state of %s is %v instead of %v", test.fen, pos.GameState(), StateInsufficientMaterial)
+		}
+	}
+}
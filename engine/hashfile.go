@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// hashfile.go implements saving the transposition table to a file and loading
+// it back, so the results of a long analysis can be reused after a restart.
+//
+// A hash file starts with a header: the magic bytes "BLUNDRTT", the version of
+// the format (a uint16), the age of the table (a uint8), and the number of
+// clusters in the table (a uint64). The entries of every cluster follow, in
+// order, each as its key, best move, score, depth, flag and age. All values
+// are little endian.
+
+const (
+	// The current version of the hash file format.
+	HashFileVersion uint16 = 1
+
+	// The size in bytes of the header, and of an entry, in a hash file.
+	HashFileHeaderSize = 8 + 2 + 1 + 8
+	HashFileEntrySize  = 4 + 4 + 2 + 1 + 1 + 1
+)
+
+// The magic bytes at the start of every hash file.
+var HashFileMagic = [8]byte{'B', 'L', 'U', 'N', 'D', 'R', 'T', 'T'}
+
+// Write the transposition table to the given writer.
+func (tt *TransTable) Save(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+
+	var header [HashFileHeaderSize]byte
+	copy(header[0:8], HashFileMagic[:])
+	binary.LittleEndian.PutUint16(header[8:10], HashFileVersion)
+	header[10] = tt.age
+	binary.LittleEndian.PutUint64(header[11:19], uint64(len(tt.clusters)))
+
+	if _, err := writer.Write(header[:]); err != nil {
+		return err
+	}
+
+	var buffer [HashFileEntrySize]byte
+	for idx := range tt.clusters {
+		for _, entry := range tt.clusters[idx] {
+			binary.LittleEndian.PutUint32(buffer[0:4], entry.Key)
+			binary.LittleEndian.PutUint32(buffer[4:8], uint32(entry.Best))
+			binary.LittleEndian.PutUint16(buffer[8:10], uint16(entry.Score))
+			buffer[10] = entry.Depth
+			buffer[11] = entry.Flag
+			buffer[12] = entry.Age
+
+			if _, err := writer.Write(buffer[:]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writer.Flush()
+}
+
+// Read a transposition table written by Save from the given reader. The table
+// must be the size it had when it was saved, and is left unchanged if it isn't,
+// or if the data read isn't a valid hash file.
+func (tt *TransTable) Load(r io.Reader) error {
+	reader := bufio.NewReader(r)
+
+	var header [HashFileHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return fmt.Errorf("reading hash file header: %v", err)
+	}
+
+	if !bytes.Equal(header[0:8], HashFileMagic[:]) {
+		return fmt.Errorf("not a hash file")
+	}
+
+	if version := binary.LittleEndian.Uint16(header[8:10]); version != HashFileVersion {
+		return fmt.Errorf("unsupported hash file version %d, expected %d", version, HashFileVersion)
+	}
+
+	age := header[10]
+	count := binary.LittleEndian.Uint64(header[11:19])
+	if count != uint64(len(tt.clusters)) {
+		return fmt.Errorf(
+			"hash file is %dMB but the table is %dMB, set the Hash option to %dMB to load it",
+			hashFileSizeInMB(count), tt.SizeInMB(), hashFileSizeInMB(count),
+		)
+	}
+
+	clusters := make([]TTCluster, count)
+	var buffer [HashFileEntrySize]byte
+	for idx := range clusters {
+		for entryIdx := range clusters[idx] {
+			if _, err := io.ReadFull(reader, buffer[:]); err != nil {
+				return fmt.Errorf("reading hash file entries: %v", err)
+			}
+
+			entry := &clusters[idx][entryIdx]
+			entry.Key = binary.LittleEndian.Uint32(buffer[0:4])
+			entry.Best = Move(binary.LittleEndian.Uint32(buffer[4:8]))
+			entry.Score = int16(binary.LittleEndian.Uint16(buffer[8:10]))
+			entry.Depth = buffer[10]
+			entry.Flag = buffer[11]
+			entry.Age = buffer[12]
+		}
+	}
+
+	tt.clusters = clusters
+	tt.age = age
+	return nil
+}
+
+// Get the size in MB of a table with the given number of clusters.
+func hashFileSizeInMB(count uint64) uint64 {
+	return count * TTClusterBytes / (1024 * 1024)
+}
+
+// Save the transposition table to the file at the given path, replacing
+// the file if it exists.
+func (tt *TransTable) SaveFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := tt.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Load the transposition table from the file at the given path.
+func (tt *TransTable) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Catch a truncated file before reading any of it.
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	expected := int64(HashFileHeaderSize + len(tt.clusters)*TTClusterSize*HashFileEntrySize)
+	if info.Mode().IsRegular() && info.Size() != expected {
+		return fmt.Errorf("hash file is %d bytes, expected %d for a %dMB table", info.Size(), expected, tt.SizeInMB())
+	}
+
+	return tt.Load(file)
+}
+
+// Get the size of the transposition table in MB.
+func (tt *TransTable) SizeInMB() uint64 {
+	return uint64(len(tt.clusters)) * TTClusterBytes / (1024 * 1024)
+}
@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashFileRoundTrip(t *testing.T) {
+	var saved TransTable
+	saved.Resize(1)
+	saved.NewSearch()
+
+	move := NewMove(E2, E4, Quiet, NoFlag)
+	for key := uint32(1); key <= 100; key++ {
+		saved.Store(ttTestHash(key, uint64(key)*31), 3, uint8(key%20), Inf-10, ExactFlag, move)
+	}
+
+	var buffer bytes.Buffer
+	if err := saved.Save(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.Len() != HashFileHeaderSize+len(saved.clusters)*TTClusterSize*HashFileEntrySize {
+		t.Errorf("saved %d bytes", buffer.Len())
+	}
+
+	var loaded TransTable
+	loaded.Resize(1)
+	if err := loaded.Load(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.clusters) != len(saved.clusters) || loaded.mask != saved.mask || loaded.age != saved.age {
+		t.Fatalf("loaded a table with %d clusters and age %d", len(loaded.clusters), loaded.age)
+	}
+
+	for idx := range saved.clusters {
+		if saved.clusters[idx] != loaded.clusters[idx] {
+			t.Fatalf("cluster %d is %v instead of %v", idx, loaded.clusters[idx], saved.clusters[idx])
+		}
+	}
+}
+
+func TestHashFileInvalid(t *testing.T) {
+	var saved TransTable
+	saved.Resize(1)
+	var buffer bytes.Buffer
+	if err := saved.Save(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	valid := buffer.Bytes()
+
+	badMagic := append([]byte{}, valid...)
+	badMagic[0] = 'X'
+
+	badVersion := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(badVersion[8:10], HashFileVersion+1)
+
+	// A count far larger than the table mustn't be allocated.
+	badCount := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint64(badCount[11:19], 1<<40)
+
+	truncated := valid[:len(valid)-1]
+
+	for name, data := range map[string][]byte{
+		"magic":     badMagic,
+		"version":   badVersion,
+		"count":     badCount,
+		"truncated": truncated,
+		"empty":     nil,
+	} {
+		var tt TransTable
+		tt.Resize(1)
+		if err := tt.Load(bytes.NewReader(data)); err == nil {
+			t.Errorf("loading a hash file with an invalid %s succeeded", name)
+		}
+
+		if tt.SizeInMB() != 1 {
+			t.Errorf("loading a hash file with an invalid %s changed the table", name)
+		}
+	}
+
+	// A table of a different size than the saved one isn't resized.
+	var tt TransTable
+	tt.Resize(2)
+	if err := tt.Load(bytes.NewReader(valid)); err == nil || tt.SizeInMB() != 2 {
+		t.Errorf("loading a 1MB hash file into a 2MB table resized it to %dMB", tt.SizeInMB())
+	}
+}
+
+func TestHashFileTruncated(t *testing.T) {
+	var saved TransTable
+	saved.Resize(1)
+	path := filepath.Join(t.TempDir(), "blunder.hash")
+	if err := saved.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data[:HashFileHeaderSize], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tt TransTable
+	tt.Resize(1)
+	if err := tt.LoadFile(path); err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Errorf("loading a truncated hash file returned %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package engine
+
+// history.go implements the history tables used to order moves and decide
+// how much to reduce them. Each table scores moves by how often they've caused
+// a beta cut-off: the butterfly history by the from and to square of the move,
+// the continuation histories by the piece and to square of the move together
+// with those of the move made one ply (counter-move history) or two plies
+// (follow-up history) before it, and the capture history by the piece moved,
+// the to square, and the piece captured.
+//
+// Every table is updated using "history gravity": the closer a score gets to
+// the maximum, the smaller the bonus it gets, so scores never overflow and
+// the tables adapt quickly when a move stops working.
+
+const (
+	// The maximum absolute value of a score in any of the history tables.
+	MaxHistory int32 = 8192
+
+	// The maximum bonus a move can get after a beta cut-off.
+	MaxHistoryBonus int32 = 1200
+
+	// The number of plies before the current one whose moves are used
+	// to index the continuation histories.
+	ContinuationPlies = 2
+)
+
+// A table of history scores indexed by the piece type moved and the to square.
+type PieceToHistory [6][64]int16
+
+// A table of history scores indexed by the side to move, and the piece type and
+// to square of a previous move, then of the current move.
+type ContinuationHistory [2][6][64]PieceToHistory
+
+// A table of history scores indexed by the side to move, the piece type moved,
+// the to square, and the piece type captured. Queen promotions which aren't
+// captures are indexed as capturing no piece.
+type CaptureHistory [2][6][64][7]int16
+
+// Get the bonus (or malus if negated) given to a move after a beta cut-off
+// at the given depth.
+func historyBonus(depth int8) int32 {
+	bonus := 32 * int32(depth) * int32(depth)
+	if bonus > MaxHistoryBonus {
+		return MaxHistoryBonus
+	}
+	return bonus
+}
+
+// Apply the given bonus to a history score using history gravity.
+func applyGravity(score int32, bonus int32) int32 {
+	absBonus := bonus
+	if absBonus < 0 {
+		absBonus = -absBonus
+	}
+	return score + bonus - score*absBonus/MaxHistory
+}
+
+// Get the continuation history tables for the moves made one and two plies
+// before the given ply. A table is nil if there was no move made, or if a
+// null move was made.
+func (search *Search) continuationHistories(ply uint8) (tables [ContinuationPlies]*PieceToHistory) {
+	sideToMove := search.Pos.SideToMove
+	for offset := uint8(1); offset <= ContinuationPlies; offset++ {
+		if ply < offset {
+			break
+		}
+
+		previous := search.plyMoves[ply-offset]
+		if previous.Equal(NullMove) {
+			continue
+		}
+
+		piece := search.plyPieces[ply-offset]
+		if offset == 1 {
+			tables[offset-1] = &search.counterMoveHistory[sideToMove][piece][previous.ToSq()]
+		} else {
+			tables[offset-1] = &search.followUpHistory[sideToMove][piece][previous.ToSq()]
+		}
+	}
+	return tables
+}
+
+// Get the combined history score of a quiet move from the butterfly history and
+// the continuation histories.
+func (search *Search) quietHistoryScore(move Move, contHistories *[ContinuationPlies]*PieceToHistory) int32 {
+	moved := search.Pos.Squares[move.FromSq()].Type
+	score := search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()]
+	for _, table := range contHistories {
+		if table != nil {
+			score += int32(table[moved][move.ToSq()])
+		}
+	}
+	return score
+}
+
+// Get the capture history score of a capture or queen promotion.
+func (search *Search) captureHistoryScore(move Move) int16 {
+	moved := search.Pos.Squares[move.FromSq()].Type
+	return search.captureHistory[search.Pos.SideToMove][moved][move.ToSq()][capturedType(&search.Pos, move)]
+}
+
+// Update the history tables after a beta cut-off caused by the given move at
+// the given ply. The move gets a bonus, and the moves of the same kind searched
+// before it, which didn't cause a cut-off, get a malus. Captures tried before
+// the cut-off always get a malus.
+func (search *Search) updateHistories(move Move, ply uint8, depth int8, quietsTried, capturesTried *MoveList) {
+	bonus := historyBonus(depth)
+
+	if isQuietMove(&search.Pos, move) {
+		contHistories := search.continuationHistories(ply)
+		search.updateQuietHistory(move, bonus, &contHistories)
+		for index := uint8(0); index < quietsTried.Count; index++ {
+			search.updateQuietHistory(quietsTried.Moves[index], -bonus, &contHistories)
+		}
+	} else {
+		search.updateCaptureHistory(move, bonus)
+	}
+
+	for index := uint8(0); index < capturesTried.Count; index++ {
+		search.updateCaptureHistory(capturesTried.Moves[index], -bonus)
+	}
+}
+
+// Update the butterfly history and continuation history scores of a quiet move.
+func (search *Search) updateQuietHistory(move Move, bonus int32, contHistories *[ContinuationPlies]*PieceToHistory) {
+	from, to := move.FromSq(), move.ToSq()
+	moved := search.Pos.Squares[from].Type
+
+	entry := &search.history[search.Pos.SideToMove][from][to]
+	*entry = applyGravity(*entry, bonus)
+
+	for _, table := range contHistories {
+		if table != nil {
+			table[moved][to] = int16(applyGravity(int32(table[moved][to]), bonus))
+		}
+	}
+}
+
+// Update the capture history score of a capture or queen promotion.
+func (search *Search) updateCaptureHistory(move Move, bonus int32) {
+	moved := search.Pos.Squares[move.FromSq()].Type
+	entry := &search.captureHistory[search.Pos.SideToMove][moved][move.ToSq()][capturedType(&search.Pos, move)]
+	*entry = int16(applyGravity(int32(*entry), bonus))
+}
+
+// Get the type of the piece captured by a move, which is a pawn for en passant,
+// and no piece at all for queen promotions which aren't captures.
+func capturedType(pos *Position, move Move) uint8 {
+	if move.MoveType() == Attack && move.Flag() == AttackEP {
+		return Pawn
+	}
+	return pos.Squares[move.ToSq()].Type
+}
+
+// Determine if a move is ordered and scored as a quiet move, rather than as
+// a capture. Queen promotions are treated like captures.
+func isQuietMove(pos *Position, move Move) bool {
+	switch move.MoveType() {
+	case Attack:
+		return false
+	case Promotion:
+		return move.Flag() != QueenPromotion && pos.Squares[move.ToSq()].Type == NoType
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package engine
+
+import "testing"
+
+// Test that history gravity keeps scores within the maximum, no matter how
+// many bonuses or maluses are applied.
+func TestHistoryGravity(t *testing.T) {
+	score := int32(0)
+	for n := 0; n < 1000; n++ {
+		score = applyGravity(score, historyBonus(MaxPly))
+		if score > MaxHistory {
+			t.Fatalf("score %d is above the maximum after %d bonuses", score, n+1)
+		}
+	}
+
+	for n := 0; n < 1000; n++ {
+		score = applyGravity(score, -historyBonus(MaxPly))
+		if score < -MaxHistory {
+			t.Fatalf("score %d is below the minimum after %d maluses", score, n+1)
+		}
+	}
+}
+
+// Test that a quiet move causing a beta cut-off gets a bonus, and the quiet
+// moves tried before it get a malus, in both the butterfly and continuation
+// histories.
+func TestUpdateHistories(t *testing.T) {
+	var search Search
+	search.Pos.LoadFEN(FENStartPosition)
+
+	// Pretend 1. e4 e5 was played to reach the position.
+	search.plyMoves[0] = NewMove(E2, E4, Quiet, NoFlag)
+	search.plyPieces[0] = Pawn
+	search.plyMoves[1] = NewMove(E7, E5, Quiet, NoFlag)
+	search.plyPieces[1] = Pawn
+
+	cutoff := NewMove(G1, F3, Quiet, NoFlag)
+	var quietsTried, capturesTried MoveList
+	quietsTried.AddMove(NewMove(A2, A3, Quiet, NoFlag))
+
+	search.updateHistories(cutoff, 2, 4, &quietsTried, &capturesTried)
+
+	contHistories := search.continuationHistories(2)
+	for index, table := range contHistories {
+		if table == nil {
+			t.Fatalf("continuation history %d is missing", index)
+		}
+	}
+
+	if score := search.quietHistoryScore(cutoff, &contHistories); score <= 0 {
+		t.Errorf("cut-off move has a history score of %d", score)
+	}
+
+	if score := search.quietHistoryScore(quietsTried.Moves[0], &contHistories); score >= 0 {
+		t.Errorf("move tried before the cut-off has a history score of %d", score)
+	}
+}
@@ -135,3 +135,17 @@ func MoveFromCoord(pos *Position, move string) Move {
 	}
 	return NewMove(from, to, moveType, flag)
 }
+
+// Convert a move in UCI format into a Move, and determine if it's a legal
+// move in the given position.
+func ParseLegalMove(pos *Position, moveStr string) (Move, bool) {
+	moves := GenLegalMoves(pos)
+
+	for index := 0; index < int(moves.Count); index++ {
+		if moves.Moves[index].String() == moveStr {
+			return moves.Moves[index], true
+		}
+	}
+
+	return NullMove, false
+}
@@ -35,6 +35,157 @@ func GenMoves(pos *Position) (moves MoveList) {
 	return moves
 }
 
+// Generate all legal moves for a given position. Rather than generating
+// pseduo-legal moves and testing each one by making it, the pieces giving
+// check and the pieces pinned to our king are found first, and used to
+// mask out the moves that would leave our king in check.
+func GenLegalMoves(pos *Position) (moves MoveList) {
+	usBB := pos.SideBB[pos.SideToMove]
+	enemyBB := pos.SideBB[pos.SideToMove^1]
+	allBB := usBB | enemyBB
+	kingSq := pos.PieceBB[pos.SideToMove][King].Msb()
+
+	// Generate the king moves first, since the king is the only piece that can
+	// move when in double check. The king is removed from the board when testing
+	// its destination squares, so it can't hide from a slider along the ray of
+	// the slider's attack.
+	kingMoves := KingMoves[kingSq] & ^usBB
+	for kingMoves != 0 {
+		to := kingMoves.PopBit()
+		if attackersOf(pos, pos.SideToMove, to, allBB^SquareBB[kingSq]) == 0 {
+			moveType := Quiet
+			if SquareBB[to]&enemyBB != 0 {
+				moveType = Attack
+			}
+			moves.AddMove(NewMove(kingSq, to, moveType, NoFlag))
+		}
+	}
+
+	checkers := attackersOf(pos, pos.SideToMove, kingSq, allBB)
+	if checkers.CountBits() > 1 {
+		return moves
+	}
+
+	// If we're in check from a single piece, our other pieces can only move to
+	// capture the checking piece or block its attack.
+	targets := FullBB
+	if checkers != 0 {
+		targets = Between[kingSq][checkers.Msb()] & ^SquareBB[kingSq]
+	}
+
+	// A piece pinned to our king can only move along the ray between our king
+	// and the enemy slider pinning it, which includes capturing the slider.
+	var pinned Bitboard
+	var pinRays [64]Bitboard
+
+	enemyQueens := pos.PieceBB[pos.SideToMove^1][Queen]
+	snipers := (genRookMoves(kingSq, enemyBB) & (pos.PieceBB[pos.SideToMove^1][Rook] | enemyQueens)) |
+		(genBishopMoves(kingSq, enemyBB) & (pos.PieceBB[pos.SideToMove^1][Bishop] | enemyQueens))
+
+	for snipers != 0 {
+		sniperSq := snipers.PopBit()
+		ray := Between[kingSq][sniperSq] & ^SquareBB[kingSq]
+		blockers := ray & allBB & ^SquareBB[sniperSq]
+		if blockers.CountBits() == 1 && blockers&usBB != 0 {
+			pinned |= blockers
+			pinRays[blockers.Msb()] = ray
+		}
+	}
+
+	for piece := uint8(Knight); piece < King; piece++ {
+		piecesBB := pos.PieceBB[pos.SideToMove][piece]
+		for piecesBB != 0 {
+			pieceSq := piecesBB.PopBit()
+			pieceTargets := targets
+			if pinned.BitSet(pieceSq) {
+				pieceTargets &= pinRays[pieceSq]
+			}
+			genPieceMoves(pos, piece, pieceSq, &moves, pieceTargets)
+		}
+	}
+
+	genLegalPawnMoves(pos, &moves, targets, pinned, &pinRays, kingSq)
+
+	// Castling moves are already fully legal, but castling is
+	// never possible while in check.
+	if checkers == 0 {
+		genCastlingMoves(pos, &moves)
+	}
+
+	return moves
+}
+
+// Generate the legal pawn moves for the current side, given the squares the
+// pawns can move to, and the pawns that are pinned to our king.
+func genLegalPawnMoves(pos *Position, moves *MoveList, targets, pinned Bitboard, pinRays *[64]Bitboard, kingSq uint8) {
+	usBB := pos.SideBB[pos.SideToMove]
+	enemyBB := pos.SideBB[pos.SideToMove^1]
+	pawnsBB := pos.PieceBB[pos.SideToMove][Pawn]
+
+	for pawnsBB != 0 {
+		from := pawnsBB.PopBit()
+
+		pawnTargets := targets
+		if pinned.BitSet(from) {
+			pawnTargets &= pinRays[from]
+		}
+
+		pawnOnePush := PawnPushes[pos.SideToMove][from] & ^(usBB | enemyBB)
+		pawnTwoPush := ((pawnOnePush & MaskRank[Rank6]) << 8) & ^(usBB | enemyBB)
+		if pos.SideToMove == White {
+			pawnTwoPush = ((pawnOnePush & MaskRank[Rank3]) >> 8) & ^(usBB | enemyBB)
+		}
+
+		pawnPush := (pawnOnePush | pawnTwoPush) & pawnTargets
+		pawnAttacks := PawnAttacks[pos.SideToMove][from]
+
+		for pawnPush != 0 {
+			to := pawnPush.PopBit()
+			if isPromoting(pos.SideToMove, to) {
+				makePromotionMoves(pos, from, to, moves)
+				continue
+			}
+			moves.AddMove(NewMove(from, to, Quiet, NoFlag))
+		}
+
+		// En passant captures are tested seperately, since they're the only
+		// move removing a piece from a square other than the one moved to.
+		if pawnAttacks.BitSet(pos.EPSq) && epIsLegal(pos, from, kingSq) {
+			moves.AddMove(NewMove(from, pos.EPSq, Attack, AttackEP))
+		}
+
+		pawnAttacks &= enemyBB & pawnTargets
+		for pawnAttacks != 0 {
+			to := pawnAttacks.PopBit()
+			if isPromoting(pos.SideToMove, to) {
+				makePromotionMoves(pos, from, to, moves)
+				continue
+			}
+			moves.AddMove(NewMove(from, to, Attack, NoFlag))
+		}
+	}
+}
+
+// Determine if capturing en passant with the pawn on the given square leaves
+// our king safe, by testing if it's attacked once the capture is made.
+func epIsLegal(pos *Position, from, kingSq uint8) bool {
+	capSq := uint8(int8(pos.EPSq) - pawnPush(pos.SideToMove))
+	allBB := pos.SideBB[White] | pos.SideBB[Black]
+	allBB = (allBB ^ SquareBB[from] ^ SquareBB[capSq]) | SquareBB[pos.EPSq]
+	return attackersOf(pos, pos.SideToMove, kingSq, allBB) & ^SquareBB[capSq] == 0
+}
+
+// Given a side, a square, and the occupancy of the board, get the enemy pieces
+// attacking the square.
+func attackersOf(pos *Position, usColor, sq uint8, allBB Bitboard) Bitboard {
+	enemyQueens := pos.PieceBB[usColor^1][Queen]
+	return (genBishopMoves(sq, allBB) & (pos.PieceBB[usColor^1][Bishop] | enemyQueens) & allBB) |
+		(genRookMoves(sq, allBB) & (pos.PieceBB[usColor^1][Rook] | enemyQueens) & allBB) |
+		(KnightMoves[sq] & pos.PieceBB[usColor^1][Knight]) |
+		(KingMoves[sq] & pos.PieceBB[usColor^1][King]) |
+		(PawnAttacks[usColor][sq] & pos.PieceBB[usColor^1][Pawn])
+}
+
 // Generate all pseduo-legal captures and queen promotions for a given position.
 func genCapturesAndQPromotions(pos *Position) (moves MoveList) {
 	// Go through each piece type, and each piece for that type,
@@ -56,6 +207,50 @@ func genCapturesAndQPromotions(pos *Position) (moves MoveList) {
 	return moves
 }
 
+// Generate all pseduo-legal quiet moves and underpromotions for a given position.
+// Together with the moves from genCapturesAndQPromotions, these are all of the
+// pseduo-legal moves in the position.
+func genQuietsAndUnderPromotions(pos *Position) (moves MoveList) {
+	emptyBB := ^(pos.SideBB[White] | pos.SideBB[Black])
+
+	for piece := uint8(Knight); piece < NoType; piece++ {
+		piecesBB := pos.PieceBB[pos.SideToMove][piece]
+		for piecesBB != 0 {
+			pieceSq := piecesBB.PopBit()
+			genPieceMoves(pos, piece, pieceSq, &moves, emptyBB)
+		}
+	}
+
+	// Generate pawn pushes, and pushes to underpromotions.
+	pawnsBB := pos.PieceBB[pos.SideToMove][Pawn]
+	for pawnsBB != 0 {
+		from := pawnsBB.PopBit()
+
+		pawnOnePush := PawnPushes[pos.SideToMove][from] & emptyBB
+		pawnTwoPush := ((pawnOnePush & MaskRank[Rank6]) << 8) & emptyBB
+		if pos.SideToMove == White {
+			pawnTwoPush = ((pawnOnePush & MaskRank[Rank3]) >> 8) & emptyBB
+		}
+
+		pawnPush := pawnOnePush | pawnTwoPush
+		for pawnPush != 0 {
+			to := pawnPush.PopBit()
+			if isPromoting(pos.SideToMove, to) {
+				moves.AddMove(NewMove(from, to, Promotion, KnightPromotion))
+				moves.AddMove(NewMove(from, to, Promotion, BishopPromotion))
+				moves.AddMove(NewMove(from, to, Promotion, RookPromotion))
+				continue
+			}
+			moves.AddMove(NewMove(from, to, Quiet, NoFlag))
+		}
+	}
+
+	// Generate castling moves.
+	genCastlingMoves(pos, &moves)
+
+	return moves
+}
+
 // Generate the moves a single piece,
 func genPieceMoves(pos *Position, piece, sq uint8, moves *MoveList, targets Bitboard) {
 	// Get a bitboard representing our side and the enemy side.
@@ -350,3 +545,28 @@ func Perft(pos *Position, depth uint8) uint64 {
 	// Return the total amount of nodes for the given position.
 	return nodes
 }
+
+// Same as perft, but uses the legal move generator, so moves
+// don't need to be tested for legality once they've been made.
+func LegalPerft(pos *Position, depth uint8) uint64 {
+	if depth == 0 {
+		return 1
+	}
+
+	// At the last ply, the number of legal moves is the number of
+	// nodes, so there's no need to make each move.
+	moves := GenLegalMoves(pos)
+	if depth == 1 {
+		return uint64(moves.Count)
+	}
+
+	var nodes uint64
+	var idx uint8
+	for idx = 0; idx < moves.Count; idx++ {
+		pos.MakeMove(moves.Moves[idx])
+		nodes += LegalPerft(pos, depth-1)
+		pos.UnmakeMove(moves.Moves[idx])
+	}
+
+	return nodes
+}
@@ -4,7 +4,6 @@ package engine
 // Blunder's move generator is working correctly.
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,50 +14,21 @@ import (
 )
 
 const (
-	MaxPerftDepth        = 7
 	MaxFenStringLength   = 84
 	MaxDepthNumberLength = 2
 	MaxMoveNumberLength  = 10
 )
 
-type PerftTest struct {
-	FEN         string
-	DepthValues [MaxPerftDepth]uint64
-}
-
 // Load the perft test suite
-func loadPerftSuite() (perftTests []PerftTest) {
+func loadPerftSuite() []PerftTest {
 	wd, _ := os.Getwd()
 	parentFolder := filepath.Dir(wd)
 	filePath := filepath.Join(parentFolder, "/testdata/perftsuite.epd")
 
-	file, err := os.Open(filePath)
+	perftTests, err := LoadPerftSuite(filePath)
 	if err != nil {
 		panic(err)
 	}
-	reader := bufio.NewReader(file)
-	scanner := bufio.NewScanner(reader)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Split(line, ";")
-		perftTest := PerftTest{FEN: strings.TrimSpace(fields[0])}
-		perftTest.DepthValues = [MaxPerftDepth]uint64{}
-
-		for _, nodeCountStr := range fields[1:] {
-			depth, err := strconv.Atoi(string(nodeCountStr[1]))
-			if err != nil {
-				panic(fmt.Sprintf("Parsing error on line: %s\n", line))
-			}
-			nodeCountStr = strings.TrimSpace(nodeCountStr[3:])
-			nodeCount, err := strconv.Atoi(nodeCountStr)
-			if err != nil {
-				panic(fmt.Sprintf("Parsing error on line: %s\n", line))
-			}
-			perftTest.DepthValues[depth-1] = uint64(nodeCount)
-		}
-		perftTests = append(perftTests, perftTest)
-	}
 	return perftTests
 }
 
@@ -89,8 +59,8 @@ func printPerftTestRowSeparator() {
 	fmt.Println("+" + strings.Repeat("-", 86) + "+" + "--------+------------+------------+----------+")
 }
 
-// Test blunder against the perft suite
-func TestMovegen(t *testing.T) {
+// Run the perft suite using the given perft function
+func runPerftSuite(t *testing.T, perft func(pos *Position, depth uint8) uint64) {
 	printPerftTestRowSeparator()
 	printPerftTestRow("position", "depth", "expected", "moves", "correct")
 	printPerftTestRowSeparator()
@@ -110,7 +80,7 @@ func TestMovegen(t *testing.T) {
 				continue
 			}
 
-			result := Perft(&pos, uint8(depth)+1)
+			result := perft(&pos, uint8(depth)+1)
 			totalNodes += result
 
 			var correct string
@@ -141,3 +111,89 @@ func TestMovegen(t *testing.T) {
 	fmt.Printf("Time: %vms\n", elapsed.Milliseconds())
 	fmt.Printf("Nps: %d\n", int(float64(totalNodes)/elapsed.Seconds()))
 }
+
+// Test blunder against the perft suite
+func TestMovegen(t *testing.T) {
+	runPerftSuite(t, Perft)
+}
+
+// Test blunder's legal move generator against the perft suite
+func TestLegalMovegen(t *testing.T) {
+	runPerftSuite(t, LegalPerft)
+}
+
+// Test that the legal move generator generates exactly the pseduo-legal
+// moves that don't leave the king in check, at every node of a shallow
+// perft of each position in the suite.
+func TestLegalMovesMatchPseduoLegal(t *testing.T) {
+	var pos Position
+	for _, perftTest := range loadPerftSuite() {
+		pos.LoadFEN(perftTest.FEN)
+		compareLegalMoves(t, &pos, 3)
+	}
+}
+
+func compareLegalMoves(t *testing.T, pos *Position, depth uint8) {
+	legalMoves := GenLegalMoves(pos)
+	isLegal := make(map[Move]bool)
+	for idx := uint8(0); idx < legalMoves.Count; idx++ {
+		isLegal[legalMoves.Moves[idx]] = true
+	}
+
+	moves := GenMoves(pos)
+	var legalCount uint8
+	for idx := uint8(0); idx < moves.Count; idx++ {
+		move := moves.Moves[idx]
+		legal := pos.MakeMove(move)
+		if legal != isLegal[move] {
+			t.Errorf("Legal move generation disagrees on move %v (legal: %v)", move, legal)
+		}
+		if legal {
+			legalCount++
+			if depth > 1 {
+				compareLegalMoves(t, pos, depth-1)
+			}
+		}
+		pos.UnmakeMove(move)
+	}
+
+	if legalCount != legalMoves.Count {
+		t.Errorf("Legal move generator found %d moves instead of %d in %s", legalMoves.Count, legalCount, pos.GenFEN())
+	}
+}
+
+func BenchmarkPerft(b *testing.B) {
+	var pos Position
+	pos.LoadFEN(FENKiwiPete)
+	for i := 0; i < b.N; i++ {
+		Perft(&pos, 3)
+	}
+}
+
+func BenchmarkLegalPerft(b *testing.B) {
+	var pos Position
+	pos.LoadFEN(FENKiwiPete)
+	for i := 0; i < b.N; i++ {
+		LegalPerft(&pos, 3)
+	}
+}
+
+func BenchmarkGenMoves(b *testing.B) {
+	var pos Position
+	pos.LoadFEN(FENKiwiPete)
+	for i := 0; i < b.N; i++ {
+		moves := GenMoves(&pos)
+		for idx := uint8(0); idx < moves.Count; idx++ {
+			pos.MakeMove(moves.Moves[idx])
+			pos.UnmakeMove(moves.Moves[idx])
+		}
+	}
+}
+
+func BenchmarkGenLegalMoves(b *testing.B) {
+	var pos Position
+	pos.LoadFEN(FENKiwiPete)
+	for i := 0; i < b.N; i++ {
+		GenLegalMoves(&pos)
+	}
+}
@@ -0,0 +1,269 @@
+package engine
+
+// movepicker.go implements a staged move picker, which hands the search its
+// moves one at a time, in the order they're most likely to be good. Moves are
+// generated lazily, stage by stage, so if the hash move causes a beta cut-off,
+// no time is wasted generating the rest of the moves.
+
+const (
+	// Move scores are unsigned, so history scores, which can be negative,
+	// are offset to make them positive.
+	HistoryScoreOffset int32 = 32768
+
+	// Constants controlling how much the victim and attacker, and how much
+	// the capture history, count when scoring a capture.
+	MvvLvaScale           int32 = 512
+	CaptureHistoryDivisor int32 = 4
+)
+
+const (
+	// Constants representing the stages of the move picker.
+	StageTTMove uint8 = iota
+	StageGenCaptures
+	StageGoodCaptures
+	StageKillers
+	StageCounterMove
+	StageGenQuiets
+	StageQuiets
+	StageBadCaptures
+	StageDone
+)
+
+// A struct representing a staged move picker.
+type MovePicker struct {
+	search        *Search
+	pos           *Position
+	contHistories [ContinuationPlies]*PieceToHistory
+	stage         uint8
+
+	// Whether only the captures and queen promotions which don't lose
+	// material should be picked, as in quiescence search.
+	capturesOnly bool
+
+	ttMove      Move
+	killers     [MaxKillers]Move
+	killerIndex int
+	counterMove Move
+
+	moves       MoveList
+	index       uint8
+	badCaptures MoveList
+	badIndex    uint8
+}
+
+// Create a move picker for a node of the main search, given the move from the
+// transposition table, and the killer and counter move of the node. Any of these
+// moves which aren't valid in the current position are skipped.
+func (search *Search) newMovePicker(ttMove Move, ply uint8) (mp MovePicker) {
+	mp.search = search
+	mp.pos = &search.Pos
+	mp.contHistories = search.continuationHistories(ply)
+	mp.ttMove = ttMove
+	mp.killers = search.killers[ply]
+	mp.counterMove = search.counterMove(ply)
+	return mp
+}
+
+// Create a move picker for quiescence search, which only picks the captures and
+// queen promotions that don't lose material according to the static exchange
+// evaluation.
+func (search *Search) newQsearchMovePicker() (mp MovePicker) {
+	mp.search = search
+	mp.pos = &search.Pos
+	mp.capturesOnly = true
+	mp.stage = StageGenCaptures
+	return mp
+}
+
+// Get the next pseduo-legal move to search, or a null move once every move
+// has been picked.
+func (mp *MovePicker) Next() Move {
+	for {
+		switch mp.stage {
+		case StageTTMove:
+			mp.stage++
+			if mp.isPseduoLegal(mp.ttMove) {
+				return mp.ttMove
+			}
+			mp.ttMove = NullMove
+
+		case StageGenCaptures:
+			mp.moves = genCapturesAndQPromotions(mp.pos)
+			mp.index = 0
+			mp.scoreCaptures()
+			mp.stage++
+
+		case StageGoodCaptures:
+			for mp.index < mp.moves.Count {
+				move := mp.pickBest()
+				if move.Equal(mp.ttMove) {
+					continue
+				}
+
+				// Captures which lose material are tried after the quiet moves,
+				// or not at all in quiescence search.
+				if mp.pos.See(move) < 0 {
+					if !mp.capturesOnly {
+						mp.badCaptures.AddMove(move)
+					}
+					continue
+				}
+				return move
+			}
+
+			mp.stage++
+			if mp.capturesOnly {
+				mp.stage = StageDone
+			}
+
+		case StageKillers:
+			for mp.killerIndex < MaxKillers {
+				killer := mp.killers[mp.killerIndex]
+				mp.killerIndex++
+				if !killer.Equal(mp.ttMove) && mp.isQuietCandidate(killer) {
+					return killer
+				}
+				mp.killers[mp.killerIndex-1] = NullMove
+			}
+			mp.stage++
+
+		case StageCounterMove:
+			mp.stage++
+			counterMove := mp.counterMove
+			mp.counterMove = NullMove
+			if !counterMove.Equal(mp.ttMove) && !mp.isKiller(counterMove) && mp.isQuietCandidate(counterMove) {
+				mp.counterMove = counterMove
+				return counterMove
+			}
+
+		case StageGenQuiets:
+			mp.moves = genQuietsAndUnderPromotions(mp.pos)
+			mp.index = 0
+			mp.scoreQuiets()
+			mp.stage++
+
+		case StageQuiets:
+			for mp.index < mp.moves.Count {
+				move := mp.pickBest()
+				if move.Equal(mp.ttMove) || mp.isKiller(move) || move.Equal(mp.counterMove) {
+					continue
+				}
+				return move
+			}
+			mp.stage++
+
+		case StageBadCaptures:
+			if mp.badIndex < mp.badCaptures.Count {
+				move := mp.badCaptures.Moves[mp.badIndex]
+				mp.badIndex++
+				return move
+			}
+			mp.stage++
+
+		default:
+			return NullMove
+		}
+	}
+}
+
+// Get the stage the move picker is in.
+func (mp *MovePicker) Stage() uint8 {
+	return mp.stage
+}
+
+// Pick the best scoring move left in the current stage's move list.
+func (mp *MovePicker) pickBest() Move {
+	orderMoves(int(mp.index), &mp.moves)
+	move := mp.moves.Moves[mp.index]
+	mp.index++
+	return move
+}
+
+// Score the captures and queen promotions using MVV-LVA, breaking ties between
+// captures of the same piece with the capture history.
+func (mp *MovePicker) scoreCaptures() {
+	for index := uint8(0); index < mp.moves.Count; index++ {
+		move := &mp.moves.Moves[index]
+		captured := mp.pos.Squares[move.ToSq()]
+		moved := mp.pos.Squares[move.FromSq()]
+		score := int32(MvvLva[captured.Type][moved.Type])*MvvLvaScale + int32(mp.search.captureHistoryScore(*move))/CaptureHistoryDivisor
+		move.AddScore(uint16(HistoryScoreOffset + score))
+	}
+}
+
+// Score the quiet moves using the butterfly and continuation histories.
+func (mp *MovePicker) scoreQuiets() {
+	for index := uint8(0); index < mp.moves.Count; index++ {
+		move := &mp.moves.Moves[index]
+		score := mp.search.quietHistoryScore(*move, &mp.contHistories)
+		move.AddScore(uint16(HistoryScoreOffset + score))
+	}
+}
+
+// Determine if the given move was picked as one of the killers.
+func (mp *MovePicker) isKiller(move Move) bool {
+	for _, killer := range mp.killers {
+		if move.Equal(killer) {
+			return true
+		}
+	}
+	return false
+}
+
+// Determine if the given move is a pseduo-legal move that would be
+// generated in the quiet move stage. Only these moves can be picked
+// as killers or counter moves, so they're never picked twice.
+func (mp *MovePicker) isQuietCandidate(move Move) bool {
+	return isQuietMove(mp.pos, move) && mp.isPseduoLegal(move)
+}
+
+// Determine if the given move, which might come from a different position,
+// is a pseduo-legal move in the current position.
+func (mp *MovePicker) isPseduoLegal(move Move) bool {
+	if move.Equal(NullMove) {
+		return false
+	}
+
+	pos := mp.pos
+	fromSq, toSq := move.FromSq(), move.ToSq()
+	moved := pos.Squares[fromSq]
+	captured := pos.Squares[toSq]
+
+	if moved.Color != pos.SideToMove {
+		return false
+	}
+
+	promoting := moved.Type == Pawn && isPromoting(pos.SideToMove, toSq)
+
+	switch move.MoveType() {
+	case Castle:
+		// Castling is only generated when it's legal, so the simplest way
+		// to validate a castling move is to generate them.
+		var castlingMoves MoveList
+		genCastlingMoves(pos, &castlingMoves)
+		for index := uint8(0); index < castlingMoves.Count; index++ {
+			if castlingMoves.Moves[index].Equal(move) {
+				return true
+			}
+		}
+		return false
+	case Attack:
+		if move.Flag() == AttackEP {
+			return moved.Type == Pawn && toSq == pos.EPSq &&
+				PawnAttacks[pos.SideToMove][fromSq]&SquareBB[toSq] != 0
+		}
+		if captured.Type == NoType || promoting {
+			return false
+		}
+	case Quiet:
+		if captured.Type != NoType || promoting {
+			return false
+		}
+	case Promotion:
+		if !promoting {
+			return false
+		}
+	}
+
+	return pos.MoveIsPseduoLegal(move)
+}
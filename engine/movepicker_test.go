@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"testing"
+)
+
+// Test that, given hash, killer, and counter moves taken from other positions,
+// the move picker picks each pseduo-legal move of a position exactly once.
+func TestMovePickerPicksEveryMove(t *testing.T) {
+	var search Search
+	for _, perftTest := range loadPerftSuite() {
+		search.Pos.LoadFEN(perftTest.FEN)
+		checkMovePicker(t, &search, 2, MoveList{})
+	}
+}
+
+func checkMovePicker(t *testing.T, search *Search, depth uint8, candidates MoveList) {
+	pos := &search.Pos
+	moves := GenMoves(pos)
+
+	// Try every move from the parent position and this one as the hash move,
+	// with the next two as killers, and the one after as the counter move.
+	for index := uint8(0); index < moves.Count; index++ {
+		candidates.AddMove(moves.Moves[index])
+	}
+
+	for index := 0; index < int(candidates.Count); index++ {
+		mp := MovePicker{search: search, pos: pos}
+		mp.ttMove = candidates.Moves[index]
+		mp.killers[0] = candidates.Moves[(index+1)%int(candidates.Count)]
+		mp.killers[1] = candidates.Moves[(index+2)%int(candidates.Count)]
+		mp.counterMove = candidates.Moves[(index+3)%int(candidates.Count)]
+
+		picked := map[Move]int{}
+		for move := mp.Next(); !move.Equal(NullMove); move = mp.Next() {
+			picked[NewMove(move.FromSq(), move.ToSq(), move.MoveType(), move.Flag())]++
+		}
+
+		if len(picked) != int(moves.Count) {
+			t.Fatalf("%s: picked %d distinct moves instead of %d", pos.GenFEN(), len(picked), moves.Count)
+		}
+
+		for moveIndex := uint8(0); moveIndex < moves.Count; moveIndex++ {
+			move := moves.Moves[moveIndex]
+			if picked[move] != 1 {
+				t.Fatalf("%s: move %v picked %d times", pos.GenFEN(), move, picked[move])
+			}
+		}
+	}
+
+	if depth == 1 {
+		return
+	}
+
+	for index := uint8(0); index < moves.Count; index++ {
+		move := moves.Moves[index]
+		if pos.MakeMove(move) {
+			checkMovePicker(t, search, depth-1, moves)
+		}
+		pos.UnmakeMove(move)
+	}
+}
+
+// Test that a valid hash move is picked first, before any moves are generated.
+func TestMovePickerHashMoveFirst(t *testing.T) {
+	var search Search
+	search.Pos.LoadFEN(FENKiwiPete)
+
+	ttMove := NewMove(E2, A6, Attack, NoFlag)
+	mp := search.newMovePicker(ttMove, 0)
+
+	if move := mp.Next(); !move.Equal(ttMove) {
+		t.Fatalf("picked %v first instead of the hash move %v", move, ttMove)
+	}
+
+	if mp.Stage() != StageGenCaptures {
+		t.Errorf("moves were generated before the hash move was searched")
+	}
+}
+
+// Test that losing captures are picked after the quiet moves, and aren't
+// picked at all in quiescence search.
+func TestMovePickerBadCaptures(t *testing.T) {
+	var search Search
+
+	// Taking the pawn on d5 with the queen loses the queen.
+	search.Pos.LoadFEN("4k3/8/2p5/3p4/8/8/3Q4/4K3 w - - 0 1")
+	badCapture := NewMove(D2, D5, Attack, NoFlag)
+
+	mp := search.newMovePicker(NullMove, 0)
+	var last Move
+	for move := mp.Next(); !move.Equal(NullMove); move = mp.Next() {
+		last = move
+	}
+
+	if !last.Equal(badCapture) {
+		t.Errorf("picked %v last instead of the losing capture %v", last, badCapture)
+	}
+
+	mp = search.newQsearchMovePicker()
+	if move := mp.Next(); !move.Equal(NullMove) {
+		t.Errorf("picked %v in quiescence search instead of no move", move)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !debug
+// +build !debug
+
+package engine
+
+// Whether the engine was built with the debug tag, which validates the
+// position after every move made and unmade.
+const DebugMode = false
@@ -0,0 +1,442 @@
+package engine
+
+// perft.go implements the tooling built on top of perft: a hashed perft, which
+// caches the node counts of the positions it has already counted, a parallel
+// perft, which splits the moves at the root between several goroutines, and a
+// checker for perft suites in the EPD format, which narrows each mismatch down
+// to the position where the move generators go wrong.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Default size of the perft hash table, in MB.
+	DefaultPerftTableSize = 64
+
+	// The size of a perft hash table entry, in bytes.
+	PerftEntrySize = 16
+
+	// The bits of an entry's data which hold the depth. The other bits
+	// hold the node count.
+	perftDepthMask uint64 = 0xff
+)
+
+// An entry of the perft hash table. The data packs the node count together
+// with the depth it was counted to, and the key is the position's hash XORed
+// with the data. An entry torn by two goroutines writing to it at once won't
+// have a matching key, so the table can be shared without locking.
+type PerftEntry struct {
+	Key  uint64
+	Data uint64
+}
+
+// A hash table of perft node counts, keyed by the hash of a position and the
+// depth its nodes were counted to.
+type PerftTable struct {
+	entries []PerftEntry
+	mask    uint64
+}
+
+// Create a perft hash table of the given size in MB. The number of entries is
+// rounded down to a power of two.
+func NewPerftTable(sizeInMB uint64) *PerftTable {
+	count := uint64(1)
+	for count*2*PerftEntrySize <= sizeInMB*1024*1024 {
+		count *= 2
+	}
+
+	return &PerftTable{
+		entries: make([]PerftEntry, count),
+		mask:    count - 1,
+	}
+}
+
+// Get the node count of the position with the given hash at the given depth,
+// if the table has it. A nil table never has a node count.
+func (pt *PerftTable) Probe(hash uint64, depth uint8) (uint64, bool) {
+	if pt == nil {
+		return 0, false
+	}
+
+	entry := &pt.entries[hash&pt.mask]
+	data := atomic.LoadUint64(&entry.Data)
+	key := atomic.LoadUint64(&entry.Key)
+	if key^data != hash || uint8(data&perftDepthMask) != depth {
+		return 0, false
+	}
+	return data >> 8, true
+}
+
+// Store the node count of the position with the given hash at the given depth,
+// replacing whatever the entry held before.
+func (pt *PerftTable) Store(hash uint64, depth uint8, nodes uint64) {
+	if pt == nil {
+		return
+	}
+
+	entry := &pt.entries[hash&pt.mask]
+	data := nodes<<8 | uint64(depth)
+	atomic.StoreUint64(&entry.Key, hash^data)
+	atomic.StoreUint64(&entry.Data, data)
+}
+
+// Clear the perft hash table.
+func (pt *PerftTable) Clear() {
+	for idx := range pt.entries {
+		pt.entries[idx] = PerftEntry{}
+	}
+}
+
+// Same as the legal perft, but the node counts of the positions counted are
+// stored in the given table, so positions reached again through transpositions
+// don't need to be counted twice.
+func HashPerft(pos *Position, depth uint8, table *PerftTable) uint64 {
+	if depth == 0 {
+		return 1
+	}
+
+	moves := GenLegalMoves(pos)
+	if depth == 1 {
+		return uint64(moves.Count)
+	}
+
+	if nodes, ok := table.Probe(pos.Hash, depth); ok {
+		return nodes
+	}
+
+	var nodes uint64
+	var idx uint8
+	for idx = 0; idx < moves.Count; idx++ {
+		pos.MakeMove(moves.Moves[idx])
+		nodes += HashPerft(pos, depth-1, table)
+		pos.UnmakeMove(moves.Moves[idx])
+	}
+
+	table.Store(pos.Hash, depth, nodes)
+	return nodes
+}
+
+// The number of nodes below a move at the root of a perft.
+type MoveNodes struct {
+	Move  Move
+	Nodes uint64
+}
+
+// Count the nodes below each legal move of the position to the given depth,
+// using the given number of goroutines, or one for each CPU if it's zero.
+// The moves are handed out to the goroutines one at a time, and each of them
+// counts the nodes of the moves it gets with its own copy of the position,
+// sharing the given table. The counts are returned in the order the moves
+// were generated.
+//
+// The position must be legal, or an error is returned before any goroutine
+// is started. If a goroutine panics anyway, the panic is returned as an error
+// once the others are done, rather than crashing the program.
+func ParallelDividePerft(pos *Position, depth uint8, table *PerftTable, threads int) ([]MoveNodes, error) {
+	if err := pos.ValidateLegal(); err != nil {
+		return nil, err
+	}
+
+	if depth == 0 {
+		return nil, nil
+	}
+
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	moves := GenLegalMoves(pos)
+	results := make([]MoveNodes, moves.Count)
+	indexes := make(chan int, moves.Count)
+	for idx := uint8(0); idx < moves.Count; idx++ {
+		results[idx].Move = moves.Moves[idx]
+		indexes <- int(idx)
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var err error
+	for thread := 0; thread < threads; thread++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errOnce.Do(func() { err = fmt.Errorf("perft panicked: %v", r) })
+				}
+			}()
+
+			worker := pos.Copy()
+			for idx := range indexes {
+				move := results[idx].Move
+				worker.MakeMove(move)
+				results[idx].Nodes = HashPerft(&worker, depth-1, table)
+				worker.UnmakeMove(move)
+			}
+		}()
+	}
+
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Same as the hashed perft, but the moves at the root are split between the
+// given number of goroutines, or one for each CPU if it's zero.
+func ParallelPerft(pos *Position, depth uint8, table *PerftTable, threads int) (uint64, error) {
+	results, err := ParallelDividePerft(pos, depth, table, threads)
+	if err != nil {
+		return 0, err
+	}
+
+	if depth == 0 {
+		return 1, nil
+	}
+
+	var nodes uint64
+	for _, result := range results {
+		nodes += result.Nodes
+	}
+	return nodes, nil
+}
+
+// A position of a perft suite, and the node counts expected at each depth.
+// The count for a depth is stored at the index one less than the depth, and
+// is zero if the suite doesn't give one.
+type PerftTest struct {
+	FEN         string
+	DepthValues []uint64
+}
+
+// Parse a perft suite in the EPD format, where each line holds a FEN string,
+// followed by the expected node counts at each depth, separated by semicolons:
+//
+//	<FEN> ;D1 20 ;D2 400 ;D3 8902
+//
+// Blank lines, and lines starting with a '#', are skipped.
+func ParsePerftSuite(reader io.Reader) (perftTests []PerftTest, err error) {
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ";")
+		perftTest := PerftTest{FEN: strings.TrimSpace(fields[0])}
+
+		for _, field := range fields[1:] {
+			parts := strings.Fields(field)
+			if len(parts) == 0 {
+				continue
+			}
+
+			if len(parts) != 2 || len(parts[0]) < 2 || (parts[0][0] != 'D' && parts[0][0] != 'd') {
+				return nil, fmt.Errorf("line %d: malformed depth entry %q", lineNumber, strings.TrimSpace(field))
+			}
+
+			depth, err := strconv.Atoi(parts[0][1:])
+			if err != nil || depth < 1 || depth > PerftDepthLimit {
+				return nil, fmt.Errorf("line %d: invalid depth %q", lineNumber, parts[0][1:])
+			}
+
+			nodes, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid node count %q", lineNumber, parts[1])
+			}
+
+			for len(perftTest.DepthValues) < depth {
+				perftTest.DepthValues = append(perftTest.DepthValues, 0)
+			}
+			perftTest.DepthValues[depth-1] = nodes
+		}
+
+		perftTests = append(perftTests, perftTest)
+	}
+
+	return perftTests, scanner.Err()
+}
+
+// Load a perft suite in the EPD format from the given file.
+func LoadPerftSuite(path string) ([]PerftTest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParsePerftSuite(file)
+}
+
+// A mismatch between the node count expected by a perft suite and the node
+// count found, or the error the perft failed with, if the position couldn't
+// be counted at all.
+type PerftMismatch struct {
+	FEN      string
+	Depth    uint8
+	Expected uint64
+	Found    uint64
+	Err      error
+
+	// The moves leading from the position to the first position where the
+	// pseduo-legal and legal move generators disagree on the node count of
+	// a move, the FEN string of that position, and the moves generated by
+	// only one of the generators in it. If the generators always agree, the
+	// path is empty, and the divide of the position should be compared with
+	// another engine's instead.
+	Path          []Move
+	DivergenceFEN string
+	PseduoOnly    []Move
+	LegalOnly     []Move
+}
+
+// Describe the mismatch.
+func (mismatch PerftMismatch) String() string {
+	if mismatch.Err != nil {
+		return fmt.Sprintf("%s at depth %d: %v\n", mismatch.FEN, mismatch.Depth, mismatch.Err)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(
+		&sb, "%s at depth %d: expected %d nodes, found %d\n",
+		mismatch.FEN, mismatch.Depth, mismatch.Expected, mismatch.Found,
+	)
+
+	if mismatch.DivergenceFEN == "" {
+		sb.WriteString("  the move generators agree, compare the divide with another engine's\n")
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "  the move generators disagree after %v, in %s\n", mismatch.Path, mismatch.DivergenceFEN)
+	if len(mismatch.PseduoOnly) != 0 {
+		fmt.Fprintf(&sb, "  only generated by the pseduo-legal generator: %v\n", mismatch.PseduoOnly)
+	}
+	if len(mismatch.LegalOnly) != 0 {
+		fmt.Fprintf(&sb, "  only generated by the legal generator: %v\n", mismatch.LegalOnly)
+	}
+	return sb.String()
+}
+
+// Divide the perft of the position with the pseduo-legal and the legal move
+// generators, and follow the first move whose node counts differ, until the
+// position where the generators generate different moves is found. The path
+// to it is recorded in the mismatch.
+func findPerftDivergence(pos *Position, depth uint8, mismatch *PerftMismatch) bool {
+	var pseduoMoves []MoveNodes
+	moves := GenMoves(pos)
+	for idx := uint8(0); idx < moves.Count; idx++ {
+		move := moves.Moves[idx]
+		if pos.MakeMove(move) {
+			pseduoMoves = append(pseduoMoves, MoveNodes{move, Perft(pos, depth-1)})
+		}
+		pos.UnmakeMove(move)
+	}
+
+	legalNodes := map[Move]uint64{}
+	moves = GenLegalMoves(pos)
+	for idx := uint8(0); idx < moves.Count; idx++ {
+		move := moves.Moves[idx]
+		pos.MakeMove(move)
+		legalNodes[move] = LegalPerft(pos, depth-1)
+		pos.UnmakeMove(move)
+	}
+
+	pseduoGenerated := map[Move]bool{}
+	for _, result := range pseduoMoves {
+		pseduoGenerated[result.Move] = true
+		if _, ok := legalNodes[result.Move]; !ok {
+			mismatch.PseduoOnly = append(mismatch.PseduoOnly, result.Move)
+		}
+	}
+	for idx := uint8(0); idx < moves.Count; idx++ {
+		if !pseduoGenerated[moves.Moves[idx]] {
+			mismatch.LegalOnly = append(mismatch.LegalOnly, moves.Moves[idx])
+		}
+	}
+
+	if len(mismatch.PseduoOnly) != 0 || len(mismatch.LegalOnly) != 0 {
+		mismatch.DivergenceFEN = pos.GenFEN()
+		return true
+	}
+
+	if depth == 1 {
+		return false
+	}
+
+	for _, result := range pseduoMoves {
+		if legalNodes[result.Move] == result.Nodes {
+			continue
+		}
+
+		pos.MakeMove(result.Move)
+		mismatch.Path = append(mismatch.Path, result.Move)
+		found := findPerftDivergence(pos, depth-1, mismatch)
+		pos.UnmakeMove(result.Move)
+
+		if found {
+			return true
+		}
+		mismatch.Path = mismatch.Path[:len(mismatch.Path)-1]
+	}
+
+	return false
+}
+
+// Run each test of the perft suite with the parallel perft, and report every
+// node count that doesn't match the expected count. If a test's position
+// can't be counted, the error is reported once, and its other depths are
+// skipped.
+func CheckPerftSuite(perftTests []PerftTest, table *PerftTable, threads int) (mismatches []PerftMismatch) {
+	var pos Position
+	for _, perftTest := range perftTests {
+		pos.LoadFEN(perftTest.FEN)
+
+		for depthIdx, expected := range perftTest.DepthValues {
+			if expected == 0 {
+				continue
+			}
+
+			depth := uint8(depthIdx + 1)
+			found, err := ParallelPerft(&pos, depth, table, threads)
+			if err != nil {
+				mismatches = append(mismatches, PerftMismatch{
+					FEN:      perftTest.FEN,
+					Depth:    depth,
+					Expected: expected,
+					Err:      err,
+				})
+				break
+			}
+
+			if found == expected {
+				continue
+			}
+
+			mismatch := PerftMismatch{
+				FEN:      perftTest.FEN,
+				Depth:    depth,
+				Expected: expected,
+				Found:    found,
+			}
+			findPerftDivergence(&pos, depth, &mismatch)
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+
+	return mismatches
+}
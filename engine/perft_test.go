@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test the hashed perft against the perft suite, sharing one table between
+// every position.
+func TestHashMovegen(t *testing.T) {
+	table := NewPerftTable(16)
+	runPerftSuite(t, func(pos *Position, depth uint8) uint64 {
+		return HashPerft(pos, depth, table)
+	})
+}
+
+// Test the parallel perft against the perft suite.
+func TestParallelMovegen(t *testing.T) {
+	table := NewPerftTable(16)
+	runPerftSuite(t, func(pos *Position, depth uint8) uint64 {
+		nodes, err := ParallelPerft(pos, depth, table, 4)
+		if err != nil {
+			t.Fatalf("Parallel perft of %s failed: %v", pos.GenFEN(), err)
+		}
+		return nodes
+	})
+}
+
+// The parallel divide should count the same nodes below each move as the
+// divide of the plain perft, and leave the position it was given alone.
+func TestParallelDividePerft(t *testing.T) {
+	var pos Position
+	pos.LoadFEN(FENKiwiPete)
+	pos.MakeMove(MoveFromCoord(&pos, "e1g1"))
+	fen, moves := pos.GenFEN(), pos.movePath()
+
+	results, err := ParallelDividePerft(&pos, 3, NewPerftTable(1), 0)
+	if err != nil {
+		t.Fatalf("Parallel divide failed: %v", err)
+	}
+
+	for _, result := range results {
+		pos.MakeMove(result.Move)
+		expected := Perft(&pos, 2)
+		pos.UnmakeMove(result.Move)
+
+		if result.Nodes != expected {
+			t.Errorf("Parallel divide found %d nodes below %v instead of %d", result.Nodes, result.Move, expected)
+		}
+	}
+
+	if pos.GenFEN() != fen || len(pos.movePath()) != len(moves) || len(pos.history) != len(moves)+1 {
+		t.Errorf("Parallel divide changed the position to %s after %v", pos.GenFEN(), pos.movePath())
+	}
+}
+
+// The parallel divide should refuse an illegal position, and return a panic
+// in one of its goroutines as an error instead of crashing.
+func TestParallelDividePerftErrors(t *testing.T) {
+	var pos Position
+	pos.LoadFEN("4k3/4R3/8/8/8/8/8/4K3 w - - 0 1")
+	if _, err := ParallelDividePerft(&pos, 2, nil, 2); err == nil {
+		t.Errorf("Parallel divide of a position with the side not to move in check didn't fail")
+	}
+
+	// A table without any entries panics when it's probed.
+	pos.LoadFEN(FENStartPosition)
+	if _, err := ParallelDividePerft(&pos, 3, &PerftTable{}, 2); err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Errorf("Parallel divide with a broken table returned %v instead of the panic", err)
+	}
+}
+
+func TestParsePerftSuite(t *testing.T) {
+	suite := "# a comment\n\n" +
+		FENStartPosition + " ;D1 20 ;D3 8902\n" +
+		FENKiwiPete + ";d2   2039;\n"
+
+	perftTests, err := ParsePerftSuite(strings.NewReader(suite))
+	if err != nil {
+		t.Fatalf("Parsing the suite failed: %v", err)
+	}
+
+	if len(perftTests) != 2 {
+		t.Fatalf("Parsed %d tests instead of 2", len(perftTests))
+	}
+
+	expected := []PerftTest{
+		{FEN: FENStartPosition, DepthValues: []uint64{20, 0, 8902}},
+		{FEN: FENKiwiPete, DepthValues: []uint64{0, 2039}},
+	}
+	for index, perftTest := range perftTests {
+		if perftTest.FEN != expected[index].FEN || len(perftTest.DepthValues) != len(expected[index].DepthValues) {
+			t.Fatalf("Parsed %+v instead of %+v", perftTest, expected[index])
+		}
+		for depth, nodes := range perftTest.DepthValues {
+			if nodes != expected[index].DepthValues[depth] {
+				t.Errorf("Parsed %+v instead of %+v", perftTest, expected[index])
+			}
+		}
+	}
+
+	for _, line := range []string{
+		FENStartPosition + " ;D1",
+		FENStartPosition + " ;X1 20",
+		FENStartPosition + " ;D1 twenty",
+	} {
+		if _, err := ParsePerftSuite(strings.NewReader(line)); err == nil {
+			t.Errorf("Parsing %q should have failed", line)
+		}
+	}
+}
+
+// A wrong expected count, with move generators that agree with each other,
+// should be reported as a mismatch without a divergence.
+func TestCheckPerftSuite(t *testing.T) {
+	perftTests := []PerftTest{
+		{FEN: FENStartPosition, DepthValues: []uint64{20, 400, 8903}},
+	}
+
+	mismatches := CheckPerftSuite(perftTests, NewPerftTable(1), 2)
+	if len(mismatches) != 1 {
+		t.Fatalf("Found %d mismatches instead of 1", len(mismatches))
+	}
+
+	mismatch := mismatches[0]
+	if mismatch.Depth != 3 || mismatch.Expected != 8903 || mismatch.Found != 8902 {
+		t.Errorf("Found the wrong mismatch: %+v", mismatch)
+	}
+	if mismatch.DivergenceFEN != "" || len(mismatch.Path) != 0 {
+		t.Errorf("Found a divergence between the move generators: %v", mismatch)
+	}
+
+	// An illegal position should be reported once, with the error.
+	perftTests = []PerftTest{
+		{FEN: "4k3/4R3/8/8/8/8/8/4K3 w - - 0 1", DepthValues: []uint64{1, 2}},
+	}
+	mismatches = CheckPerftSuite(perftTests, NewPerftTable(1), 2)
+	if len(mismatches) != 1 || mismatches[0].Err == nil {
+		t.Errorf("Found %v instead of an error for the illegal position", mismatches)
+	}
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"unicode"
@@ -55,20 +56,11 @@ const (
 	NorthDelta = 8
 	SouthDelta = -8
 
-	// A constant representing the maximum game ply,
-	// used to initalize the array for holding repetition
-	// detection history.
-	MaxGamePly = 1024
+	// The number of moves the state and history stacks of a position have
+	// room for when it's loaded. The stacks grow past it as needed.
+	InitialStackSize = 256
 )
 
-// A global array to store position histories. As this array has
-// a quite large memory footprint, it's implemented as a single global
-// variable, rather than being part of a Position instance.
-var PositionHistories [MaxGamePly]uint64
-
-// A global variable to index into the position histories.
-var HistoryPly uint16
-
 // A 64 element array where each entry, when bitwise ANDed with the
 // castling rights, destorys the correct bit in the castling rights
 // if a move to or from that square would take away castling rights.
@@ -132,10 +124,14 @@ type Piece struct {
 type State struct {
 	CastlingRights uint8
 	EPSq           uint8
-	Rule50         uint8
+	Rule50         uint16
 
 	Captured Piece
 	Moved    Piece
+
+	// The move made, so the moves leading to a position can be recovered
+	// when debugging.
+	Move Move
 }
 
 // A struct reprenting Blunder's core internal position representation, which consists
@@ -154,17 +150,33 @@ type Position struct {
 	// 00000001 = black queenside castling right
 	CastlingRights uint8
 
-	// The zobrist hash of the position
-	Hash uint64
+	// The zobrist hash of the position, and the zobrist hash of only
+	// its pawns, which is used to index the pawn hash table.
+	Hash     uint64
+	PawnHash uint64
+
+	// The material and piece-square table scores of each side in the
+	// middle-game and end-game, and the sum of the phase values of the
+	// pieces on the board, all kept updated as pieces are put on and
+	// cleared from the board.
+	MGScores [2]int16
+	EGScores [2]int16
+	Phase    int16
 
 	SideToMove uint8
 	EPSq       uint8
 
 	Ply    uint16
-	Rule50 uint8
-
-	prevStates [100]State
-	StatePly   uint8
+	Rule50 uint16
+
+	// The state of the irreversible aspects of the position before each
+	// move made since the position was loaded, and the zobrist hash of each
+	// position reached since, the current one last, used for repetition
+	// detection. Both stacks grow with the game. Since a copy of a position
+	// shares them, a copy that will have moves made in it while the original
+	// is still in use must be made with Copy.
+	prevStates []State
+	history    []uint64
 }
 
 func (pos *Position) MakeMove(move Move) bool {
@@ -182,6 +194,7 @@ func (pos *Position) MakeMove(move Move) bool {
 		Rule50:         pos.Rule50,
 		Captured:       pos.Squares[to],
 		Moved:          pos.Squares[from],
+		Move:           move,
 	}
 
 	// Increment the game ply and the fifty-move rule counter
@@ -269,27 +282,28 @@ func (pos *Position) MakeMove(move Move) bool {
 	// Update the zobrist hash if the en passant square was set
 	pos.Hash ^= Zobrist.EPNumber(pos.EPSq)
 
-	// Save the State object and increment the stack counter
-	// to point to the next empty slot in the position state history.
-	pos.prevStates[pos.StatePly] = state
-	pos.StatePly++
+	// Push the State object onto the position's state stack.
+	pos.prevStates = append(pos.prevStates, state)
 
 	// Flip the side to move and update the zobrist hash
 	pos.SideToMove ^= 1
 	pos.Hash ^= Zobrist.SideToMoveNumber(pos.SideToMove)
 
-	// Save the current zobrist in the position history array.
-	HistoryPly++
-	PositionHistories[HistoryPly] = pos.Hash
+	// Save the current zobrist in the position history.
+	pos.history = append(pos.history, pos.Hash)
+
+	if DebugMode {
+		pos.debugValidate("making", move)
+	}
 
 	// Test if the move was legal or not, and let the caller know.
 	return !sqIsAttacked(pos, pos.SideToMove^1, pos.PieceBB[pos.SideToMove^1][King].Msb())
 }
 
 func (pos *Position) UnmakeMove(move Move) {
-	// Get the State object for this move
-	pos.StatePly--
-	state := pos.prevStates[pos.StatePly]
+	// Pop the State object for this move
+	state := pos.prevStates[len(pos.prevStates)-1]
+	pos.prevStates = pos.prevStates[:len(pos.prevStates)-1]
 
 	// remove the en passant zobrist number if there was one in the position
 	// we're undoing, and remove the castling rights zobrist number.
@@ -297,7 +311,7 @@ func (pos *Position) UnmakeMove(move Move) {
 	pos.Hash ^= Zobrist.CastlingNumber(pos.CastlingRights)
 
 	// Remove the current positions from the position history
-	HistoryPly--
+	pos.history = pos.history[:len(pos.history)-1]
 
 	// Restore the irreversible aspects of the position using the State object.
 	pos.CastlingRights = state.CastlingRights
@@ -365,6 +379,10 @@ func (pos *Position) UnmakeMove(move Move) {
 			}
 		}
 	}
+
+	if DebugMode {
+		pos.debugValidate("unmaking", move)
+	}
 }
 
 // Make a "null"-move for null-move pruning:
@@ -377,10 +395,8 @@ func (pos *Position) MakeNullMove() {
 		Rule50:         pos.Rule50,
 	}
 
-	// Save the State object and increment the stack counter
-	// to point to the next empty slot in the position state history.
-	pos.prevStates[pos.StatePly] = state
-	pos.StatePly++
+	// Push the State object onto the position's state stack.
+	pos.prevStates = append(pos.prevStates, state)
 
 	// Clear the en passant square and en passant zobrist number
 	pos.Hash ^= Zobrist.EPNumber(pos.EPSq)
@@ -397,16 +413,18 @@ func (pos *Position) MakeNullMove() {
 	pos.SideToMove ^= 1
 	pos.Hash ^= Zobrist.SideToMoveNumber(pos.SideToMove)
 
-	// Save the current zobrist in the position history array.
-	HistoryPly++
-	PositionHistories[HistoryPly] = pos.Hash
+	// Save the current zobrist in the position history.
+	pos.history = append(pos.history, pos.Hash)
 
+	if DebugMode {
+		pos.debugValidate("making", NullMove)
+	}
 }
 
 func (pos *Position) UnmakeNullMove() {
-	// Get the State object for the null move
-	pos.StatePly--
-	state := pos.prevStates[pos.StatePly]
+	// Pop the State object for the null move
+	state := pos.prevStates[len(pos.prevStates)-1]
+	pos.prevStates = pos.prevStates[:len(pos.prevStates)-1]
 
 	// Restore the irreversible aspects of the position using the State object.
 	pos.CastlingRights = state.CastlingRights
@@ -424,7 +442,11 @@ func (pos *Position) UnmakeNullMove() {
 	pos.Hash ^= Zobrist.SideToMoveNumber(pos.SideToMove)
 
 	// Remove the current positions from the position history
-	HistoryPly--
+	pos.history = pos.history[:len(pos.history)-1]
+
+	if DebugMode {
+		pos.debugValidate("unmaking", NullMove)
+	}
 }
 
 // Put the piece given on the given square
@@ -435,6 +457,13 @@ func (pos *Position) putPiece(pieceType, pieceColor, to uint8) {
 	pos.Squares[to].Type = pieceType
 	pos.Squares[to].Color = pieceColor
 	pos.Hash ^= Zobrist.PieceNumber(pieceType, pieceColor, to)
+	if pieceType == Pawn {
+		pos.PawnHash ^= Zobrist.PieceNumber(Pawn, pieceColor, to)
+	}
+
+	pos.MGScores[pieceColor] += PieceValueMG[pieceType] + PSQT_MG[pieceType][FlipSq[pieceColor][to]]
+	pos.EGScores[pieceColor] += PieceValueEG[pieceType] + PSQT_EG[pieceType][FlipSq[pieceColor][to]]
+	pos.Phase += PhaseValues[pieceType]
 }
 
 // Clear the piece given from the given square.
@@ -444,10 +473,33 @@ func (pos *Position) clearPiece(from uint8) {
 	pos.SideBB[piece.Color].ClearBit(from)
 
 	pos.Hash ^= Zobrist.PieceNumber(piece.Type, piece.Color, from)
+	if piece.Type == Pawn {
+		pos.PawnHash ^= Zobrist.PieceNumber(Pawn, piece.Color, from)
+	}
+
+	pos.MGScores[piece.Color] -= PieceValueMG[piece.Type] + PSQT_MG[piece.Type][FlipSq[piece.Color][from]]
+	pos.EGScores[piece.Color] -= PieceValueEG[piece.Type] + PSQT_EG[piece.Type][FlipSq[piece.Color][from]]
+	pos.Phase -= PhaseValues[piece.Type]
+
 	piece.Type = NoType
 	piece.Color = NoColor
 }
 
+// Compute the material and piece-square table scores of each side, and the
+// sum of the phase values of the pieces, from scratch. Useful for debugging
+// the scores the position keeps updated incrementally.
+func (pos *Position) GenScores() (mgScores, egScores [2]int16, phase int16) {
+	for sq := uint8(0); sq < 64; sq++ {
+		piece := pos.Squares[sq]
+		if piece.Type != NoType {
+			mgScores[piece.Color] += PieceValueMG[piece.Type] + PSQT_MG[piece.Type][FlipSq[piece.Color][sq]]
+			egScores[piece.Color] += PieceValueEG[piece.Type] + PSQT_EG[piece.Type][FlipSq[piece.Color][sq]]
+			phase += PhaseValues[piece.Type]
+		}
+	}
+	return mgScores, egScores, phase
+}
+
 // Load in a FEN string and use it to setup the position.
 func (pos *Position) LoadFEN(fen string) {
 	// Reset the internal fields of the position
@@ -455,6 +507,9 @@ func (pos *Position) LoadFEN(fen string) {
 	pos.SideBB = [2]Bitboard{}
 	pos.Squares = [64]Piece{}
 	pos.CastlingRights = 0
+	pos.MGScores = [2]int16{}
+	pos.EGScores = [2]int16{}
+	pos.Phase = 0
 
 	for square := range pos.Squares {
 		pos.Squares[square] = Piece{Type: NoType, Color: NoColor}
@@ -501,9 +556,13 @@ func (pos *Position) LoadFEN(fen string) {
 		}
 	}
 
-	// Set the half move counter and game ply for the position.
+	// Set the half move counter and game ply for the position. A counter
+	// too large to store is clamped, which keeps it past the move limits.
 	halfMoveCounter, _ := strconv.Atoi(halfMove)
-	pos.Rule50 = uint8(halfMoveCounter)
+	if halfMoveCounter > math.MaxUint16 {
+		halfMoveCounter = math.MaxUint16
+	}
+	pos.Rule50 = uint16(halfMoveCounter)
 
 	gamePly, _ := strconv.Atoi(fullMove)
 	gamePly *= 2
@@ -526,13 +585,37 @@ func (pos *Position) LoadFEN(fen string) {
 		}
 	}
 
-	// Generate the zobrist hash for the position...
+	// Generate the zobrist hashes for the position...
 	pos.Hash = 0
 	pos.Hash = Zobrist.GenHash(pos)
+	pos.PawnHash = Zobrist.GenPawnHash(pos)
+
+	// and start the position's stacks afresh, with the hash as the first
+	// entry in the position history. New stacks are allocated, rather than
+	// reusing the old ones, since copies of the position might share them.
+	pos.prevStates = make([]State, 0, InitialStackSize)
+	pos.history = make([]uint64, 1, InitialStackSize+1)
+	pos.history[0] = pos.Hash
+}
+
+// Make a copy of the position, with its own state and history stacks, so
+// moves can be made in the copy and the original independently.
+func (pos *Position) Copy() Position {
+	posCopy := *pos
+	posCopy.prevStates = append(make([]State, 0, cap(pos.prevStates)), pos.prevStates...)
+	posCopy.history = append(make([]uint64, 0, cap(pos.history)), pos.history...)
+	return posCopy
+}
 
-	// and add the hash as the first entry in the position history.
-	HistoryPly = 0
-	PositionHistories[HistoryPly] = pos.Hash
+// Determine if the current position has been reached before, since the
+// position was loaded.
+func (pos *Position) isRepetition() bool {
+	for idx := len(pos.history) - 2; idx >= 0; idx-- {
+		if pos.history[idx] == pos.Hash {
+			return true
+		}
+	}
+	return false
 }
 
 // Generate the FEN string represention of the current board.
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"testing"
+)
+
+// Test that the material, piece-square table, and phase scores of a position,
+// which are updated incrementally as moves are made and unmade, match the
+// scores computed from scratch.
+func TestIncrementalScores(t *testing.T) {
+	var pos Position
+	for _, perftTest := range loadPerftSuite() {
+		pos.LoadFEN(perftTest.FEN)
+		checkScores(t, &pos, 3)
+	}
+}
+
+func checkScores(t *testing.T, pos *Position, depth uint8) {
+	mgScores, egScores, phase := pos.GenScores()
+	if mgScores != pos.MGScores || egScores != pos.EGScores || phase != pos.Phase {
+		t.Fatalf(
+			"%s: incremental scores mg=%v eg=%v phase=%d instead of mg=%v eg=%v phase=%d",
+			pos.GenFEN(), pos.MGScores, pos.EGScores, pos.Phase, mgScores, egScores, phase,
+		)
+	}
+
+	if depth == 0 {
+		return
+	}
+
+	moves := GenMoves(pos)
+	for index := uint8(0); index < moves.Count; index++ {
+		move := moves.Moves[index]
+		if pos.MakeMove(move) {
+			checkScores(t, pos, depth-1)
+		}
+		pos.UnmakeMove(move)
+	}
+
+	// Unmaking the moves should have restored the scores.
+	checkScores(t, pos, 0)
+}
+
+// Test that a game can be longer than any fixed limit, with every move kept
+// on the position's stacks, and that all of them can be unmade again.
+func TestLongGame(t *testing.T) {
+	var pos Position
+	pos.LoadFEN(FENStartPosition)
+	shuffle := []string{"g1f3", "g8f6", "f3g1", "f6g8"}
+
+	const moveCount = 4000
+	moves := make([]Move, moveCount)
+	for ply := range moves {
+		moves[ply] = MoveFromCoord(&pos, shuffle[ply%len(shuffle)])
+		pos.MakeMove(moves[ply])
+	}
+
+	if len(pos.prevStates) != moveCount || len(pos.history) != moveCount+1 {
+		t.Fatalf("Stacks hold %d states and %d hashes after %d moves", len(pos.prevStates), len(pos.history), moveCount)
+	}
+	if !pos.isRepetition() {
+		t.Errorf("Shuffling the knights back and forth wasn't detected as a repetition")
+	}
+
+	for ply := moveCount - 1; ply >= 0; ply-- {
+		pos.UnmakeMove(moves[ply])
+	}
+
+	start := Position{}
+	start.LoadFEN(FENStartPosition)
+	if pos.GenFEN() != start.GenFEN() || pos.Hash != start.Hash || pos.isRepetition() {
+		t.Errorf("Unmaking every move led to %s instead of the start position", pos.GenFEN())
+	}
+}
+
+// Test that copies of a position keep their own stacks, so moves can be made
+// in a copy and in the original independently.
+func TestPositionCopy(t *testing.T) {
+	var pos Position
+	pos.LoadFEN(FENStartPosition)
+	pos.MakeMove(MoveFromCoord(&pos, "g1f3"))
+	fen := pos.GenFEN()
+
+	posCopy := pos.Copy()
+	var moves []Move
+	for _, moveAsString := range []string{"g8f6", "f3g1", "f6g8"} {
+		moves = append(moves, MoveFromCoord(&posCopy, moveAsString))
+		posCopy.MakeMove(moves[len(moves)-1])
+	}
+
+	reply := MoveFromCoord(&pos, "e7e5")
+	pos.MakeMove(reply)
+
+	if !posCopy.isRepetition() {
+		t.Errorf("Repetition in the copy wasn't detected")
+	}
+	if pos.isRepetition() {
+		t.Errorf("Repetition was detected in the original")
+	}
+
+	for idx := len(moves) - 1; idx >= 0; idx-- {
+		posCopy.UnmakeMove(moves[idx])
+	}
+	pos.UnmakeMove(reply)
+
+	if posCopy.GenFEN() != fen || pos.GenFEN() != fen {
+		t.Errorf("Unmaking the moves led to %s in the copy and %s in the original, instead of %s", posCopy.GenFEN(), pos.GenFEN(), fen)
+	}
+}
@@ -2,7 +2,6 @@ package engine
 
 import (
 	"fmt"
-	"math"
 	"time"
 )
 
@@ -13,24 +12,9 @@ const (
 	// A constant representing no move.
 	NullMove Move = 0
 
-	// A constant representing the score of the principal variation
-	// move from the transposition table.
-	PVMoveScore uint16 = 60
-
-	// A constant representing the score of a killer move.
-	KillerMoveScore uint16 = 10
-
 	// A constant representing the maximum number of killers.
 	MaxKillers = 2
 
-	// A constant to offset the score of the pv and MVV-LVA move higher
-	// than killers and history heuristic moves.
-	MvvLvaOffset uint16 = math.MaxUint16 - 256
-
-	// A constant representing the maximum value a history heuristic score
-	// is allowed to reach.
-	MaxHistoryScore int32 = int32(MvvLvaOffset) - int32((MaxKillers+1)*KillerMoveScore)
-
 	// Constants representing various pruning margins and parameters used
 	// in the search.
 	StaticNullMovePruningBaseMargin int16 = 120
@@ -38,6 +22,30 @@ const (
 	LMRLegalMovesLimit              int   = 4
 	LMRDepthLimit                   int8  = 3
 	WindowSize                      int16 = 25
+
+	// Constants controlling singular extensions: the minimum depth they're
+	// tried at, how much shallower than that the transposition table entry
+	// can be, and the margin below the entry's score, per ply of depth, a
+	// singular move must be better than every other move by.
+	SingularExtensionDepthLimit int8  = 7
+	SingularTTDepthMargin       int8  = 3
+	SingularMarginPerDepth      int16 = 2
+
+	// How much the history score of a quiet move changes its late move
+	// reduction: one ply per this much above or below zero.
+	LMRHistoryDivisor int32 = 8192
+
+	// How long a search must have been running before the root starts
+	// reporting the move it's currently searching.
+	CurrMoveReportDelay = time.Millisecond * 3000
+)
+
+const (
+	// Constants representing whether a reported score is exact, or only
+	// a bound from a search that failed high or low.
+	ExactBound uint8 = iota
+	LowerBound
+	UpperBound
 )
 
 var FutilityMargins = [9]int16{0, 100, 160, 220, 280, 340, 400, 460, 520}
@@ -103,6 +111,55 @@ func (pvLine PVLine) String() string {
 	return pv[1 : len(pv)-1]
 }
 
+// A struct representing the statistics of a search iteration that are
+// reported to the GUI.
+type SearchInfo struct {
+	Depth    uint8
+	SelDepth uint8
+	MultiPV  int
+	Score    int16
+	Bound    uint8
+	Nodes    uint64
+	NPS      uint64
+	Hashfull int
+	Time     time.Duration
+	PV       PVLine
+}
+
+// Convert the search statistics to an info line of the UCI protocol.
+func (info SearchInfo) String() string {
+	infoStr := fmt.Sprintf("info depth %d seldepth %d", info.Depth, info.SelDepth)
+	if info.MultiPV != 0 {
+		infoStr += fmt.Sprintf(" multipv %d", info.MultiPV)
+	}
+
+	infoStr += " score " + getMateOrCPScore(info.Score)
+	if info.Bound == LowerBound {
+		infoStr += " lowerbound"
+	} else if info.Bound == UpperBound {
+		infoStr += " upperbound"
+	}
+
+	infoStr += fmt.Sprintf(
+		" nodes %d nps %d hashfull %d time %d",
+		info.Nodes, info.NPS, info.Hashfull, info.Time.Milliseconds(),
+	)
+
+	if len(info.PV.Moves) != 0 {
+		infoStr += fmt.Sprintf(" pv %s", info.PV)
+	}
+	return infoStr
+}
+
+// Get the nodes searched per second, or zero if the search was too quick to
+// take any measurable time.
+func NodesPerSecond(nodes uint64, elapsed time.Duration) uint64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return uint64(float64(nodes) / elapsed.Seconds())
+}
+
 // A struct that holds state needed during the search phase. The search
 // routines are thus implemented as methods of this struct.
 type Search struct {
@@ -114,11 +171,50 @@ type Search struct {
 	nodes      uint64
 	totalNodes uint64
 
-	killers [MaxPly + 1][MaxKillers]Move
-	history [2][64][64]int32
+	killers            [MaxPly + 1][MaxKillers]Move
+	history            [2][64][64]int32
+	counterMoves       [2][64][64]Move
+	counterMoveHistory ContinuationHistory
+	followUpHistory    ContinuationHistory
+	captureHistory     CaptureHistory
+
+	// The caches of pawn structure scores and static evaluations.
+	pawnTable PawnTable
+	evalCache EvalCache
+
+	// The move made at each ply of the current search line, or a null
+	// move if a null move was made, and the type of the piece moved.
+	plyMoves  [MaxPly + 1]Move
+	plyPieces [MaxPly + 1]uint8
+
+	// The move excluded from the search at each ply, when searching
+	// the other moves to verify a move is singular.
+	excludedMoves [MaxPly + 1]Move
 
 	SpecifiedDepth uint8
 	SpecifiedNodes uint64
+
+	// The root moves the search is restricted to. If empty, every legal
+	// move is searched.
+	SearchMoves []Move
+
+	// The skill level the search is limited to.
+	Skill Skill
+
+	// The function used to report the statistics of each search iteration.
+	// If not set, they're reported as UCI info lines.
+	Report func(info SearchInfo)
+
+	excludedRootMoves []Move
+	rootDepth         uint8
+	seldepth          uint8
+	startTime         time.Time
+
+	// The nodes spent searching each root move, by its from and to squares,
+	// and searching every root move, which the time manager uses to decide
+	// how much time the search needs.
+	rootMoveNodes [64][64]uint64
+	rootNodes     uint64
 }
 
 // The main search function for Blunder, implemented as an interative
@@ -129,18 +225,41 @@ func (search *Search) Search() Move {
 	bestMove := NullMove
 
 	search.ageHistoryTable()
+	search.TT.NewSearch()
+	search.pawnTable.allocate()
+	search.evalCache.allocate()
 	search.Timer.Start()
+	search.startTime = time.Now()
 
 	search.totalNodes = 0
+	search.rootMoveNodes = [64][64]uint64{}
+	search.rootNodes = 0
+
+	if search.Skill.Enabled {
+		return search.limitedSearch()
+	}
+
 	depth := uint8(0)
 	lastIterationScore := int16(0)
 
 	alpha := -Inf
 	beta := Inf
 
+	// If there's only one move we can make, there's no point in spending
+	// time searching once we have a move to return.
+	rootMoves := 0
+	moves := GenLegalMoves(&search.Pos)
+	for index := uint8(0); index < moves.Count; index++ {
+		if search.isSearchMove(moves.Moves[index]) {
+			rootMoves++
+		}
+	}
+
 	for depth = 1; depth <= MaxPly && depth <= search.SpecifiedDepth && search.SpecifiedNodes > 0; depth++ {
 		// Clear the nodes searched and the last iterations pv line.
 		search.nodes = 0
+		search.seldepth = 0
+		search.rootDepth = depth
 		pvLine.Clear()
 
 		// Start a search, and time it for reporting purposes.
@@ -148,8 +267,8 @@ func (search *Search) Search() Move {
 		score := search.negamax(int8(depth), 0, alpha, beta, &pvLine, true)
 		endTime := time.Since(startTime)
 
-		if search.Timer.Stop {
-			if bestMove == NullMove && depth == 1 {
+		if search.Timer.Stopped() {
+			if bestMove == NullMove && depth == 1 && len(pvLine.Moves) != 0 {
 				bestMove = pvLine.GetPVMove()
 			}
 			break
@@ -166,6 +285,22 @@ func (search *Search) Search() Move {
 		// benefits of a quicker search easily outweigh the few extra searches.    //
 		// ========================================================================//
 		if score <= alpha || score >= beta {
+			bound := LowerBound
+			if score <= alpha {
+				bound = UpperBound
+			}
+
+			search.report(SearchInfo{
+				Depth:    depth,
+				SelDepth: search.seldepth,
+				Score:    score,
+				Bound:    bound,
+				Nodes:    search.nodes,
+				NPS:      NodesPerSecond(search.nodes, endTime),
+				Hashfull: search.TT.Hashfull(),
+				Time:     endTime,
+			})
+
 			alpha = -Inf
 			beta = Inf
 			depth--
@@ -175,37 +310,101 @@ func (search *Search) Search() Move {
 		alpha = score - WindowSize
 		beta = score + WindowSize
 
-		// If the score between this current iteration and the last iteration drops,
-		// take more time on the current search to make sure we find the best move.
-		if depth > 1 && lastIterationScore > score && lastIterationScore-score >= 30 {
-			search.Timer.SetSoftTimeForMove(search.Timer.SoftTimeForMove * 13 / 10)
-		}
-
 		// Save the best move and report search statistics to the GUI
 		bestMove = pvLine.GetPVMove()
 
+		// Let the time manager adjust how long to search for, based on how
+		// stable the best move is, and on whether the score between this
+		// iteration and the last iteration dropped.
+		scoreDrop := int16(0)
+		if depth > 1 {
+			scoreDrop = lastIterationScore - score
+		}
+		bestMoveNodes := search.rootMoveNodes[bestMove.FromSq()][bestMove.ToSq()]
+		search.Timer.Update(bestMove, bestMoveNodes, search.rootNodes, scoreDrop)
+
 		// Get the nodes per second
-		nps := uint64(float64(search.nodes) / float64(endTime.Seconds()))
+		nps := NodesPerSecond(search.nodes, endTime)
 
 		// Collect the amount of nodes searched for this iteration.
 		search.totalNodes += search.nodes
 
 		// Send search statistics to the GUI.
-		fmt.Printf(
-			"info depth %d score %s nodes %d nps %d time %d pv %s\n",
-			depth, getMateOrCPScore(score),
-			search.nodes, nps,
-			endTime.Milliseconds(),
-			pvLine,
-		)
+		search.report(SearchInfo{
+			Depth:    depth,
+			SelDepth: search.seldepth,
+			Score:    score,
+			Nodes:    search.nodes,
+			NPS:      nps,
+			Hashfull: search.TT.Hashfull(),
+			Time:     endTime,
+			PV:       pvLine,
+		})
 
 		lastIterationScore = score
+
+		// Don't start another iteration if we're out of time for it, or if we
+		// only have one move to make.
+		if search.Timer.SoftTimeUp() || (rootMoves == 1 && search.Timer.Managed()) {
+			break
+		}
+	}
+
+	// If the search was stopped before it found a move, fall back on any
+	// move we're allowed to make.
+	if bestMove == NullMove {
+		bestMove = search.firstSearchMove()
 	}
 
 	// Return the best move found to the GUI.
 	return bestMove
 }
 
+// Report the statistics of a search iteration.
+func (search *Search) report(info SearchInfo) {
+	if search.Report != nil {
+		search.Report(info)
+		return
+	}
+	fmt.Println(info)
+}
+
+// A search running in the background, so the commands sent while it's running
+// can still be responded to.
+type backgroundSearch struct {
+	timer   *TimeManager
+	started <-chan struct{}
+	done    chan struct{}
+}
+
+// Run the given function in the background, which is expected to run the
+// search, unless it finds it has nothing to search.
+func (search *Search) runInBackground(run func()) *backgroundSearch {
+	background := &backgroundSearch{
+		timer:   &search.Timer,
+		started: search.Timer.notifyStart(),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(background.done)
+		run()
+	}()
+	return background
+}
+
+// Stop the search once it's started, and wait for the function running it to
+// finish. If the function finishes without starting a search, there's nothing
+// to stop.
+func (background *backgroundSearch) stop() {
+	select {
+	case <-background.started:
+		background.timer.Stop()
+	case <-background.done:
+	}
+	<-background.done
+}
+
 // Display the correct format for the search score if it's a centipawn score
 // or a checkmate score.
 func getMateOrCPScore(score int16) string {
@@ -226,17 +425,20 @@ func getMateOrCPScore(score int16) string {
 
 // The primary negamax function.
 func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *PVLine, doNull bool) int16 {
-	// Update the number of nodes searched.
+	// Update the number of nodes searched, and the selective depth reached.
 	search.nodes++
+	if ply > search.seldepth {
+		search.seldepth = ply
+	}
 
 	if ply >= MaxPly {
-		return EvaluatePos(&search.Pos)
+		return search.evaluate()
 	}
 
 	// If a given node amount to search was given, make sure we haven't passed it
 	// and if so stop the search.
-	if search.totalNodes+search.nodes >= search.SpecifiedNodes {
-		search.Timer.Stop = true
+	if search.outOfNodes() {
+		search.Timer.Stop()
 		return 0
 	}
 
@@ -248,7 +450,7 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	// If we're told to stop, abort the current search and return 0. This won't
 	// affect anything, as the previous search's best move will be used, and
 	// everything from the current search will be discarded.
-	if search.Timer.Stop {
+	if search.Timer.Stopped() {
 		return 0
 	}
 
@@ -285,13 +487,29 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	// table. And the best move we'll get from the search if we don't get a hit.
 	ttMove := NullMove
 
+	// When verifying that a move is singular, the move is excluded from the search,
+	// so the transposition table entry for the position can't be used.
+	excludedMove := search.excludedMoves[ply]
+	isExcludedSearch := !excludedMove.Equal(NullMove)
+
 	// Probe the transposition table to see if we have a useable matching entry for the current
 	// position. If we get a hit, return the score and stop searching.
-	score := search.TT.Probe(search.Pos.Hash, ply, uint8(depth), alpha, beta, &ttMove)
-	if score != Invalid && !isRoot {
-		return score
+	ttEntry, ttHit := search.TT.ProbeEntry(search.Pos.Hash)
+	ttHit = ttHit && !isExcludedSearch
+	if ttHit {
+		ttMove = ttEntry.Best
+		score := ttEntry.UsableScore(ply, uint8(depth), alpha, beta)
+		if score != Invalid && !isRoot {
+			return score
+		}
 	}
 
+	// A move is only tested for being singular if the transposition table entry says
+	// it's the best move, and that the position's score is at least its score.
+	canSingularExtend := !isRoot && ttHit && depth >= SingularExtensionDepthLimit &&
+		int8(ttEntry.Depth) >= depth-SingularTTDepthMargin &&
+		ttEntry.Flag != AlphaFlag && abs16(ttEntry.Score) < Checkmate
+
 	// =====================================================================//
 	// STATIC NULL MOVE PRUNING: If our current material score is so good   //
 	// that even if we give ourselves a big hit materially and subtract a   //
@@ -301,7 +519,7 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	// =====================================================================//
 
 	if !inCheck && !isPVNode && abs16(beta) < Checkmate {
-		staticScore := EvaluatePos(&search.Pos)
+		staticScore := search.evaluate()
 		scoreMargin := StaticNullMovePruningBaseMargin * int16(depth)
 		if staticScore-scoreMargin >= beta {
 			return beta
@@ -317,14 +535,15 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	// this branch.                                                         //
 	// =====================================================================//
 
-	if doNull && !inCheck && !isPVNode && depth >= 3 && !search.Pos.NoMajorsOrMiniors() {
+	if doNull && !isExcludedSearch && !inCheck && !isPVNode && depth >= 3 && !search.Pos.NoMajorsOrMiniors() {
 		R := 3 + depth/6
 		search.Pos.MakeNullMove()
+		search.plyMoves[ply] = NullMove
 		score := -search.negamax(depth-R-1, ply+1, -beta, -beta+1, &childPVLine, false)
 		search.Pos.UnmakeNullMove()
 		childPVLine.Clear()
 
-		if search.Timer.Stop {
+		if search.Timer.Stopped() {
 			return 0
 		}
 
@@ -342,16 +561,15 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	// =====================================================================//
 
 	if depth <= 8 && !isPVNode && !inCheck && alpha < Checkmate {
-		staticScore := EvaluatePos(&search.Pos)
+		staticScore := search.evaluate()
 		if staticScore+FutilityMargins[depth] <= alpha {
 			canFutilityPrune = true
 		}
 	}
 
-	// Generate and score the moves for the side to move
-	// in the current position.
-	moves := GenMoves(&search.Pos)
-	search.scoreMoves(&moves, ttMove, ply)
+	// Create a move picker to hand us the moves of the current
+	// position, best first.
+	picker := search.newMovePicker(ttMove, ply)
 
 	// Set up variables to record the number of legal moves and
 	// the transposition table entry flag.
@@ -363,10 +581,64 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	bestMove := NullMove
 	bestScore := -Inf
 
-	for index := 0; index < int(moves.Count); index++ {
-		// Order the moves to get the best moves first.
-		orderMoves(index, &moves)
-		move := moves.Moves[index]
+	// Keep track of the moves searched which didn't cause a beta cut-off, so
+	// their history scores can be lowered if a later move does.
+	var quietsTried, capturesTried MoveList
+
+	for move := picker.Next(); !move.Equal(NullMove); move = picker.Next() {
+		// If we've been told to only search certian moves at the root, skip
+		// any moves that weren't given.
+		if isRoot && !search.isSearchMove(move) {
+			continue
+		}
+
+		// Skip the move being excluded from the search.
+		if isExcludedSearch && move.Equal(excludedMove) {
+			continue
+		}
+
+		quiet := isQuietMove(&search.Pos, move)
+		historyScore := int32(0)
+		if quiet {
+			historyScore = search.quietHistoryScore(move, &picker.contHistories)
+		}
+		// =====================================================================//
+		// SINGULAR EXTENSIONS: If the move from the transposition table is     //
+		// much better than every other move, it's a forced move, or a          //
+		// "singular" move, and is searched a ply deeper. This is tested by a   //
+		// reduced depth search of the other moves, with a null window just     //
+		// below the move's score. If several moves beat the window, and it's   //
+		// above beta, more than one move will likely fail high here, so the    //
+		// node can be pruned (multi-cut). Otherwise, if the move's score is    //
+		// above beta, it isn't the only good move, so it's searched a ply      //
+		// shallower (a negative extension).                                    //
+		// =====================================================================//
+
+		extension := int8(0)
+		if canSingularExtend && move.Equal(ttMove) {
+			ttScore := ttEntry.AdjustedScore(ply)
+			singularBeta := ttScore - SingularMarginPerDepth*int16(depth)
+			singularDepth := (depth - 1) / 2
+
+			var singularPVLine PVLine
+			search.excludedMoves[ply] = move
+			score := search.negamax(singularDepth, ply, singularBeta-1, singularBeta, &singularPVLine, false)
+			search.excludedMoves[ply] = NullMove
+
+			if search.Timer.Stopped() {
+				return 0
+			}
+
+			if score < singularBeta {
+				extension = 1
+			} else if singularBeta >= beta {
+				return singularBeta
+			} else if ttScore >= beta {
+				extension = -1
+			}
+		}
+
+		search.plyPieces[ply] = search.Pos.Squares[move.FromSq()].Type
 
 		// Make the move, and if it was illegal, undo it and skip to the next move.
 		if !search.Pos.MakeMove(move) {
@@ -375,6 +647,17 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 		}
 
 		legalMoves++
+		search.plyMoves[ply] = move
+
+		// Once the search has been running for a while, let the GUI know which
+		// root move we're currently searching. This is only part of the UCI
+		// protocol, so it's not reported anywhere else.
+		if isRoot && search.Report == nil && time.Since(search.startTime) >= CurrMoveReportDelay {
+			fmt.Printf(
+				"info depth %d currmove %v currmovenumber %d\n",
+				search.rootDepth, move, legalMoves,
+			)
+		}
 
 		// =====================================================================//
 		// LATE MOVE PRUNING: Because of move ordering, moves late in the move  //
@@ -418,13 +701,16 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 		// accurate score for the move.                                         //
 		// =====================================================================//
 
+		newDepth := depth - 1 + extension
+		nodesBefore := search.nodes
 		score := int16(0)
 		if legalMoves == 1 {
-			score = -search.negamax(depth-1, ply+1, -beta, -alpha, &childPVLine, true)
+			score = -search.negamax(newDepth, ply+1, -beta, -alpha, &childPVLine, true)
 		} else {
 			tactical := inCheck || move.MoveType() == Attack ||
 				search.killers[ply][0].Equal(move) ||
 				search.killers[ply][1].Equal(move) ||
+				picker.counterMove.Equal(move) ||
 				(FlipRank[search.Pos.SideToMove^1][RankOf(move.ToSq())] >= Rank6 && isPawnPush(&search.Pos, move))
 
 			reduction := int8(0)
@@ -436,14 +722,20 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 						break
 					}
 				}
+
+				// Reduce quiet moves with a good history less, and those with
+				// a bad history more.
+				if quiet {
+					reduction = max8(reduction-int8(historyScore/LMRHistoryDivisor), 0)
+				}
 			}
 
 			// Don't drop directly into quiescence search from a late-move
 			// reduction, unless we're already at a frontier node (a node at
 			// depth=1). This increases the tactical strength.
-			reducedDepth := max8(depth-1-reduction, 1)
+			reducedDepth := max8(newDepth-reduction, 1)
 			if depth == 1 {
-				reducedDepth = depth - 1
+				reducedDepth = newDepth
 			}
 
 			score = -search.negamax(reducedDepth, ply+1, -alpha-1, -alpha, &childPVLine, true)
@@ -451,15 +743,20 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 			if score > alpha && reduction > 0 {
 				score = -search.negamax(reducedDepth, ply+1, -beta, -alpha, &childPVLine, true)
 				if score > alpha {
-					score = -search.negamax(depth-1, ply+1, -beta, -alpha, &childPVLine, true)
+					score = -search.negamax(newDepth, ply+1, -beta, -alpha, &childPVLine, true)
 				}
 			} else if score > alpha && score < beta {
-				score = -search.negamax(depth-1, ply+1, -beta, -alpha, &childPVLine, true)
+				score = -search.negamax(newDepth, ply+1, -beta, -alpha, &childPVLine, true)
 			}
 		}
 
 		search.Pos.UnmakeMove(move)
 
+		if isRoot {
+			search.rootMoveNodes[move.FromSq()][move.ToSq()] += search.nodes - nodesBefore
+			search.rootNodes += search.nodes - nodesBefore
+		}
+
 		// If the current score is better than the best score so far,
 		// update the best score and the best move.
 		if score > bestScore {
@@ -474,17 +771,21 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 			// Set the transposition table entry flag to beta
 			ttFlag = BetaFlag
 
-			// Store the possible killer.
+			// Store the possible killer and counter move.
 			search.storeKiller(ply, move)
+			search.storeCounterMove(ply, move)
 
-			// Update the history table.
-			search.incrementHistoryScore(move, depth)
+			// Update the history tables.
+			search.updateHistories(move, ply, depth, &quietsTried, &capturesTried)
 
 			// Break he move loop, since we have a beta cutoff.
 			break
+		}
+
+		if quiet {
+			quietsTried.AddMove(move)
 		} else {
-			// If the move doesn't cause a beta cutoff decrease it's history score.
-			search.decrementHistoryScore(move)
+			capturesTried.AddMove(move)
 		}
 
 		// If the score of this move is better than alpha (i.e better than the score
@@ -494,17 +795,11 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 			// Update alpha and the best move.
 			alpha = score
 
-			// Update the history table.
-			search.incrementHistoryScore(move, depth)
-
 			// Update the principal variation line.
 			pvLine.Update(move, childPVLine)
 
 			// Set the transposition table flag to exact.
 			ttFlag = ExactFlag
-		} else {
-			// If the move doesn't raise alpha decrease it's history score.
-			search.decrementHistoryScore(move)
 		}
 
 		// Clear this child node's principal variation line for the
@@ -512,6 +807,13 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 		childPVLine.Clear()
 	}
 
+	// If every move but the excluded one was searched, and none of them were
+	// legal, the position isn't a checkmate or stalemate, since the excluded
+	// move is legal. So just fail low.
+	if isExcludedSearch && legalMoves == 0 {
+		return alpha
+	}
+
 	// If we don't have any legal moves, it's either checkmate, or a stalemate.
 	if legalMoves == 0 {
 		if inCheck {
@@ -525,7 +827,9 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 	}
 
 	// If we're not out of time, store the result of the search for this position.
-	if !search.Timer.Stop {
+	// A root search restricted to certian moves doesn't give the true result for
+	// the position, and neither does a search excluding a move, so they aren't stored.
+	if !search.Timer.Stopped() && !isExcludedSearch && !(isRoot && search.rootIsRestricted()) {
 		search.TT.Store(search.Pos.Hash, ply, uint8(depth), bestScore, ttFlag, bestMove)
 	}
 
@@ -538,22 +842,29 @@ func (search *Search) negamax(depth int8, ply uint8, alpha, beta int16, pvLine *
 // a special form of negamax until the position is quiet (i.e there are no
 // winning tatical captures). Doing this is known as quiescence search, and
 // it makes the static evaluation much more accurate.
-func (search *Search) Qsearch(alpha, beta int16, negamaxPly uint8, pvLine *PVLine) int16 {
+func (search *Search) Qsearch(alpha, beta int16, ply uint8, pvLine *PVLine) int16 {
 	search.nodes++
+	if ply > search.seldepth {
+		search.seldepth = ply
+	}
+
+	if ply >= MaxPly {
+		return search.evaluate()
+	}
 
 	if (search.nodes & 2047) == 0 {
 		search.Timer.Check()
 	}
 
-	if search.totalNodes+search.nodes >= search.SpecifiedNodes {
-		search.Timer.Stop = true
+	if search.outOfNodes() {
+		search.Timer.Stop()
 	}
 
-	if search.Timer.Stop {
+	if search.Timer.Stopped() {
 		return 0
 	}
 
-	bestScore := EvaluatePos(&search.Pos)
+	bestScore := search.evaluate()
 
 	// If the score is greater than beta, what our opponet can
 	// already guarantee early in the search tree, then we
@@ -568,25 +879,18 @@ func (search *Search) Qsearch(alpha, beta int16, negamaxPly uint8, pvLine *PVLin
 		alpha = bestScore
 	}
 
-	moves := genCapturesAndQPromotions(&search.Pos)
-	search.scoreMoves(&moves, NullMove, negamaxPly)
+	// The move picker prunes the moves with a negative static
+	// exchange evaluation.
+	picker := search.newQsearchMovePicker()
 	var childPVLine PVLine
 
-	for index := 0; index < int(moves.Count); index++ {
-		orderMoves(index, &moves)
-		move := moves.Moves[index]
-
-		// Prune moves based on the static exchange evaluation being negative.
-		if search.Pos.See(move) < 0 {
-			continue
-		}
-
+	for move := picker.Next(); !move.Equal(NullMove); move = picker.Next() {
 		if !search.Pos.MakeMove(move) {
 			search.Pos.UnmakeMove(move)
 			continue
 		}
 
-		score := -search.Qsearch(-beta, -alpha, negamaxPly, &childPVLine)
+		score := -search.Qsearch(-beta, -alpha, ply+1, &childPVLine)
 		search.Pos.UnmakeMove(move)
 
 		if score > bestScore {
@@ -608,26 +912,6 @@ func (search *Search) Qsearch(alpha, beta int16, negamaxPly uint8, pvLine *PVLin
 	return bestScore
 }
 
-// Increment the history score for the given move if it caused a beta-cutoff and is quiet.
-func (search *Search) incrementHistoryScore(move Move, depth int8) {
-	if search.Pos.Squares[move.ToSq()].Type == NoType {
-		search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()] += int32(depth) * int32(depth)
-	}
-
-	if search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()] >= MaxHistoryScore {
-		search.ageHistoryTable()
-	}
-}
-
-// Decrement the history score for the given move if it didn't cause a beta-cutoff and is quiet.
-func (search *Search) decrementHistoryScore(move Move) {
-	if search.Pos.Squares[move.ToSq()].Type == NoType {
-		if search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()] > 0 {
-			search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()] -= 1
-		}
-	}
-}
-
 // Age the values in the history table by halving them.
 func (search *Search) ageHistoryTable() {
 	for sq1 := 0; sq1 < 64; sq1++ {
@@ -637,13 +921,15 @@ func (search *Search) ageHistoryTable() {
 	}
 }
 
-// Clear the values in the history table.
+// Clear the values in the history tables of both sides, the killer moves,
+// and the counter moves.
 func (search *Search) ClearHistoryTable() {
-	for sq1 := 0; sq1 < 64; sq1++ {
-		for sq2 := 0; sq2 < 64; sq2++ {
-			search.history[search.Pos.SideToMove][sq1][sq2] = 0
-		}
-	}
+	search.history = [2][64][64]int32{}
+	search.killers = [MaxPly + 1][MaxKillers]Move{}
+	search.counterMoves = [2][64][64]Move{}
+	search.counterMoveHistory = ContinuationHistory{}
+	search.followUpHistory = ContinuationHistory{}
+	search.captureHistory = CaptureHistory{}
 }
 
 // Given a "killer move" (a quiet move that caused a beta cut-off), store the
@@ -657,6 +943,33 @@ func (search *Search) storeKiller(ply uint8, move Move) {
 	}
 }
 
+// Given a quiet move that caused a beta cut-off, store it as the counter
+// move to the move made by our opponet before it.
+func (search *Search) storeCounterMove(ply uint8, move Move) {
+	previousMove := search.previousMove(ply)
+	if previousMove.Equal(NullMove) || search.Pos.Squares[move.ToSq()].Type != NoType {
+		return
+	}
+	search.counterMoves[search.Pos.SideToMove][previousMove.FromSq()][previousMove.ToSq()] = move
+}
+
+// Get the counter move to the move made by our opponet before the given ply.
+func (search *Search) counterMove(ply uint8) Move {
+	previousMove := search.previousMove(ply)
+	if previousMove.Equal(NullMove) {
+		return NullMove
+	}
+	return search.counterMoves[search.Pos.SideToMove][previousMove.FromSq()][previousMove.ToSq()]
+}
+
+// Get the move made before the given ply, or a null move if there's none.
+func (search *Search) previousMove(ply uint8) Move {
+	if ply == 0 {
+		return NullMove
+	}
+	return search.plyMoves[ply-1]
+}
+
 // Determine the draw score based on the phase of the game and whose moving,
 // to encourge the engine to strive for a win in the middle-game, but be
 // satisified with a draw in the endgame.
@@ -672,44 +985,69 @@ func (search *Search) contempt() int16 {
 	return drawValue
 }
 
-// Determine if the current board state is being repeated.
-func (search *Search) isDrawByRepition() bool {
-	var repPly uint16
-	for repPly = 0; repPly < HistoryPly; repPly++ {
-		if PositionHistories[repPly] == search.Pos.Hash {
+// Get the static evaluation of the current position, from the eval cache if
+// it's been evaluated before, adding noise to it if the search is limited to
+// a skill level. The noise isn't cached, so it doesn't depend on the cache.
+func (search *Search) evaluate() int16 {
+	score, ok := search.evalCache.Probe(search.Pos.Hash)
+	if !ok {
+		score = evaluatePos(&search.Pos, &search.pawnTable, nil)
+		search.evalCache.Store(search.Pos.Hash, score)
+	}
+
+	if search.Skill.Enabled {
+		score += search.Skill.noise(search.Pos.Hash)
+	}
+	return score
+}
+
+// Determine if the given move is one the root search should consider.
+func (search *Search) isSearchMove(move Move) bool {
+	for _, excludedMove := range search.excludedRootMoves {
+		if move.Equal(excludedMove) {
+			return false
+		}
+	}
+
+	if len(search.SearchMoves) == 0 {
+		return true
+	}
+
+	for _, searchMove := range search.SearchMoves {
+		if move.Equal(searchMove) {
 			return true
 		}
 	}
 	return false
 }
 
-// Score the moves generated.
-func (search *Search) scoreMoves(moves *MoveList, pvMove Move, ply uint8) {
-	for index := 0; index < int(moves.Count); index++ {
-		move := &moves.Moves[index]
-		captured := search.Pos.Squares[move.ToSq()]
+// Get the first legal move the root search could consider, or a null move if
+// there isn't one.
+func (search *Search) firstSearchMove() Move {
+	moves := GenLegalMoves(&search.Pos)
+	for index := uint8(0); index < moves.Count; index++ {
+		if search.isSearchMove(moves.Moves[index]) {
+			return moves.Moves[index]
+		}
+	}
+	return NullMove
+}
 
-		if move.Equal(pvMove) {
-			move.AddScore(MvvLvaOffset + PVMoveScore)
-		} else if captured.Type != NoType {
-			moved := search.Pos.Squares[move.FromSq()]
-			move.AddScore(MvvLvaOffset + MvvLva[captured.Type][moved.Type])
-		} else {
-			moveScore := uint16(0)
-			for n, killer := range search.killers[ply] {
-				if move.Equal(killer) {
-					moveScore = MvvLvaOffset - (uint16(n+1) * KillerMoveScore)
-					break
-				}
-			}
+// Determine if the search has used up the nodes it's allowed, either by the
+// node limit it was given, or by the node limit of its skill level.
+func (search *Search) outOfNodes() bool {
+	nodes := search.totalNodes + search.nodes
+	return nodes >= search.SpecifiedNodes || (search.Skill.Enabled && nodes >= search.Skill.nodeLimit())
+}
 
-			if moveScore == 0 {
-				moveScore = uint16(search.history[search.Pos.SideToMove][move.FromSq()][move.ToSq()])
-			}
+// Determine if the root search is restricted to only some of the legal moves.
+func (search *Search) rootIsRestricted() bool {
+	return len(search.SearchMoves) > 0 || len(search.excludedRootMoves) > 0
+}
 
-			move.AddScore(moveScore)
-		}
-	}
+// Determine if the current board state is being repeated.
+func (search *Search) isDrawByRepition() bool {
+	return search.Pos.isRepetition()
 }
 
 // Order the moves given by finding the best move and putting it
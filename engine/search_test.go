@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Search the first bench positions to a fixed depth, starting with empty
+// tables, and report the number of nodes searched and the nodes per second.
+func BenchmarkSearch(b *testing.B) {
+	var result BenchResult
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		result = Bench(BenchPositions[:8], 9)
+	}
+	b.ReportMetric(float64(result.Nodes), "nodes/op")
+	b.ReportMetric(float64(result.Nodes)*float64(b.N)/time.Since(start).Seconds(), "nps")
+}
+
+// Test that a search excluding the only legal move of a position fails low,
+// instead of scoring the position as a stalemate.
+func TestSearchExcludingOnlyMove(t *testing.T) {
+	var search Search
+	search.TT.Resize(1)
+	search.SpecifiedNodes = math.MaxUint64
+
+	// Black's only legal move is Kh7.
+	search.Pos.LoadFEN("7k/8/8/8/8/8/6Q1/K7 b - - 0 1")
+
+	var pvLine PVLine
+	search.excludedMoves[1] = NewMove(H8, H7, Quiet, NoFlag)
+	score := search.negamax(2, 1, -100, -99, &pvLine, false)
+
+	if score != -100 {
+		t.Errorf("search excluding the only move returned %d instead of failing low", score)
+	}
+
+	if _, ok := search.TT.ProbeEntry(search.Pos.Hash); ok {
+		t.Errorf("search excluding a move was stored in the transposition table")
+	}
+}
+
+// Test that a search limited to the lowest skill level returns a legal move
+// even when it runs out of nodes before its first search finishes, without
+// changing the node limit it was given.
+func TestLimitedSearchReturnsMove(t *testing.T) {
+	var search Search
+	search.TT.Resize(1)
+	search.Pos.LoadFEN("r2q1rk1/pP1p2pp/Q4n2/bbp1p3/Np6/1B3NBn/pPPP1PPP/R3K2R b KQ - 0 1")
+	search.Timer.TimeLeft = InfiniteTime
+	search.SpecifiedDepth = MaxPly
+	search.SpecifiedNodes = math.MaxUint64
+	search.Skill = NewSkill(MinSkillLevel)
+	search.Report = func(SearchInfo) {}
+
+	move := search.Search()
+	if _, legal := ParseLegalMove(&search.Pos, move.String()); !legal {
+		t.Errorf("Search at the lowest skill level returned %v", move)
+	}
+	if search.SpecifiedNodes != math.MaxUint64 {
+		t.Errorf("Search at the lowest skill level changed the node limit to %d", search.SpecifiedNodes)
+	}
+}
+
+func TestNodesPerSecond(t *testing.T) {
+	if nps := NodesPerSecond(1000, 0); nps != 0 {
+		t.Errorf("Nodes per second in no time is %d instead of 0", nps)
+	}
+	if nps := NodesPerSecond(1000, 500*time.Millisecond); nps != 2000 {
+		t.Errorf("Nodes per second is %d instead of 2000", nps)
+	}
+}
@@ -0,0 +1,198 @@
+package engine
+
+// skill.go implements the strength limiting Blunder uses to play as a weaker
+// opponent. Below full strength, the search is given a node limit, several of
+// the best root moves are searched and one of them is picked at random with a
+// bias towards worse moves, and the position is seen through a noisy evaluation.
+// Each of these handicaps grows as the skill level drops.
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// The range of skill levels, where the maximum skill level is
+	// full strength.
+	MinSkillLevel = 0
+	MaxSkillLevel = 20
+
+	// The range of ratings UCI_Elo can be set to. The minimum rating maps
+	// to the minimum skill level, and the maximum rating to full strength.
+	MinElo = 1000
+	MaxElo = 2400
+
+	// The number of root moves searched and picked between at a limited
+	// skill level.
+	SkillMultiPV = 4
+
+	// The amount of evaluation noise, in centipawns, added for each skill
+	// level below full strength.
+	SkillNoisePerLevel int16 = 8
+)
+
+// The maximum number of nodes the search may use at each skill level below
+// full strength.
+var SkillNodeLimits = [MaxSkillLevel]uint64{
+	1000, 4000, 9000, 16000, 25000,
+	36000, 49000, 64000, 81000, 100000,
+	121000, 144000, 169000, 196000, 225000,
+	256000, 289000, 324000, 361000, 400000,
+}
+
+// A struct representing the skill level the search is limited to. The zero
+// value of a Skill is full strength.
+type Skill struct {
+	Enabled bool
+	Level   int
+}
+
+// A struct representing a root move and the score the search gave it.
+type rootLine struct {
+	Move  Move
+	Score int16
+}
+
+// Create a skill limited to the given level. A level outside of the
+// valid range is clamped to it.
+func NewSkill(level int) Skill {
+	if level < MinSkillLevel {
+		level = MinSkillLevel
+	}
+
+	if level >= MaxSkillLevel {
+		return Skill{}
+	}
+
+	return Skill{Enabled: true, Level: level}
+}
+
+// Create a skill limited to the level matching the given rating.
+func NewSkillFromElo(elo int) Skill {
+	return NewSkill((elo - MinElo) * MaxSkillLevel / (MaxElo - MinElo))
+}
+
+// Get the maximum number of nodes the search may use at this skill level.
+func (skill *Skill) nodeLimit() uint64 {
+	return SkillNodeLimits[skill.Level]
+}
+
+// Get the evaluation noise for the position with the given hash. The noise
+// is derived from the hash so that a position is always given the same score
+// at a skill level, which keeps the transposition table consistent.
+func (skill *Skill) noise(hash uint64) int16 {
+	amplitude := int16(MaxSkillLevel-skill.Level) * SkillNoisePerLevel
+	mixed := hash * 0x9e3779b97f4a7c15
+	mixed ^= mixed >> 32
+	return int16(mixed%uint64(2*amplitude+1)) - amplitude
+}
+
+// Pick a move from the given root lines, which are expected to be ordered
+// from best to worst. The lower the skill level, the more likely a worse
+// move is picked. The idea behind this method is taken from Stockfish:
+//
+// https://github.com/official-stockfish/Stockfish/blob/sf_14/src/search.cpp#L1886
+func (skill *Skill) pickMove(lines []rootLine) Move {
+	topScore := int(lines[0].Score)
+	delta := topScore - int(lines[len(lines)-1].Score)
+	if delta > int(PieceValueMG[Pawn]) {
+		delta = int(PieceValueMG[Pawn])
+	}
+
+	weakness := 120 - 2*skill.Level
+	bestMove := lines[0].Move
+	maxScore := -int(Inf)
+
+	for _, line := range lines {
+		push := (weakness*(topScore-int(line.Score)) + delta*rand.Intn(weakness)) / 128
+		if int(line.Score)+push >= maxScore {
+			maxScore = int(line.Score) + push
+			bestMove = line.Move
+		}
+	}
+
+	return bestMove
+}
+
+// The iterative deepening loop used when the search is limited to a skill
+// level. Each iteration searches the best few root moves with a full window,
+// and once the search is done, a move is picked between them.
+func (search *Search) limitedSearch() Move {
+	var lines []rootLine
+
+	// The best move of a search cut short, in case no search finishes.
+	partialMove := NullMove
+
+	for depth := uint8(1); depth <= MaxPly && depth <= search.SpecifiedDepth; depth++ {
+		var depthLines []rootLine
+		search.rootDepth = depth
+
+		for pvIndex := 1; pvIndex <= SkillMultiPV; pvIndex++ {
+			var pvLine PVLine
+			search.nodes = 0
+			search.seldepth = 0
+
+			startTime := time.Now()
+			score := search.negamax(int8(depth), 0, -Inf, Inf, &pvLine, true)
+			endTime := time.Since(startTime)
+			search.totalNodes += search.nodes
+
+			// Stop once we're out of nodes or time, or we've run out
+			// of root moves to search.
+			if search.Timer.Stopped() || len(pvLine.Moves) == 0 {
+				if partialMove == NullMove && len(pvLine.Moves) != 0 {
+					partialMove = pvLine.GetPVMove()
+				}
+				break
+			}
+
+			depthLines = append(depthLines, rootLine{pvLine.GetPVMove(), score})
+			search.excludedRootMoves = append(search.excludedRootMoves, pvLine.GetPVMove())
+
+			search.report(SearchInfo{
+				Depth:    depth,
+				SelDepth: search.seldepth,
+				MultiPV:  pvIndex,
+				Score:    score,
+				Nodes:    search.nodes,
+				NPS:      NodesPerSecond(search.nodes, endTime),
+				Hashfull: search.TT.Hashfull(),
+				Time:     endTime,
+				PV:       pvLine,
+			})
+		}
+
+		search.excludedRootMoves = nil
+
+		// An iteration cut short is only used if we don't have a
+		// completed one to fall back on.
+		if search.Timer.Stopped() {
+			if len(lines) == 0 {
+				lines = depthLines
+			}
+			break
+		}
+
+		lines = depthLines
+
+		// Don't start another iteration once the soft time limit has passed.
+		if search.Timer.SoftTimeUp() {
+			break
+		}
+	}
+
+	// If we ran out of nodes or time before the first search finished,
+	// fall back on the move it found so far, or on any move we can make.
+	if len(lines) == 0 {
+		if partialMove != NullMove {
+			return partialMove
+		}
+		return search.firstSearchMove()
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Score > lines[j].Score
+	})
+	return search.Skill.pickMove(lines)
+}
@@ -2,33 +2,113 @@ package engine
 
 // timemanager.go implements the time mangement logic which Blunder
 // uses during its search phase.
+//
+// When playing with a clock, each search gets two deadlines. The soft
+// deadline is checked between iterations of the search, and no new
+// iteration is started once it's passed. It's scaled after every iteration
+// by how stable the best move has been, by the fraction of the nodes which
+// were spent on the best move, and by whether the score dropped. The hard
+// deadline is checked during the search, and stops it at once, so it's
+// kept well within the time left on the clock.
 
 import (
+	"sync/atomic"
 	"time"
 )
 
 const (
 	NoValue      int64 = 0
 	InfiniteTime int64 = -1
+
+	// The default and maximum time, in milliseconds, kept in reserve for each
+	// move, to make up for the delay between the search stopping and the
+	// move reaching the clock.
+	DefaultMoveOverhead int64 = 10
+	MaxMoveOverhead     int64 = 5000
+
+	// The number of moves the time left is assumed to have to last for
+	// when there's no time control coming up.
+	DefaultMovesToGo int64 = 40
+
+	// The percentage of its share of the time left the soft time limit is set
+	// to. An iteration started just before the soft time limit passes can run
+	// well past it, so it's kept below an even share.
+	SoftTimePercent int64 = 60
+
+	// How many times longer than the soft time limit the hard time limit is.
+	HardTimeScale int64 = 5
+
+	// The least the score must drop by between iterations before more
+	// time is given to the search.
+	ScoreDropMargin int16 = 30
 )
 
+// The percentages the soft time limit is scaled by, indexed by the number
+// of iterations in a row the best move has stayed the same.
+var BestMoveStabilityScales = [...]int64{200, 130, 100, 85, 75}
+
+// The percentage the soft time limit is scaled by when the score drops.
+var ScoreDropScale int64 = 130
+
 // A struct which holds data for a timer for Blunder's time mangement.
 type TimeManager struct {
 	TimeLeft  int64
 	Increment int64
 	MovesToGo int64
-	Stop      bool
 
-	stopTime        time.Time
+	// Whether the search should stop, which is set to one to stop it. It's
+	// read and written atomically, as it can be set from another goroutine.
+	stop int32
+
+	// A channel closed once the timer is started, if one is waiting on it.
+	started chan struct{}
+
+	// The time in milliseconds kept in reserve for each move.
+	MoveOverhead int64
+
+	// The function used to tell the time. If not set, time.Now is used.
+	Clock func() time.Time
+
 	startTime       time.Time
 	hardTimeForMove int64
+
+	// The soft and hard time limits of the current search, in milliseconds,
+	// and the soft time limit before it was scaled.
 	SoftTimeForMove int64
+	HardTimeForMove int64
+	baseSoftTime    int64
+
+	// Whether the time limits of the current search were worked out from
+	// the clock, rather than given as a fixed time for the move.
+	managed bool
+
+	// The best move of the last iteration, and the number of iterations in
+	// a row it's been the best move.
+	bestMove          Move
+	bestMoveStability int
+}
+
+// Get the current time from the timer's clock.
+func (tm *TimeManager) now() time.Time {
+	if tm.Clock != nil {
+		return tm.Clock()
+	}
+	return time.Now()
 }
 
 // Start the timer, setting up the internal state.
 func (tm *TimeManager) Start() {
-	// Reset the flag time's up flag to false for a new search
-	tm.Stop = false
+	// Reset the flag time's up flag to false for a new search, and let
+	// anyone waiting to stop it know it can be stopped now.
+	atomic.StoreInt32(&tm.stop, 0)
+	if tm.started != nil {
+		close(tm.started)
+		tm.started = nil
+	}
+	tm.startTime = tm.now()
+	tm.managed = false
+	tm.bestMove = NullMove
+	tm.bestMoveStability = 0
 
 	// If we're given infinite time, we're done calculating the time for the
 	// current move.
@@ -39,43 +119,43 @@ func (tm *TimeManager) Start() {
 	// If we're given a hard time limit, we're also done calculating, since we've
 	// been told already how much time should be spent on the current search.
 	if tm.hardTimeForMove != NoValue {
+		tm.SoftTimeForMove = tm.hardTimeForMove
+		tm.HardTimeForMove = tm.hardTimeForMove
 		return
 	}
 
-	// Calculate the time we can allocate for the search about to start.
-	var timeForMove int64
-
-	if tm.MovesToGo != NoValue {
-		// If we have a certian amount of moves to go before the time we have left
-		// is reset, use that value to divide the time currently left.
-		timeForMove = tm.TimeLeft / tm.MovesToGo
-	} else {
-		// Otherwise get 2.5% of the current time left and use that.
-		timeForMove = tm.TimeLeft / 40
+	// If we have a certian amount of moves to go before the time we have left
+	// is reset, plan to use the time left over those moves. Otherwise plan to
+	// make it last a default number of moves.
+	movesToGo := DefaultMovesToGo
+	if tm.MovesToGo != NoValue && tm.MovesToGo < movesToGo {
+		movesToGo = tm.MovesToGo
 	}
 
-	// Give an bonus from the increment
-	timeForMove += tm.Increment / 2
+	// Count the increments we'll get over those moves as time left, and keep
+	// the move overhead in reserve for each of them.
+	timeLeft := tm.TimeLeft + tm.Increment*(movesToGo-1) - tm.MoveOverhead*(movesToGo+2)
+	if timeLeft < 0 {
+		timeLeft = 0
+	}
 
-	// If the increment bonus puts us outside of the actual time we
-	// have left, use the time we have left minus 500ms.
-	if timeForMove >= tm.TimeLeft {
-		timeForMove = tm.TimeLeft - 500
+	// Never let the hard limit take more than half of the time left on the
+	// clock after the overhead, unless the clock is reset after this move.
+	maxTime := (tm.TimeLeft - tm.MoveOverhead) / 2
+	if movesToGo == 1 {
+		maxTime = (tm.TimeLeft - tm.MoveOverhead) * 3 / 4
 	}
 
-	// If taking away 500ms puts us below zero, use 100ms
-	// to just get a move to return.
-	if timeForMove <= 0 {
-		timeForMove = 100
+	// If we're nearly out of time, use a millisecond to just get a move to
+	// return, since a search always finishes its first iteration.
+	if maxTime < 1 {
+		maxTime = 1
 	}
 
-	// Calculate the time from now when we need to stop searching, based on the
-	// time are allowed to spend on the current search.
-	tm.startTime = time.Now()
-	tm.stopTime = tm.startTime.Add(time.Duration(timeForMove) * time.Millisecond)
-	tm.SoftTimeForMove = timeForMove
-	tm.hardTimeForMove = NoValue
-	tm.Stop = false
+	tm.SoftTimeForMove = min64(timeLeft/movesToGo*SoftTimePercent/100, maxTime)
+	tm.HardTimeForMove = min64(max64(tm.SoftTimeForMove*HardTimeScale, 1), maxTime)
+	tm.baseSoftTime = tm.SoftTimeForMove
+	tm.managed = true
 }
 
 // Set a hard limit for the maximum amount of time the current
@@ -83,47 +163,97 @@ func (tm *TimeManager) Start() {
 // allow the search to use however much time it needs, but there
 // are cases where we want to enforce a strict time limit.
 //
-// This method should not be called after TimeManger.Start has been called,
-// since both methods act as the intializers of the current search, and one
-// would conflict with the other. So either TimeManager.Start or
-// TimeManeger.SetHardTimeForMove should be called to intialize the current
-// search time logic, but not both.
+// This method should be called before TimeManager.Start, which sets up
+// the time limits of the search from it. Calling it with NoValue lets the
+// time limits be worked out from the clock instead.
 func (tm *TimeManager) SetHardTimeForMove(newTime int64) {
 	tm.hardTimeForMove = newTime
-	tm.stopTime = time.Now().Add(time.Duration(newTime) * time.Millisecond)
 }
 
-// Set the soft time limit for current search. The soft time limit
-// is a reccomendation for how long the search should continue, but
-// can be changed by dynamic factors during the search.
-func (tm *TimeManager) SetSoftTimeForMove(newTime int64) {
-	// To avoid losing on time, we do enforce a rule that
-	// any update to the soft time limit must not exceeded
-	// more than 1/8th of the total time left for our side.
-	if newTime > tm.TimeLeft/8 {
-		newTime = tm.TimeLeft / 8
+// Determine if the time limits of the current search are worked out from the
+// clock, and not given as a fixed or infinite time for the move.
+func (tm *TimeManager) Managed() bool {
+	return tm.managed
+}
+
+// Update the soft time limit once an iteration of the search has completed,
+// given the best move it found, the nodes spent searching the best move and
+// every root move so far, and how much the score dropped from the last
+// iteration.
+func (tm *TimeManager) Update(bestMove Move, bestMoveNodes, totalNodes uint64, scoreDrop int16) {
+	if !tm.managed {
+		return
+	}
+
+	if bestMove.Equal(tm.bestMove) {
+		if tm.bestMoveStability < len(BestMoveStabilityScales)-1 {
+			tm.bestMoveStability++
+		}
+	} else {
+		tm.bestMove = bestMove
+		tm.bestMoveStability = 0
 	}
 
-	// If the hard time limit for this move has already been set, only update the
-	// time limit if the hard time limit has not been set, or it is still greater
-	// than or equal to the new soft time limit.
-	if newTime != NoValue && (tm.hardTimeForMove == NoValue || tm.hardTimeForMove >= newTime) {
-		tm.SoftTimeForMove = newTime
-		tm.stopTime = tm.startTime.Add(time.Duration(newTime) * time.Millisecond)
+	softTime := tm.baseSoftTime * BestMoveStabilityScales[tm.bestMoveStability] / 100
+
+	// The more of the nodes were spent on the best move, the less likely it
+	// is another move will turn out to be better, so the less time is needed.
+	// This scales the soft time limit from 202% to 67%.
+	if totalNodes > 0 {
+		bestMovePercent := int64(bestMoveNodes * 100 / totalNodes)
+		softTime = softTime * (150 - bestMovePercent) * 135 / 10000
+	}
+
+	if scoreDrop >= ScoreDropMargin {
+		softTime = softTime * ScoreDropScale / 100
+	}
+
+	tm.SoftTimeForMove = min64(softTime, tm.HardTimeForMove)
+}
+
+// Determine if the soft time limit of the current search has passed, in which
+// case no new iteration of the search should be started.
+func (tm *TimeManager) SoftTimeUp() bool {
+	if tm.TimeLeft == InfiniteTime {
+		return false
 	}
+	return tm.Elapsed() >= time.Duration(tm.SoftTimeForMove)*time.Millisecond
+}
+
+// Get the time elapsed since the timer was started.
+func (tm *TimeManager) Elapsed() time.Duration {
+	return tm.now().Sub(tm.startTime)
 }
 
 // Check if the time we alloted for picking this move has expired.
 func (tm *TimeManager) Check() {
-	// If we have infinite time, tm.Stop is set to false unless we've already
-	// been told to stop.
-	if !tm.Stop && tm.TimeLeft == InfiniteTime {
-		tm.Stop = false
+	// If we have infinite time, we only stop once we're told to.
+	if tm.TimeLeft == InfiniteTime {
 		return
 	}
 
 	// Otherwise figure out if our alloated time for this move is up.
-	if time.Now().After(tm.stopTime) {
-		tm.Stop = true
+	if tm.Elapsed() >= time.Duration(tm.HardTimeForMove)*time.Millisecond {
+		tm.Stop()
 	}
 }
+
+// Stop the search using the timer. This can be called from a goroutine other
+// than the search's, but only once the timer's been started, since starting
+// it clears the stop.
+func (tm *TimeManager) Stop() {
+	atomic.StoreInt32(&tm.stop, 1)
+}
+
+// Determine if the search using the timer should stop.
+func (tm *TimeManager) Stopped() bool {
+	return atomic.LoadInt32(&tm.stop) == 1
+}
+
+// Get a channel which is closed when the timer is next started, so the search
+// can be stopped from another goroutine once it's running. This must be
+// called before the search is started.
+func (tm *TimeManager) notifyStart() <-chan struct{} {
+	tm.started = make(chan struct{})
+	return tm.started
+}
@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// A clock which only moves forward when it's told to, so the time manager can
+// be tested without waiting on real time.
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) advance(ms int64) {
+	clock.now = clock.now.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func newFakeTimer() (*TimeManager, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	tm := &TimeManager{Clock: clock.Now, MoveOverhead: DefaultMoveOverhead}
+	return tm, clock
+}
+
+// Simulate a search with the timer, where each iteration takes twice as long
+// as the one before it, and the timer is checked every millisecond. The best
+// move, the fraction of nodes spent on it and the score drop of each iteration
+// are picked at random.
+func simulateSearch(tm *TimeManager, clock *fakeClock, random *rand.Rand) {
+	tm.Start()
+	for iterationTime := int64(1); ; iterationTime *= 2 {
+		for ms := int64(0); ms < iterationTime; ms++ {
+			clock.advance(1)
+			tm.Check()
+			if tm.Stopped() {
+				return
+			}
+		}
+
+		bestMove := NewMove(E2, E4, Quiet, NoFlag)
+		if random.Intn(4) == 0 {
+			bestMove = NewMove(D2, D4, Quiet, NoFlag)
+		}
+
+		scoreDrop := int16(0)
+		if random.Intn(4) == 0 {
+			scoreDrop = 50
+		}
+
+		tm.Update(bestMove, uint64(random.Intn(1001)), 1000, scoreDrop)
+		if tm.SoftTimeUp() {
+			return
+		}
+	}
+}
+
+// Test that the time manager never loses on time over a long game, in sudden
+// death time controls and in time controls with an increment or a number of
+// moves to go. The delay between the search stopping and the move reaching
+// the clock is simulated as half of the move overhead.
+func TestTimeManagerNeverFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		timeLeft  int64
+		increment int64
+		movesToGo int64
+	}{
+		{"1 minute", 60000, 0, NoValue},
+		{"5 seconds", 5000, 0, NoValue},
+		{"1 minute + 1 second", 60000, 1000, NoValue},
+		{"1 second + 10 milliseconds", 1000, 10, NoValue},
+		{"100 milliseconds + 20 milliseconds", 100, 20, NoValue},
+		{"40 moves in 10 seconds", 10000, 0, 40},
+		{"1 move in 1 second", 1000, 0, 1},
+	}
+
+	for _, test := range tests {
+		tm, clock := newFakeTimer()
+		random := rand.New(rand.NewSource(1))
+		lag := tm.MoveOverhead / 2
+		timeLeft := test.timeLeft
+
+		for move := int64(0); move < 150; move++ {
+			tm.TimeLeft = timeLeft
+			tm.Increment = test.increment
+			tm.MovesToGo = NoValue
+			if test.movesToGo != NoValue {
+				tm.MovesToGo = test.movesToGo - move%test.movesToGo
+			}
+			tm.SetHardTimeForMove(NoValue)
+
+			simulateSearch(tm, clock, random)
+
+			used := int64(tm.Elapsed()/time.Millisecond) + lag
+			if tm.Elapsed() > time.Duration(tm.HardTimeForMove)*time.Millisecond {
+				t.Fatalf("%s: move %d took %v, past the hard limit of %dms", test.name, move+1, tm.Elapsed(), tm.HardTimeForMove)
+			}
+
+			timeLeft -= used
+			if timeLeft <= 0 {
+				t.Fatalf("%s: lost on time on move %d", test.name, move+1)
+			}
+
+			timeLeft += test.increment
+			if test.movesToGo != NoValue && tm.MovesToGo == 1 {
+				timeLeft += test.timeLeft
+			}
+		}
+	}
+}
+
+// Test that a fixed time for the move is used as both the soft and hard limit.
+func TestTimeManagerFixedTime(t *testing.T) {
+	tm, clock := newFakeTimer()
+	tm.TimeLeft = NoValue
+	tm.SetHardTimeForMove(500)
+	tm.Start()
+
+	if tm.Managed() {
+		t.Errorf("a fixed time for the move is managed as clock time")
+	}
+
+	clock.advance(499)
+	tm.Check()
+	if tm.Stopped() || tm.SoftTimeUp() {
+		t.Errorf("timer stopped before the time for the move was up")
+	}
+
+	clock.advance(1)
+	tm.Check()
+	if !tm.Stopped() || !tm.SoftTimeUp() {
+		t.Errorf("timer didn't stop when the time for the move was up")
+	}
+}
+
+// Test that a timer with infinite time never stops on its own.
+func TestTimeManagerInfiniteTime(t *testing.T) {
+	tm, clock := newFakeTimer()
+	tm.TimeLeft = InfiniteTime
+	tm.SetHardTimeForMove(NoValue)
+	tm.Start()
+
+	clock.advance(24 * 60 * 60 * 1000)
+	tm.Check()
+	if tm.Stopped() || tm.SoftTimeUp() {
+		t.Errorf("timer with infinite time stopped")
+	}
+}
+
+// Test that the soft time limit shrinks as the best move stays the same and
+// the search spends more of its nodes on it, and grows when the best move
+// changes or the score drops, but never passes the hard time limit.
+func TestTimeManagerScaling(t *testing.T) {
+	tm, _ := newFakeTimer()
+	tm.TimeLeft = 60000
+	tm.SetHardTimeForMove(NoValue)
+	tm.Start()
+
+	e2e4, d2d4 := NewMove(E2, E4, Quiet, NoFlag), NewMove(D2, D4, Quiet, NoFlag)
+	tm.Update(e2e4, 500, 1000, 0)
+	unstable := tm.SoftTimeForMove
+
+	for i := 0; i < 5; i++ {
+		tm.Update(e2e4, 500, 1000, 0)
+	}
+	stable := tm.SoftTimeForMove
+
+	tm.Update(e2e4, 950, 1000, 0)
+	focused := tm.SoftTimeForMove
+
+	tm.Update(e2e4, 950, 1000, 100)
+	dropped := tm.SoftTimeForMove
+
+	tm.Update(d2d4, 10, 1000, 100)
+	changed := tm.SoftTimeForMove
+
+	if stable >= unstable {
+		t.Errorf("expected a stable best move to use less time than an unstable one: %d vs %d", stable, unstable)
+	}
+
+	if focused >= stable {
+		t.Errorf("expected spending more nodes on the best move to use less time: %d vs %d", focused, stable)
+	}
+
+	if dropped <= focused {
+		t.Errorf("expected a score drop to use more time: %d vs %d", dropped, focused)
+	}
+
+	if changed != tm.HardTimeForMove {
+		t.Errorf("expected the soft limit to be capped at the hard limit %d, got %d", tm.HardTimeForMove, changed)
+	}
+}
+
+// Test that the search stops after its first iteration when there's only one
+// legal move, even when it has plenty of time left.
+func TestSearchSingleLegalMove(t *testing.T) {
+	var search Search
+	search.TT.Resize(1)
+
+	// White's only legal move is Kxg2.
+	search.Pos.LoadFEN("k7/8/8/8/8/8/6q1/7K w - - 0 1")
+	search.Timer.TimeLeft = 60000
+	search.Timer.SetHardTimeForMove(NoValue)
+	search.SpecifiedDepth = MaxPly
+	search.SpecifiedNodes = math.MaxUint64
+
+	iterations := 0
+	search.Report = func(info SearchInfo) { iterations++ }
+
+	if move := search.Search(); !move.Equal(NewMove(H1, G2, Attack, NoFlag)) {
+		t.Errorf("expected Kxg2, got %v", move)
+	}
+
+	if iterations != 1 {
+		t.Errorf("expected the search to stop after one iteration, it ran %d", iterations)
+	}
+}
@@ -11,14 +11,17 @@ const (
 	// considering memory alignment.
 	TTEntrySize = 16
 
-	// Constants representing the different flags for a transposition table entry,
-	// which determine what kind of entry it is. If the entry has a score from
-	// a fail-low node (alpha wasn't raised), it's an alpha entry. If the entry has
-	// a score from a fail-high node (a beta cutoff occured), it's a beta entry. And
-	// if the entry has an exact score (alpha was raised), it's an exact entry.
-	AlphaFlag uint8 = iota
-	BetaFlag
-	ExactFlag
+	// Constants for the number of entries in a cluster, and its size in bytes.
+	TTClusterSize  = 4
+	TTClusterBytes = TTClusterSize * TTEntrySize
+
+	// How many plies of depth an entry is worth less for each search
+	// since the one that stored it, when picking an entry to replace.
+	TTAgeWeight = 8
+
+	// How many plies deeper an entry from the current search must be than
+	// a new non-exact result for the same position to be kept.
+	TTDepthMargin = 4
 
 	// A constant representing an invalid score from probing the transposition table.
 	// this constant's value doesn't matter as long as it's not in the range of possible
@@ -31,99 +34,205 @@ const (
 	Checkmate = 9000
 )
 
-// A struct for a transposition table entry.
+const (
+	// Constants representing the different flags for a transposition table entry,
+	// which determine what kind of entry it is. If the entry has a score from
+	// a fail-low node (alpha wasn't raised), it's an alpha entry. If the entry has
+	// a score from a fail-high node (a beta cutoff occured), it's a beta entry. And
+	// if the entry has an exact score (alpha was raised), it's an exact entry. An
+	// entry that hasn't been used yet has no flag.
+	AlphaFlag uint8 = iota + 1
+	BetaFlag
+	ExactFlag
+)
+
+// A struct for a transposition table entry. Only the upper 32 bits of a position's
+// hash are stored in an entry, since the lower bits are used to index the table.
 type TT_Entry struct {
-	Hash  uint64
-	Depth uint8
+	Key   uint32
+	Best  Move
 	Score int16
+	Depth uint8
 	Flag  uint8
-	Best  Move
+	Age   uint8
 }
 
+// A cluster of entries, which share the same index in the table and, being
+// 64 bytes large, fit in a single cache line.
+type TTCluster [TTClusterSize]TT_Entry
+
 // A struct for a transposition table.
 type TransTable struct {
-	entries []TT_Entry
-	size    uint64
+	clusters []TTCluster
+	mask     uint64
+	age      uint8
 }
 
-// Resize the transposition table given what the size should be in MB.
+// Resize the transposition table given what the size should be in MB. The
+// number of clusters in the table is rounded down to a power of two, so an
+// index can be calculated by masking the hash of a position.
 func (tt *TransTable) Resize(sizeInMB uint64) {
-	size := (sizeInMB * 1024 * 1024) / TTEntrySize
-	tt.entries = make([]TT_Entry, size)
-	tt.size = size
+	count := uint64(1)
+	for count*2*TTClusterBytes <= sizeInMB*1024*1024 {
+		count *= 2
+	}
+
+	tt.clusters = make([]TTCluster, count)
+	tt.mask = count - 1
+	tt.age = 0
+}
+
+// Start a new search, ageing every entry stored by previous searches, so
+// they're the first to be replaced.
+func (tt *TransTable) NewSearch() {
+	tt.age++
+}
+
+// Get the key stored in an entry for the given hash.
+func ttKey(hash uint64) uint32 {
+	return uint32(hash >> 32)
+}
+
+// Get the cluster of entries the given hash is stored in.
+func (tt *TransTable) cluster(hash uint64) *TTCluster {
+	return &tt.clusters[hash&tt.mask]
+}
+
+// Determine if an entry hasn't been used yet.
+func (entry *TT_Entry) isEmpty() bool {
+	return entry.Flag == 0
+}
+
+// Get the entry stored for the given hash, and whether there was one.
+func (tt *TransTable) ProbeEntry(hash uint64) (TT_Entry, bool) {
+	// Since index collisions can occur, test if the key of each entry in the
+	// position's cluster matches the key for the current position.
+	key := ttKey(hash)
+	cluster := tt.cluster(hash)
+	for index := range cluster {
+		entry := &cluster[index]
+		if entry.Key == key && !entry.isEmpty() {
+			// The entry is still useful, so make sure it isn't replaced
+			// before the entries of older searches.
+			entry.Age = tt.age
+			return *entry, true
+		}
+	}
+	return TT_Entry{}, false
 }
 
 // Get an entry from the table.
 func (tt *TransTable) Probe(hash uint64, ply, depth uint8, alpha, beta int16, best *Move) int16 {
-	// Get the entry from the table, calculating an index by modulo-ing the hash of
-	// the position by the size of the table.
-	entry := tt.entries[hash%tt.size]
-
-	adjustedScore := Invalid
-
-	// Since index collisions can occur, test if the hash of the entry at this index
-	// actually matches the hash for the current position.
-	if entry.Hash == hash {
-
-		// Even if we don't get a score we can use from the table, we can still
-		// use the best move in this entry and put it first in our move ordering
-		// scheme.
-		*best = entry.Best
-
-		// To be able to get an accurate value from this entry, make sure the results of
-		// this entry are from a search that is equal or greater than the current
-		// depth of our search.
-		if entry.Depth >= depth {
-			score := entry.Score
-
-			// If the score we get from the transposition table is a checkmate score, we need
-			// to do a little extra work. This is because we store checkmates in the table using
-			// their distance from the node they're found in, not their distance from the root.
-			// So if we found a checkmate-in-8 in a node that was 5 plies from the root, we need
-			// to store the score as a checkmate-in-3. Then, if we read the checkmate-in-3 from
-			// the table in a node that's 4 plies from the root, we need to return the score as
-			// checkmate-in-7.
-			if score > Checkmate {
-				score -= int16(ply)
-			}
+	entry, ok := tt.ProbeEntry(hash)
+	if !ok {
+		return Invalid
+	}
 
-			if score < -Checkmate {
-				score += int16(ply)
-			}
+	// Even if we don't get a score we can use from the table, we can still
+	// use the best move in this entry and put it first in our move ordering
+	// scheme.
+	*best = entry.Best
+	return entry.UsableScore(ply, depth, alpha, beta)
+}
 
-			if entry.Flag == ExactFlag {
-				// If we have an exact entry, we can use the saved score.
-				adjustedScore = score
-			}
+// Get the score of the entry, as seen from a node the given number of plies
+// from the root.
+func (entry *TT_Entry) AdjustedScore(ply uint8) int16 {
+	score := entry.Score
 
-			if entry.Flag == AlphaFlag && score <= alpha {
-				// If we have an alpha entry, and the entry's score is less than our
-				// current alpha, then we know that our current alpha is the best score
-				// we can get in this node, so we can stop searching and use alpha.
-				adjustedScore = alpha
-			}
+	// If the score we get from the transposition table is a checkmate score, we need
+	// to do a little extra work. This is because we store checkmates in the table using
+	// their distance from the node they're found in, not their distance from the root.
+	// So if we found a checkmate-in-8 in a node that was 5 plies from the root, we need
+	// to store the score as a checkmate-in-3. Then, if we read the checkmate-in-3 from
+	// the table in a node that's 4 plies from the root, we need to return the score as
+	// checkmate-in-7.
+	if score > Checkmate {
+		score -= int16(ply)
+	}
 
-			if entry.Flag == BetaFlag && score >= beta {
-				// If we have a beta entry, and the entry's score is greater than our
-				// current beta, then we have a beta-cutoff, since while
-				// searching this node previously, we found a value greater than the current
-				// beta. so we can stop searching and use beta.
-				adjustedScore = beta
-			}
-		}
+	if score < -Checkmate {
+		score += int16(ply)
 	}
 
-	// Return the score
-	return adjustedScore
+	return score
 }
 
-// Store an entry in the table.
+// Get the score the search can return from the entry, for a node searched with
+// the given depth and window, or Invalid if the entry can't be used.
+func (entry *TT_Entry) UsableScore(ply, depth uint8, alpha, beta int16) int16 {
+	// To be able to get an accurate value from this entry, make sure the results of
+	// this entry are from a search that is equal or greater than the current
+	// depth of our search.
+	if entry.Depth < depth {
+		return Invalid
+	}
+
+	score := entry.AdjustedScore(ply)
+
+	if entry.Flag == ExactFlag {
+		// If we have an exact entry, we can use the saved score.
+		return score
+	}
+
+	if entry.Flag == AlphaFlag && score <= alpha {
+		// If we have an alpha entry, and the entry's score is less than our
+		// current alpha, then we know that our current alpha is the best score
+		// we can get in this node, so we can stop searching and use alpha.
+		return alpha
+	}
+
+	if entry.Flag == BetaFlag && score >= beta {
+		// If we have a beta entry, and the entry's score is greater than our
+		// current beta, then we have a beta-cutoff, since while
+		// searching this node previously, we found a value greater than the current
+		// beta. so we can stop searching and use beta.
+		return beta
+	}
+
+	return Invalid
+}
+
+// Store an entry in the table. If the position already has an entry, it's
+// replaced unless it's from a much deeper search in the current search, and the
+// new entry isn't exact. Otherwise the entry in the position's cluster which is the
+// least valuable to keep is replaced: the shallowest, after taking how many
+// searches ago it was stored into account.
 func (tt *TransTable) Store(hash uint64, ply, depth uint8, score int16, flag uint8, best Move) {
-	entry := &tt.entries[hash%tt.size]
-	entry.Hash = hash
+	key := ttKey(hash)
+	cluster := tt.cluster(hash)
+
+	entry := &cluster[0]
+	for index := range cluster {
+		candidate := &cluster[index]
+
+		if candidate.Key == key && !candidate.isEmpty() {
+			entry = candidate
+			if depth+TTDepthMargin <= entry.Depth && flag != ExactFlag && entry.Age == tt.age {
+				// Keep the deeper result, but remember the move found.
+				if !best.Equal(NullMove) {
+					entry.Best = best
+				}
+				return
+			}
+			break
+		}
+
+		if candidate.replacementValue(tt.age) < entry.replacementValue(tt.age) {
+			entry = candidate
+		}
+	}
+
+	// If a different position is being replaced, or no move was found this
+	// time, don't keep the old move.
+	if entry.Key != key || !best.Equal(NullMove) {
+		entry.Best = best
+	}
+
+	entry.Key = key
 	entry.Depth = depth
 	entry.Flag = flag
-	entry.Best = best
+	entry.Age = tt.age
 
 	// If the score we get from the transposition table is a checkmate score, we need
 	// to do a little extra work. This is because we store checkmates in the table using
@@ -143,16 +252,50 @@ func (tt *TransTable) Store(hash uint64, ply, depth uint8, score int16, flag uin
 	entry.Score = score
 }
 
+// Get how valuable an entry is to keep in the table. Empty entries have no value,
+// and entries lose value the more searches ago they were stored.
+func (entry *TT_Entry) replacementValue(age uint8) int {
+	if entry.isEmpty() {
+		return -1 << 16
+	}
+	return int(entry.Depth) - TTAgeWeight*int(age-entry.Age)
+}
+
+// Estimate how full the transposition table is, in permill, by sampling
+// the first thousand entries of the table. Only entries from the current
+// search are counted.
+func (tt *TransTable) Hashfull() int {
+	clusters := uint64(1000 / TTClusterSize)
+	if uint64(len(tt.clusters)) < clusters {
+		clusters = uint64(len(tt.clusters))
+	}
+
+	if clusters == 0 {
+		return 0
+	}
+
+	used := uint64(0)
+	for idx := uint64(0); idx < clusters; idx++ {
+		for _, entry := range tt.clusters[idx] {
+			if !entry.isEmpty() && entry.Age == tt.age {
+				used++
+			}
+		}
+	}
+
+	return int(used * 1000 / (clusters * TTClusterSize))
+}
+
 // Unitialize the memory used by the transposition table
 func (tt *TransTable) Unitialize() {
-	tt.entries = nil
-	tt.size = 0
+	tt.clusters = nil
+	tt.mask = 0
 }
 
 // Clear the transposition table
 func (tt *TransTable) Clear() {
-	var idx uint64
-	for idx = 0; idx < tt.size; idx++ {
-		tt.entries[idx] = TT_Entry{}
+	for idx := range tt.clusters {
+		tt.clusters[idx] = TTCluster{}
 	}
+	tt.age = 0
 }
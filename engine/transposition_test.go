@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// Create a hash which indexes the given cluster, with the given key.
+func ttTestHash(key uint32, cluster uint64) uint64 {
+	return uint64(key)<<32 | cluster
+}
+
+func TestTTEntrySize(t *testing.T) {
+	if size := unsafe.Sizeof(TT_Entry{}); size != TTEntrySize {
+		t.Errorf("entries are %d bytes instead of %d", size, TTEntrySize)
+	}
+
+	if size := unsafe.Sizeof(TTCluster{}); size != TTClusterBytes {
+		t.Errorf("clusters are %d bytes instead of %d", size, TTClusterBytes)
+	}
+}
+
+func TestTTResize(t *testing.T) {
+	var tt TransTable
+	for _, test := range []struct {
+		sizeInMB uint64
+		clusters int
+	}{
+		{0, 1},
+		{1, 1024 * 1024 / TTClusterBytes},
+		{3, 2 * 1024 * 1024 / TTClusterBytes},
+		{64, 64 * 1024 * 1024 / TTClusterBytes},
+	} {
+		tt.Resize(test.sizeInMB)
+		if len(tt.clusters) != test.clusters || tt.mask != uint64(test.clusters-1) {
+			t.Errorf("a %dMB table has %d clusters and mask %x, instead of %d clusters",
+				test.sizeInMB, len(tt.clusters), tt.mask, test.clusters)
+		}
+	}
+}
+
+// Test that checkmate scores are stored relative to the node they're found in,
+// and read back relative to the root.
+func TestTTMateScores(t *testing.T) {
+	var tt TransTable
+	tt.Resize(1)
+
+	for _, test := range []struct {
+		score      int16
+		storePly   uint8
+		probePly   uint8
+		probeScore int16
+	}{
+		// A mate in 8 plies found 5 plies from the root is a mate in 3 plies
+		// from the node, which is a mate in 7 plies when probed 4 plies from
+		// the root.
+		{Inf - 8, 5, 4, Inf - 7},
+		{-Inf + 8, 5, 4, -Inf + 7},
+		{Inf - 10, 2, 6, Inf - 14},
+		{-Inf + 10, 2, 6, -Inf + 14},
+
+		// Other scores are stored as they are.
+		{150, 5, 4, 150},
+		{-Checkmate, 5, 4, -Checkmate},
+	} {
+		hash := ttTestHash(0xabcdef, 7)
+		tt.Store(hash, test.storePly, 10, test.score, ExactFlag, NullMove)
+
+		var best Move
+		score := tt.Probe(hash, test.probePly, 10, -Inf, Inf, &best)
+		if score != test.probeScore {
+			t.Errorf("score %d stored at ply %d was %d at ply %d, instead of %d",
+				test.score, test.storePly, score, test.probePly, test.probeScore)
+		}
+	}
+}
+
+// Test that the shallowest entry of a cluster is replaced, and that entries
+// from older searches are replaced first.
+func TestTTReplacement(t *testing.T) {
+	var tt TransTable
+	tt.Resize(1)
+
+	for key := uint32(1); key <= TTClusterSize; key++ {
+		tt.Store(ttTestHash(key, 3), 0, uint8(10+key), 0, ExactFlag, NullMove)
+	}
+
+	tt.Store(ttTestHash(100, 3), 0, 5, 0, ExactFlag, NullMove)
+	if _, ok := tt.ProbeEntry(ttTestHash(1, 3)); ok {
+		t.Errorf("the shallowest entry wasn't replaced")
+	}
+
+	for key := uint32(2); key <= TTClusterSize; key++ {
+		if _, ok := tt.ProbeEntry(ttTestHash(key, 3)); !ok {
+			t.Errorf("entry %d was replaced instead of the shallowest", key)
+		}
+	}
+
+	// Probing an entry keeps it from aging, so after two new searches, the
+	// deepest entry, which wasn't probed, is now the one to replace.
+	tt.NewSearch()
+	tt.NewSearch()
+	for _, key := range []uint32{2, 3, 100} {
+		tt.ProbeEntry(ttTestHash(key, 3))
+	}
+
+	tt.Store(ttTestHash(200, 3), 0, 1, 0, ExactFlag, NullMove)
+	if _, ok := tt.ProbeEntry(ttTestHash(TTClusterSize, 3)); ok {
+		t.Errorf("the entry from an older search wasn't replaced")
+	}
+}
+
+// Test that a result for a position isn't replaced by a much shallower
+// result from the same search, except to remember a move.
+func TestTTSamePositionReplacement(t *testing.T) {
+	var tt TransTable
+	tt.Resize(1)
+
+	hash := ttTestHash(42, 0)
+	move := NewMove(E2, E4, Quiet, NoFlag)
+
+	tt.Store(hash, 0, 12, 100, BetaFlag, NullMove)
+	tt.Store(hash, 0, 2, 50, AlphaFlag, move)
+
+	entry, _ := tt.ProbeEntry(hash)
+	if entry.Depth != 12 || entry.Score != 100 || !entry.Best.Equal(move) {
+		t.Errorf("got entry with depth %d, score %d and move %v", entry.Depth, entry.Score, entry.Best)
+	}
+
+	tt.Store(hash, 0, 2, 50, ExactFlag, NullMove)
+	entry, _ = tt.ProbeEntry(hash)
+	if entry.Depth != 2 || entry.Flag != ExactFlag || !entry.Best.Equal(move) {
+		t.Errorf("an exact entry didn't replace the entry, or lost its move")
+	}
+}
+
+func TestTTHashfull(t *testing.T) {
+	var tt TransTable
+	tt.Resize(1)
+
+	if hashfull := tt.Hashfull(); hashfull != 0 {
+		t.Errorf("an empty table is %d permill full", hashfull)
+	}
+
+	for cluster := uint64(0); cluster < uint64(len(tt.clusters))/2; cluster++ {
+		for key := uint32(1); key <= TTClusterSize; key++ {
+			tt.Store(ttTestHash(key, cluster), 0, 1, 0, ExactFlag, NullMove)
+		}
+	}
+
+	if hashfull := tt.Hashfull(); hashfull != 1000 {
+		t.Errorf("a table with its first half full is %d permill full, instead of 1000", hashfull)
+	}
+
+	tt.NewSearch()
+	if hashfull := tt.Hashfull(); hashfull != 0 {
+		t.Errorf("a table with entries from an older search is %d permill full", hashfull)
+	}
+}
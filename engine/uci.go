@@ -22,6 +22,15 @@ type UCIInterface struct {
 	OptionUseBook       bool
 	OptionBookPath      string
 	OptionBookMoveDelay int
+
+	OptionSkillLevel    int
+	OptionLimitStrength bool
+	OptionElo           int
+
+	// Closed once the search started by the last "go" command is done,
+	// or nil if no search has been started since the last one was waited
+	// for.
+	thinking *backgroundSearch
 }
 
 func (inter *UCIInterface) Reset() {
@@ -40,6 +49,10 @@ func (inter *UCIInterface) uciCommandResponse() {
 	fmt.Print("option name BookMoveDelay type spin default 2 min 0 max 10\n")
 	fmt.Print("option name MiddleGameContempt type spin default 25 min 0 max 100\n")
 	fmt.Print("option name EndGameContempt type spin default 0 min 0 max 100\n")
+	fmt.Printf("option name Skill Level type spin default %d min %d max %d\n", MaxSkillLevel, MinSkillLevel, MaxSkillLevel)
+	fmt.Print("option name UCI_LimitStrength type check default false\n")
+	fmt.Printf("option name UCI_Elo type spin default %d min %d max %d\n", MaxElo, MinElo, MaxElo)
+	fmt.Printf("option name Move Overhead type spin default %d min 0 max %d\n", DefaultMoveOverhead, MaxMoveOverhead)
 	fmt.Print("\nAvailable UCI commands:\n")
 
 	fmt.Print("    * uci\n    * isready\n    * ucinewgame")
@@ -53,8 +66,10 @@ func (inter *UCIInterface) uciCommandResponse() {
 	fmt.Print("\n\t* wtime <MILLISECONDS>\n\t* btime <MILLISECONDS>")
 	fmt.Print("\n\t* winc <MILLISECONDS>\n\t* binc <MILLISECONDS>")
 	fmt.Print("\n\t* movestogo <INTEGER>\n\t* depth <INTEGER>\n\t* nodes <INTEGER>\n\t* movetime <MILLISECONDS>")
+	fmt.Print("\n\t* searchmoves <MOVES>")
 	fmt.Print("\n\t* infinite")
 
+	fmt.Print("\n    * savehash <FILE>\n    * loadhash <FILE>")
 	fmt.Print("\n    * stop\n    * quit\n\n")
 	fmt.Printf("uciok\n\n")
 }
@@ -84,11 +99,6 @@ func (inter *UCIInterface) positionCommandResponse(command string) {
 			for _, moveAsString := range strings.Fields(args) {
 				move := MoveFromCoord(&inter.Search.Pos, moveAsString)
 				inter.Search.Pos.MakeMove(move)
-
-				// Decrementing the history counter here makes
-				// sure that no state is saved on the position's
-				// history stack since this move will never be undone.
-				inter.Search.Pos.StatePly--
 			}
 		}
 	}
@@ -156,9 +166,49 @@ func (inter *UCIInterface) setOptionCommandResponse(command string) {
 		if err == nil {
 			EndGameDraw = int16(contempt)
 		}
+	case "Skill Level":
+		level, err := strconv.Atoi(value)
+		if err == nil {
+			inter.OptionSkillLevel = level
+		}
+	case "UCI_LimitStrength":
+		if value == "true" {
+			inter.OptionLimitStrength = true
+		} else if value == "false" {
+			inter.OptionLimitStrength = false
+		}
+	case "UCI_Elo":
+		elo, err := strconv.Atoi(value)
+		if err == nil {
+			inter.OptionElo = elo
+		}
+	case "Move Overhead":
+		overhead, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && overhead >= 0 && overhead <= MaxMoveOverhead {
+			inter.Search.Timer.MoveOverhead = overhead
+		}
 	}
 }
 
+// Start responding to the command "go" in the background, so the commands
+// sent while the engine is searching can still be read.
+func (inter *UCIInterface) startSearch(command string) {
+	inter.stopSearch()
+	inter.thinking = inter.Search.runInBackground(func() {
+		inter.goCommandResponse(command)
+	})
+}
+
+// Stop the search started by the last "go" command, if it's still running,
+// and wait for it to report its best move.
+func (inter *UCIInterface) stopSearch() {
+	if inter.thinking == nil {
+		return
+	}
+	inter.thinking.stop()
+	inter.thinking = nil
+}
+
 // Respond to the command "go"
 func (inter *UCIInterface) goCommandResponse(command string) {
 	if inter.OptionUseBook {
@@ -191,6 +241,7 @@ func (inter *UCIInterface) goCommandResponse(command string) {
 	specifiedDepth := uint64(MaxPly)
 	specifiedNodes := uint64(math.MaxUint64)
 	searchTime := uint64(NoValue)
+	var searchMoves []Move
 
 	for index, field := range fields {
 		if strings.HasPrefix(field, colorPrefix) {
@@ -207,6 +258,8 @@ func (inter *UCIInterface) goCommandResponse(command string) {
 			specifiedNodes, _ = strconv.ParseUint(fields[index+1], 10, 64)
 		} else if field == "movetime" {
 			searchTime, _ = strconv.ParseUint(fields[index+1], 10, 64)
+		} else if field == "searchmoves" {
+			searchMoves = parseSearchMoves(&inter.Search.Pos, fields[index+1:])
 		}
 	}
 
@@ -225,12 +278,79 @@ func (inter *UCIInterface) goCommandResponse(command string) {
 	// Setup user defined search options if given.
 	inter.Search.SpecifiedDepth = uint8(specifiedDepth)
 	inter.Search.SpecifiedNodes = specifiedNodes
+	inter.Search.SearchMoves = searchMoves
+
+	// Limit the strength of the search if we've been asked to, with UCI_LimitStrength
+	// taking precedence over the skill level. Scores in the transposition table
+	// from a different skill level were found using a different evaluation, so
+	// clear the table when the skill level changes.
+	skill := NewSkill(inter.OptionSkillLevel)
+	if inter.OptionLimitStrength {
+		skill = NewSkillFromElo(inter.OptionElo)
+	}
+
+	if skill != inter.Search.Skill {
+		inter.Search.TT.Clear()
+		inter.Search.Skill = skill
+	}
 
 	// Report the best move found by the engine to the GUI.
 	bestMove := inter.Search.Search()
 	fmt.Printf("bestmove %v\n", bestMove)
 }
 
+// Parse the moves given after the "searchmoves" parameter of the "go" command.
+// Parsing stops at the first field that isn't a legal move in the current position,
+// which will be the next parameter of the command.
+func parseSearchMoves(pos *Position, fields []string) (searchMoves []Move) {
+	for _, field := range fields {
+		move, legal := ParseLegalMove(pos, field)
+		if !legal {
+			break
+		}
+		searchMoves = append(searchMoves, move)
+	}
+	return searchMoves
+}
+
+// Respond to the command "savehash", which saves the transposition table
+// to the file given. A running search is stopped first, so the table isn't
+// changing while it's saved.
+func (inter *UCIInterface) saveHashCommandResponse(command string) {
+	path := strings.TrimSpace(strings.TrimPrefix(command, "savehash"))
+	if path == "" {
+		fmt.Println("info string no file given to save the hash to")
+		return
+	}
+
+	inter.stopSearch()
+
+	if err := inter.Search.TT.SaveFile(path); err != nil {
+		fmt.Printf("info string failed to save the hash: %v\n", err)
+		return
+	}
+	fmt.Printf("info string saved the hash to %s\n", path)
+}
+
+// Respond to the command "loadhash", which loads the transposition table
+// from the file given. The table takes the size it was saved with. A running
+// search is stopped first, since it can't use the table while it's replaced.
+func (inter *UCIInterface) loadHashCommandResponse(command string) {
+	path := strings.TrimSpace(strings.TrimPrefix(command, "loadhash"))
+	if path == "" {
+		fmt.Println("info string no file given to load the hash from")
+		return
+	}
+
+	inter.stopSearch()
+
+	if err := inter.Search.TT.LoadFile(path); err != nil {
+		fmt.Printf("info string failed to load the hash: %v\n", err)
+		return
+	}
+	fmt.Printf("info string loaded a %dMB hash from %s\n", inter.Search.TT.SizeInMB(), path)
+}
+
 func (inter *UCIInterface) quitCommandResponse() {
 	inter.Search.TT.Unitialize()
 }
@@ -246,6 +366,9 @@ func (inter *UCIInterface) UCILoop() {
 	inter.Search.Pos.LoadFEN(FENStartPosition)
 	inter.OpeningBook = make(map[uint64][]PolyglotEntry)
 	inter.OptionBookMoveDelay = DefaultBookMoveDelay
+	inter.OptionSkillLevel = MaxSkillLevel
+	inter.OptionElo = MaxElo
+	inter.Search.Timer.MoveOverhead = DefaultMoveOverhead
 
 	for {
 		command, _ := reader.ReadString('\n')
@@ -263,9 +386,13 @@ func (inter *UCIInterface) UCILoop() {
 		} else if strings.HasPrefix(command, "position") {
 			inter.positionCommandResponse(command)
 		} else if strings.HasPrefix(command, "go") {
-			go inter.goCommandResponse(command)
+			inter.startSearch(command)
+		} else if strings.HasPrefix(command, "savehash") {
+			inter.saveHashCommandResponse(command)
+		} else if strings.HasPrefix(command, "loadhash") {
+			inter.loadHashCommandResponse(command)
 		} else if strings.HasPrefix(command, "stop") {
-			inter.Search.Timer.Stop = true
+			inter.stopSearch()
 		} else if command == "quit\n" {
 			inter.quitCommandResponse()
 			break
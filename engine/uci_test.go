@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Loading a hash while the engine is searching should stop the search and
+// wait for it, before the table is replaced.
+func TestLoadHashStopsSearch(t *testing.T) {
+	var saved TransTable
+	saved.Resize(1)
+	path := filepath.Join(t.TempDir(), "blunder.hash")
+	if err := saved.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var inter UCIInterface
+	inter.Search.TT.Resize(1)
+	inter.Search.Pos.LoadFEN(FENStartPosition)
+	inter.Search.Report = func(info SearchInfo) {}
+	inter.OptionSkillLevel = MaxSkillLevel
+	inter.OptionElo = MaxElo
+
+	inter.startSearch("go infinite\n")
+	inter.loadHashCommandResponse("loadhash " + path + "\n")
+
+	if inter.thinking != nil {
+		t.Errorf("Search is still running after loading the hash")
+	}
+	if inter.Search.TT.age != saved.age {
+		t.Errorf("Hash wasn't loaded, the table's age is %d instead of %d", inter.Search.TT.age, saved.age)
+	}
+}
@@ -47,6 +47,17 @@ func abs16(n int16) int16 {
 	return n
 }
 
+// Get the distance between two squares, which is the number of moves a king
+// needs to go from one to the other.
+func distance(sq1, sq2 uint8) int16 {
+	fileDistance := abs16(int16(FileOf(sq1)) - int16(FileOf(sq2)))
+	rankDistance := abs16(int16(RankOf(sq1)) - int16(RankOf(sq2)))
+	if fileDistance > rankDistance {
+		return fileDistance
+	}
+	return rankDistance
+}
+
 // Get the maximum between two signed 8-bit numbers.
 func max8(a, b int8) int8 {
 	if a > b {
@@ -55,6 +66,30 @@ func max8(a, b int8) int8 {
 	return b
 }
 
+// Get the minimum between two signed 16-bit numbers.
+func min16(a, b int16) int16 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Get the minimum between two signed 64-bit numbers.
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Get the maximum between two signed 64-bit numbers.
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // Determine if a square is dark.
 func sqIsDark(sq uint8) bool {
 	fileNo := FileOf(sq)
@@ -157,3 +192,97 @@ func ConvertSANToLAN(pos *Position, moveStr string) Move {
 
 	return matchingMove
 }
+
+// Convert a legal move into short algebraic notation, disambiguating the
+// moving piece if needed, and marking checks and checkmates.
+func ConvertMoveToSAN(pos *Position, move Move) string {
+	from, to := move.FromSq(), move.ToSq()
+	moved := pos.Squares[from].Type
+	san := ""
+
+	if move.MoveType() == Castle {
+		san = "O-O"
+		if FileOf(to) == FileOf(C1) {
+			san = "O-O-O"
+		}
+	} else {
+		isCapture := move.MoveType() == Attack || pos.Squares[to].Type != NoType
+
+		if moved == Pawn {
+			if isCapture {
+				san += move.String()[0:1]
+			}
+		} else {
+			san += string("NBRQK"[moved-1])
+			san += disambiguateMove(pos, move)
+		}
+
+		if isCapture {
+			san += "x"
+		}
+		san += move.String()[2:4]
+
+		if move.MoveType() == Promotion {
+			san += "=" + string("NBRQ"[move.Flag()])
+		}
+	}
+
+	pos.MakeMove(move)
+	if pos.InCheck() {
+		if HasLegalMove(pos) {
+			san += "+"
+		} else {
+			san += "#"
+		}
+	}
+	pos.UnmakeMove(move)
+
+	return san
+}
+
+// Get the origin file and/or rank needed to tell the given piece move apart
+// from the moves of any other pieces of the same type to the same square.
+func disambiguateMove(pos *Position, move Move) string {
+	from, to := move.FromSq(), move.ToSq()
+	moved := pos.Squares[from].Type
+	sameFile, sameRank, ambiguous := false, false, false
+
+	moves := GenLegalMoves(pos)
+	for index := 0; index < int(moves.Count); index++ {
+		other := moves.Moves[index]
+		otherFrom := other.FromSq()
+
+		if other.ToSq() != to || otherFrom == from || pos.Squares[otherFrom].Type != moved {
+			continue
+		}
+
+		ambiguous = true
+		sameFile = sameFile || FileOf(otherFrom) == FileOf(from)
+		sameRank = sameRank || RankOf(otherFrom) == RankOf(from)
+	}
+
+	coords := move.String()
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return coords[0:1]
+	case !sameRank:
+		return coords[1:2]
+	default:
+		return coords[0:2]
+	}
+}
+
+// Determine if the side to move has a legal move in the given position.
+func HasLegalMove(pos *Position) bool {
+	return GenLegalMoves(pos).Count != 0
+}
+
+// Get the name of the given color.
+func ColorName(color uint8) string {
+	if color == White {
+		return "white"
+	}
+	return "black"
+}
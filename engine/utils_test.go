@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+// utils_test.go tests the conversion of moves to short algebraic notation.
+
+type SANPosition struct {
+	Fen  string
+	Move string
+	SAN  string
+}
+
+var SANTestPositions []SANPosition = []SANPosition{
+	{FENStartPosition, "e2e4", "e4"},
+	{FENStartPosition, "g1f3", "Nf3"},
+	{FENKiwiPete, "e1g1", "O-O"},
+	{FENKiwiPete, "e1c1", "O-O-O"},
+	{FENKiwiPete, "e5f7", "Nxf7"},
+	{FENKiwiPete, "d5e6", "dxe6"},
+	{"7k/8/8/8/8/8/8/1N2KN2 w - - 0 1", "b1d2", "Nbd2"},
+	{FENKiwiPete, "d2c1", "Bc1"},
+	{"k7/8/8/8/8/8/8/R4RK1 w - - 0 1", "a1d1", "Rad1"},
+	{"7k/8/8/8/R7/8/8/R3K3 w - - 0 1", "a1a3", "R1a3"},
+	{"7k/8/8/8/Q6Q/8/8/Q3K3 w - - 0 1", "a4d4", "Qa4d4+"},
+	{"7k/8/8/8/Q6Q/8/8/Q3K3 w - - 0 1", "a1d4", "Q1d4+"},
+	{"k7/8/8/3pP3/8/8/8/K7 w - d6 0 1", "e5d6", "exd6"},
+	{"1r5k/P7/8/8/8/8/8/K7 w - - 0 1", "a7b8q", "axb8=Q+"},
+	{"7k/P7/8/8/8/8/8/K7 w - - 0 1", "a7a8n", "a8=N"},
+	{"6k1/5ppp/8/8/8/8/8/K2R4 w - - 0 1", "d1d8", "Rd8#"},
+	{"6k1/5pp1/8/8/8/8/8/K2R4 w - - 0 1", "d1d8", "Rd8+"},
+}
+
+func TestConvertMoveToSAN(t *testing.T) {
+	var pos Position
+
+	for _, sanPos := range SANTestPositions {
+		pos.LoadFEN(sanPos.Fen)
+		move, legal := ParseLegalMove(&pos, sanPos.Move)
+		if !legal {
+			t.Error(fmt.Sprintf("Move %s is not legal in position %s", sanPos.Move, sanPos.Fen))
+			continue
+		}
+
+		san := ConvertMoveToSAN(&pos, move)
+		if san != sanPos.SAN {
+			t.Error(
+				fmt.Sprintf(
+					"SAN test failed for position %s, move %s. Got %s instead of %s",
+					sanPos.Fen, sanPos.Move, san, sanPos.SAN,
+				),
+			)
+		}
+
+		if pos.GenFEN() != sanPos.Fen {
+			t.Error(fmt.Sprintf("Position %s was changed to %s", sanPos.Fen, pos.GenFEN()))
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+// validate.go implements an integrity checker for positions, which cross-checks
+// the redundant ways a position stores its state, so bugs in making and
+// unmaking moves can be caught where they happen, rather than long after as a
+// bad move or score. Built with the debug tag, the engine checks the position
+// after every move made and unmade, and panics with the moves that led to a
+// corrupted position:
+//
+//	go test -tags debug ./engine
+//
+// Without the tag, the checks are compiled out.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The square each piece must be on for the castling rights to be valid, and
+// the castling right each set of squares belongs to.
+var castlingRightSquares = [4]struct {
+	right          uint8
+	color          uint8
+	kingSq, rookSq uint8
+}{
+	{WhiteKingsideRight, White, E1, H1},
+	{WhiteQueensideRight, White, E1, A1},
+	{BlackKingsideRight, Black, E8, H8},
+	{BlackQueensideRight, Black, E8, A8},
+}
+
+// Check that the different parts of the position agree with each other: the
+// piece bitboards, the side bitboards and the mailbox board, the number of
+// kings of each side, the castling rights, the en passant square, the zobrist
+// hashes, and the scores kept updated incrementally. The first
+// inconsistency found is returned as an error.
+func (pos *Position) Validate() error {
+	if pos.SideToMove != White && pos.SideToMove != Black {
+		return fmt.Errorf("invalid side to move %d", pos.SideToMove)
+	}
+
+	for color := Black; color <= White; color++ {
+		var sideBB Bitboard
+		for pieceType := Pawn; pieceType <= King; pieceType++ {
+			sideBB |= pos.PieceBB[color][pieceType]
+		}
+
+		if sideBB != pos.SideBB[color] {
+			return fmt.Errorf("side bitboard of %s doesn't match its piece bitboards", ColorName(color))
+		}
+
+		if kings := pos.PieceBB[color][King].CountBits(); kings != 1 {
+			return fmt.Errorf("%s has %d kings", ColorName(color), kings)
+		}
+	}
+
+	if pos.SideBB[White]&pos.SideBB[Black] != 0 {
+		return fmt.Errorf("side bitboards overlap")
+	}
+
+	for sq := uint8(0); sq < 64; sq++ {
+		piece := pos.Squares[sq]
+		bitboardPieces := 0
+
+		for color := Black; color <= White; color++ {
+			for pieceType := Pawn; pieceType <= King; pieceType++ {
+				if !pos.PieceBB[color][pieceType].BitSet(sq) {
+					continue
+				}
+
+				bitboardPieces++
+				if piece.Type != pieceType || piece.Color != color {
+					return fmt.Errorf("board and bitboards disagree on the piece on %s", posToCoordinate(sq))
+				}
+			}
+		}
+
+		if bitboardPieces > 1 {
+			return fmt.Errorf("more than one piece on %s in the bitboards", posToCoordinate(sq))
+		}
+
+		if bitboardPieces == 0 && (piece.Type != NoType || piece.Color != NoColor) {
+			return fmt.Errorf("board has a piece on %s that's missing from the bitboards", posToCoordinate(sq))
+		}
+
+		if piece.Type == Pawn && (RankOf(sq) == Rank1 || RankOf(sq) == Rank8) {
+			return fmt.Errorf("pawn on %s", posToCoordinate(sq))
+		}
+	}
+
+	for _, castling := range castlingRightSquares {
+		if pos.CastlingRights&castling.right == 0 {
+			continue
+		}
+
+		king, rook := pos.Squares[castling.kingSq], pos.Squares[castling.rookSq]
+		if king.Type != King || king.Color != castling.color || rook.Type != Rook || rook.Color != castling.color {
+			return fmt.Errorf("castling rights 0x%x without the king and rook on their squares", pos.CastlingRights)
+		}
+	}
+
+	if pos.EPSq != NoSq {
+		if err := pos.validateEPSq(); err != nil {
+			return err
+		}
+	}
+
+	if hash := Zobrist.GenHash(pos); hash != pos.Hash {
+		return fmt.Errorf("zobrist hash is 0x%x instead of 0x%x", pos.Hash, hash)
+	}
+
+	if pawnHash := Zobrist.GenPawnHash(pos); pawnHash != pos.PawnHash {
+		return fmt.Errorf("pawn zobrist hash is 0x%x instead of 0x%x", pos.PawnHash, pawnHash)
+	}
+
+	mgScores, egScores, phase := pos.GenScores()
+	if mgScores != pos.MGScores || egScores != pos.EGScores || phase != pos.Phase {
+		return fmt.Errorf(
+			"scores are %v/%v with phase %d instead of %v/%v with phase %d",
+			pos.MGScores, pos.EGScores, pos.Phase, mgScores, egScores, phase,
+		)
+	}
+
+	return nil
+}
+
+// Check that the position is consistent, like Validate, and that it could be
+// reached in a game, so it's safe to search: the side which isn't to move
+// can't be in check, or the side to move could capture its king.
+func (pos *Position) ValidateLegal() error {
+	if err := pos.Validate(); err != nil {
+		return err
+	}
+
+	them := pos.SideToMove ^ 1
+	if sqIsAttacked(pos, them, pos.PieceBB[them][King].Msb()) {
+		return fmt.Errorf("%s is in check but isn't to move", ColorName(them))
+	}
+	return nil
+}
+
+// Check that the en passant square of the position is behind an enemy pawn
+// which just moved two squares, and that one of our pawns can capture it.
+func (pos *Position) validateEPSq() error {
+	if pos.EPSq > H8 {
+		return fmt.Errorf("invalid en passant square %d", pos.EPSq)
+	}
+
+	epRank, pawnSq, originSq := uint8(Rank6), pos.EPSq-8, pos.EPSq+8
+	if pos.SideToMove == Black {
+		epRank, pawnSq, originSq = Rank3, pos.EPSq+8, pos.EPSq-8
+	}
+
+	coordinate := posToCoordinate(pos.EPSq)
+	if RankOf(pos.EPSq) != epRank {
+		return fmt.Errorf("en passant square %s is on the wrong rank", coordinate)
+	}
+
+	pawn := pos.Squares[pawnSq]
+	if pawn.Type != Pawn || pawn.Color != pos.SideToMove^1 {
+		return fmt.Errorf("en passant square %s isn't behind an enemy pawn", coordinate)
+	}
+
+	if pos.Squares[pos.EPSq].Type != NoType || pos.Squares[originSq].Type != NoType {
+		return fmt.Errorf("en passant square %s or the square behind it is occupied", coordinate)
+	}
+
+	if PawnAttacks[pos.SideToMove^1][pos.EPSq]&pos.PieceBB[pos.SideToMove][Pawn] == 0 {
+		return fmt.Errorf("en passant square %s can't be captured on", coordinate)
+	}
+
+	return nil
+}
+
+// Get the moves made in the position since it was loaded.
+func (pos *Position) movePath() []Move {
+	moves := make([]Move, len(pos.prevStates))
+	for idx := range moves {
+		moves[idx] = pos.prevStates[idx].Move
+	}
+	return moves
+}
+
+// Validate the position after the given move was made or unmade, and panic
+// with the moves that led to the position if it's corrupted. Only called in
+// debug builds.
+func (pos *Position) debugValidate(action string, move Move) {
+	err := pos.Validate()
+	if err == nil {
+		return
+	}
+
+	moves := pos.movePath()
+	if action == "unmaking" {
+		moves = append(moves, move)
+	}
+
+	// Null moves are written the way the UCI protocol writes them.
+	path := make([]string, len(moves))
+	for idx, move := range moves {
+		path[idx] = "0000"
+		if move != NullMove {
+			path[idx] = move.String()
+		}
+	}
+
+	panic(fmt.Sprintf(
+		"position corrupted after %s %s: %v\nmoves: %s\n%v",
+		action, path[len(path)-1], err, strings.Join(path, " "), pos,
+	))
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test that every position reached in a shallow perft of the positions in
+// the suite is valid.
+func TestValidatePerftSuite(t *testing.T) {
+	var pos Position
+	for _, perftTest := range loadPerftSuite() {
+		pos.LoadFEN(perftTest.FEN)
+		checkValid(t, &pos, 3)
+	}
+}
+
+func checkValid(t *testing.T, pos *Position, depth uint8) {
+	if err := pos.Validate(); err != nil {
+		t.Fatalf("%s after %v: %v", pos.GenFEN(), pos.movePath(), err)
+	}
+
+	if depth == 0 {
+		return
+	}
+
+	moves := GenLegalMoves(pos)
+	for idx := uint8(0); idx < moves.Count; idx++ {
+		pos.MakeMove(moves.Moves[idx])
+		checkValid(t, pos, depth-1)
+		pos.UnmakeMove(moves.Moves[idx])
+	}
+}
+
+// Test that each kind of corruption of a position is caught.
+func TestValidateCorruption(t *testing.T) {
+	corruptions := []struct {
+		name    string
+		corrupt func(pos *Position)
+		err     string
+	}{
+		{"hash", func(pos *Position) { pos.Hash ^= 1 }, "zobrist hash"},
+		{"pawn hash", func(pos *Position) { pos.PawnHash ^= 1 }, "pawn zobrist hash"},
+		{"side bitboard", func(pos *Position) { pos.SideBB[White].ClearBit(E1) }, "side bitboard"},
+		{"board", func(pos *Position) { pos.Squares[D2] = Piece{Type: Knight, Color: White} }, "disagree"},
+		{"missing piece", func(pos *Position) { pos.Squares[E4] = Piece{Type: Queen, Color: Black} }, "missing"},
+		{"scores", func(pos *Position) { pos.Phase++ }, "scores"},
+		{"castling", func(pos *Position) { pos.Squares[H1], pos.Squares[G1] = pos.Squares[G1], pos.Squares[H1] }, "disagree"},
+		{"en passant", func(pos *Position) { pos.EPSq = E3 }, "en passant"},
+		{"kings", func(pos *Position) { pos.PieceBB[Black][King], pos.SideBB[Black] = 0, pos.SideBB[Black]&^SquareBB[E8] }, "kings"},
+	}
+
+	var pos Position
+	for _, corruption := range corruptions {
+		pos.LoadFEN(FENStartPosition)
+		corruption.corrupt(&pos)
+
+		err := pos.Validate()
+		if err == nil {
+			t.Errorf("Corrupting the %s wasn't caught", corruption.name)
+		} else if !strings.Contains(err.Error(), corruption.err) {
+			t.Errorf("Corrupting the %s was reported as %q", corruption.name, err)
+		}
+	}
+}
+
+// Test that the castling rights are checked against the pieces on the board,
+// which the other checks can't catch.
+func TestValidateCastlingRights(t *testing.T) {
+	var pos Position
+	pos.LoadFEN("r3k2r/8/8/8/8/8/8/R3K1R1 w KQkq - 0 1")
+	if err := pos.Validate(); err == nil || !strings.Contains(err.Error(), "castling rights") {
+		t.Errorf("Castling rights without a rook on h1 were reported as %v", err)
+	}
+}
+
+// Test that a corrupted position is reported with the moves that led to it.
+func TestDebugValidateReportsMoves(t *testing.T) {
+	var pos Position
+	pos.LoadFEN(FENStartPosition)
+	for _, moveAsString := range []string{"e2e4", "e7e5"} {
+		pos.MakeMove(MoveFromCoord(&pos, moveAsString))
+	}
+	pos.Hash ^= 1
+
+	defer func() {
+		report, _ := recover().(string)
+		if !strings.Contains(report, "moves: e2e4 e7e5") || !strings.Contains(report, "zobrist hash") {
+			t.Errorf("Corruption was reported as %q", report)
+		}
+	}()
+	pos.debugValidate("making", MoveFromCoord(&pos, "e7e5"))
+}
+
+// Test that a position where the side which isn't to move is in check is
+// consistent, but not legal.
+func TestValidateLegal(t *testing.T) {
+	var pos Position
+	for _, perftTest := range loadPerftSuite() {
+		pos.LoadFEN(perftTest.FEN)
+		if err := pos.ValidateLegal(); err != nil {
+			t.Errorf("%s isn't legal: %v", perftTest.FEN, err)
+		}
+	}
+
+	pos.LoadFEN("4k3/4R3/8/8/8/8/8/4K3 w - - 0 1")
+	if err := pos.Validate(); err != nil {
+		t.Errorf("Position with the side not to move in check isn't consistent: %v", err)
+	}
+	if err := pos.ValidateLegal(); err == nil || !strings.Contains(err.Error(), "black is in check") {
+		t.Errorf("Position with the side not to move in check is legal: %v", err)
+	}
+}
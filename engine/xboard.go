@@ -0,0 +1,433 @@
+package engine
+
+// xboard.go implements version 2 of the XBoard/CECP protocol, so Blunder can be
+// used by GUIs and tournament managers that don't support UCI:
+//
+// https://www.gnu.org/software/xboard/engine-intf.html
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// XBoard's default time control of 40 moves in 5 minutes, used until
+	// we're given one with the "level" command.
+	DefaultMovesPerSession       = 40
+	DefaultSessionTime     int64 = 5 * 60 * 1000
+
+	// The score XBoard uses for a mate in zero moves.
+	XBoardMateScore = 100000
+)
+
+type XBoardInterface struct {
+	Search Search
+
+	// The position the current game started from, and the moves
+	// played since, which are needed to take moves back.
+	startFEN string
+	moves    []Move
+
+	forceMode   bool
+	analyzeMode bool
+	post        bool
+	engineColor uint8
+
+	movesPerSession int64
+	increment       int64
+	timeLeft        int64
+	timeForMove     int64
+	depthLimit      uint8
+
+	// The search currently running, and whether the move it finds should be
+	// thrown away instead of played.
+	thinking    *backgroundSearch
+	discardMove bool
+	mutex       sync.Mutex
+
+	// Where the responses to XBoard are written.
+	out io.Writer
+}
+
+func (inter *XBoardInterface) Reset() {
+	*inter = XBoardInterface{}
+}
+
+// Respond to the command "protover"
+func (inter *XBoardInterface) protoverCommandResponse() {
+	fmt.Fprint(inter.out, "feature done=0\n")
+	fmt.Fprintf(inter.out, "feature myname=\"%v\"\n", EngineName)
+	fmt.Fprint(inter.out, "feature ping=1 setboard=1 playother=1 usermove=1 san=0 time=1 draw=0\n")
+	fmt.Fprint(inter.out, "feature sigint=0 sigterm=0 reuse=1 analyze=1 colors=0 name=0 ics=0\n")
+	fmt.Fprint(inter.out, "feature done=1\n")
+}
+
+// Respond to the command "new"
+func (inter *XBoardInterface) newCommandResponse() {
+	inter.stopThinking(true)
+
+	inter.startFEN = FENStartPosition
+	inter.moves = nil
+	inter.Search.Pos.LoadFEN(FENStartPosition)
+	inter.Search.TT.Clear()
+	inter.Search.ClearHistoryTable()
+
+	inter.forceMode = false
+	inter.analyzeMode = false
+	inter.engineColor = Black
+
+	inter.movesPerSession = DefaultMovesPerSession
+	inter.increment = NoValue
+	inter.timeLeft = DefaultSessionTime
+	inter.timeForMove = NoValue
+	inter.depthLimit = MaxPly
+}
+
+// Respond to the command "setboard"
+func (inter *XBoardInterface) setboardCommandResponse(command string) {
+	inter.stopThinking(true)
+	fen := strings.TrimPrefix(command, "setboard ")
+
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintln(inter.out, "tellusererror Illegal position")
+			inter.setupPosition()
+		}
+	}()
+
+	// A position which can't be played from is reported like one which
+	// can't be loaded, and the game goes on from the position before.
+	inter.Search.Pos.LoadFEN(fen)
+	if err := inter.Search.Pos.ValidateLegal(); err != nil {
+		fmt.Fprintln(inter.out, "tellusererror Illegal position")
+		inter.setupPosition()
+		return
+	}
+
+	inter.startFEN = fen
+	inter.moves = nil
+	inter.restartAnalysis()
+}
+
+// Respond to the command "usermove"
+func (inter *XBoardInterface) usermoveCommandResponse(command string) {
+	inter.stopThinking(true)
+	moveStr := strings.TrimPrefix(command, "usermove ")
+
+	move, legal := ParseLegalMove(&inter.Search.Pos, moveStr)
+	if !legal {
+		fmt.Fprintf(inter.out, "Illegal move: %v\n", moveStr)
+		return
+	}
+
+	inter.makeMove(move)
+	if inter.analyzeMode {
+		inter.restartAnalysis()
+	} else if !inter.forceMode && inter.Search.Pos.SideToMove == inter.engineColor {
+		inter.think()
+	}
+}
+
+// Respond to the commands "undo" and "remove", which take back the
+// given number of moves.
+func (inter *XBoardInterface) takeBackCommandResponse(count int) {
+	inter.stopThinking(true)
+	if count > len(inter.moves) {
+		count = len(inter.moves)
+	}
+
+	inter.moves = inter.moves[:len(inter.moves)-count]
+	inter.setupPosition()
+	inter.restartAnalysis()
+}
+
+// Respond to the command "level"
+func (inter *XBoardInterface) levelCommandResponse(command string) {
+	fields := strings.Fields(command)
+	if len(fields) != 4 {
+		fmt.Fprintf(inter.out, "Error (malformed command): %v\n", command)
+		return
+	}
+
+	// The base time is given either in minutes, or as minutes and
+	// seconds seperated by a colon.
+	movesPerSession, _ := strconv.Atoi(fields[1])
+	var baseTime int64
+	if baseFields := strings.Split(fields[2], ":"); len(baseFields) == 2 {
+		minutes, _ := strconv.Atoi(baseFields[0])
+		seconds, _ := strconv.Atoi(baseFields[1])
+		baseTime = int64(minutes*60+seconds) * 1000
+	} else {
+		minutes, _ := strconv.ParseFloat(fields[2], 64)
+		baseTime = int64(minutes * 60 * 1000)
+	}
+	increment, _ := strconv.ParseFloat(fields[3], 64)
+
+	inter.movesPerSession = int64(movesPerSession)
+	inter.timeLeft = baseTime
+	inter.increment = int64(increment * 1000)
+	inter.timeForMove = NoValue
+}
+
+// Think about the current position, and play the best move found
+// once the search is finished.
+func (inter *XBoardInterface) think() {
+	// Don't search if the game is already over.
+	if result := inter.gameResult(); result != "" {
+		fmt.Fprintln(inter.out, result)
+		return
+	}
+
+	// Figure out how many moves we have left to make before the next time control.
+	movesToGo := NoValue
+	if inter.movesPerSession != 0 {
+		movesToGo = inter.movesPerSession - int64(len(inter.moves)/2)%inter.movesPerSession
+	}
+
+	if inter.timeForMove != NoValue {
+		inter.Search.Timer.SetHardTimeForMove(inter.timeForMove)
+		inter.Search.Timer.TimeLeft = NoValue
+	} else {
+		inter.Search.Timer.SetHardTimeForMove(NoValue)
+		inter.Search.Timer.TimeLeft = inter.timeLeft
+	}
+
+	inter.Search.Timer.Increment = inter.increment
+	inter.Search.Timer.MovesToGo = movesToGo
+	inter.Search.SpecifiedDepth = inter.depthLimit
+	inter.Search.SpecifiedNodes = math.MaxUint64
+
+	inter.startSearch()
+}
+
+// Start analyzing the current position, until we're told to stop.
+func (inter *XBoardInterface) analyze() {
+	// There's nothing to analyze if there are no moves left to play. A
+	// position the game could be drawn in can still be analyzed.
+	if !HasLegalMove(&inter.Search.Pos) {
+		return
+	}
+
+	inter.Search.Timer.SetHardTimeForMove(NoValue)
+	inter.Search.Timer.TimeLeft = InfiniteTime
+	inter.Search.SpecifiedDepth = MaxPly
+	inter.Search.SpecifiedNodes = math.MaxUint64
+
+	inter.startSearch()
+}
+
+// Restart the analysis of the current position, if we're analyzing.
+func (inter *XBoardInterface) restartAnalysis() {
+	if inter.analyzeMode {
+		inter.analyze()
+	}
+}
+
+// Run the search in the background, so we're still able to respond to
+// commands while thinking.
+func (inter *XBoardInterface) startSearch() {
+	inter.discardMove = false
+
+	inter.thinking = inter.Search.runInBackground(func() {
+		bestMove := inter.Search.Search()
+
+		inter.mutex.Lock()
+		defer inter.mutex.Unlock()
+
+		if inter.analyzeMode || inter.discardMove {
+			return
+		}
+
+		if bestMove != NullMove {
+			inter.makeMove(bestMove)
+			fmt.Fprintf(inter.out, "move %v\n", bestMove)
+		}
+
+		if result := inter.gameResult(); result != "" {
+			fmt.Fprintln(inter.out, result)
+		}
+	})
+}
+
+// Stop the search currently running, if there is one, and wait for it
+// to finish. If told to, the move found will be thrown away.
+func (inter *XBoardInterface) stopThinking(discard bool) {
+	if inter.thinking == nil {
+		return
+	}
+
+	inter.mutex.Lock()
+	inter.discardMove = discard
+	inter.mutex.Unlock()
+
+	inter.thinking.stop()
+	inter.thinking = nil
+}
+
+// Make the given move in the current game.
+func (inter *XBoardInterface) makeMove(move Move) {
+	inter.Search.Pos.MakeMove(move)
+	inter.moves = append(inter.moves, move)
+}
+
+// Setup the position of the current game from its starting position and
+// the moves played since.
+func (inter *XBoardInterface) setupPosition() {
+	inter.Search.Pos.LoadFEN(inter.startFEN)
+	for _, move := range inter.moves {
+		inter.Search.Pos.MakeMove(move)
+	}
+}
+
+// Get the result of the current game if it's over, or can be claimed to be
+// drawn, formatted the way XBoard expects it.
+func (inter *XBoardInterface) gameResult() string {
+	pos := &inter.Search.Pos
+	switch state := pos.GameState(); state {
+	case StateOngoing:
+		return ""
+	case StateCheckmate:
+		if pos.SideToMove == White {
+			return "0-1 {Black mates}"
+		}
+		return "1-0 {White mates}"
+	case StateStalemate:
+		return "1/2-1/2 {Stalemate}"
+	default:
+		return "1/2-1/2 {Draw by " + state.String() + "}"
+	}
+}
+
+// Display the thinking output of the search, if we've been told to.
+func (inter *XBoardInterface) postThinking(info SearchInfo) {
+	if !(inter.post || inter.analyzeMode) || info.Bound != ExactBound {
+		return
+	}
+
+	score := int(info.Score)
+	if info.Score > Checkmate {
+		score = XBoardMateScore + int(Inf-info.Score+1)/2
+	} else if info.Score < -Checkmate {
+		score = -XBoardMateScore - int(Inf+info.Score+1)/2
+	}
+
+	fmt.Fprintf(
+		inter.out, "%d %d %d %d %v\n",
+		info.Depth, score, info.Time.Milliseconds()/10,
+		info.Nodes, info.PV,
+	)
+}
+
+// Respond to a single command from XBoard, reporting whether it was the
+// command to quit.
+func (inter *XBoardInterface) handleCommand(command string) (quit bool) {
+	command = strings.TrimSpace(command)
+	fields := strings.Fields(command)
+
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "xboard", "accepted", "rejected", "random", "hard", "easy",
+		"computer", "name", "rating", "ics", "otim", "hint", "bk", "draw", ".":
+	case "protover":
+		inter.protoverCommandResponse()
+	case "new":
+		inter.newCommandResponse()
+	case "setboard":
+		inter.setboardCommandResponse(command)
+	case "usermove":
+		inter.usermoveCommandResponse(command)
+	case "force":
+		inter.stopThinking(true)
+		inter.forceMode = true
+	case "go":
+		inter.stopThinking(true)
+		inter.forceMode = false
+		inter.engineColor = inter.Search.Pos.SideToMove
+		inter.think()
+	case "playother":
+		inter.stopThinking(true)
+		inter.forceMode = false
+		inter.engineColor = inter.Search.Pos.SideToMove ^ 1
+	case "?":
+		if !inter.analyzeMode {
+			inter.stopThinking(false)
+		}
+	case "undo":
+		inter.takeBackCommandResponse(1)
+	case "remove":
+		inter.takeBackCommandResponse(2)
+	case "level":
+		inter.levelCommandResponse(command)
+	case "st":
+		if len(fields) == 2 {
+			seconds, _ := strconv.ParseFloat(fields[1], 64)
+			inter.timeForMove = int64(seconds * 1000)
+		}
+	case "sd":
+		if len(fields) == 2 {
+			depth, err := strconv.Atoi(fields[1])
+			if err == nil && depth > 0 && depth <= MaxPly {
+				inter.depthLimit = uint8(depth)
+			}
+		}
+	case "time":
+		if len(fields) == 2 {
+			centiseconds, _ := strconv.Atoi(fields[1])
+			inter.timeLeft = int64(centiseconds) * 10
+		}
+	case "post":
+		inter.post = true
+	case "nopost":
+		inter.post = false
+	case "analyze":
+		inter.stopThinking(true)
+		inter.analyzeMode = true
+		inter.analyze()
+	case "exit":
+		inter.stopThinking(true)
+		inter.analyzeMode = false
+	case "ping":
+		fmt.Fprintf(inter.out, "pong %v\n", strings.Join(fields[1:], " "))
+	case "result":
+		inter.stopThinking(true)
+		inter.forceMode = true
+	case "quit":
+		inter.stopThinking(true)
+		inter.Search.TT.Unitialize()
+		return true
+	default:
+		fmt.Fprintf(inter.out, "Error (unknown command): %v\n", fields[0])
+	}
+	return false
+}
+
+func (inter *XBoardInterface) XBoardLoop() {
+	reader := bufio.NewReader(os.Stdin)
+
+	inter.Reset()
+	inter.out = os.Stdout
+	inter.Search.TT.Resize(DefaultTTSize)
+	inter.Search.Report = inter.postThinking
+	inter.Search.Timer.MoveOverhead = DefaultMoveOverhead
+	inter.newCommandResponse()
+
+	for {
+		command, err := reader.ReadString('\n')
+		if err != nil {
+			command = "quit"
+		}
+
+		if inter.handleCommand(command) {
+			return
+		}
+	}
+}
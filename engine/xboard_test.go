@@ -0,0 +1,326 @@
+package engine
+
+// xboard_test.go drives the XBoard interface command by command, the way
+// XBoard would, and checks the responses written and the state of the game.
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A writer collecting the responses of the XBoard interface, which the search
+// running in the background writes to as well.
+type xboardOutput struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (output *xboardOutput) Write(p []byte) (int, error) {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	return output.buffer.Write(p)
+}
+
+// Get the responses written so far, and start collecting them afresh.
+func (output *xboardOutput) Take() string {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	responses := output.buffer.String()
+	output.buffer.Reset()
+	return responses
+}
+
+// Create an XBoard interface writing its responses to the returned output,
+// with a new game set up.
+func newTestXBoard() (*XBoardInterface, *xboardOutput) {
+	output := &xboardOutput{}
+	inter := &XBoardInterface{out: output}
+	inter.Search.TT.Resize(1)
+	inter.Search.Report = inter.postThinking
+	inter.newCommandResponse()
+	return inter, output
+}
+
+// Send each of the commands to the interface.
+func sendXBoardCommands(inter *XBoardInterface, commands ...string) {
+	for _, command := range commands {
+		inter.handleCommand(command + "\n")
+	}
+}
+
+// Wait for the search running in the background to finish by itself.
+func waitForXBoardSearch(t *testing.T, inter *XBoardInterface) {
+	t.Helper()
+	if inter.thinking == nil {
+		t.Fatal("No search is running")
+	}
+
+	select {
+	case <-inter.thinking.done:
+		inter.thinking = nil
+	case <-time.After(30 * time.Second):
+		t.Fatal("Search didn't finish")
+	}
+}
+
+func TestXBoardCommands(t *testing.T) {
+	inter, output := newTestXBoard()
+
+	sendXBoardCommands(inter, "xboard", "protover 2")
+	if responses := output.Take(); !strings.Contains(responses, "setboard=1") || !strings.HasSuffix(responses, "feature done=1\n") {
+		t.Errorf("Protover responded with %q", responses)
+	}
+
+	sendXBoardCommands(inter, "accepted setboard", "ping 7", "foo bar", "")
+	if responses := output.Take(); responses != "pong 7\nError (unknown command): foo\n" {
+		t.Errorf("Commands responded with %q", responses)
+	}
+
+	if !inter.handleCommand("quit\n") {
+		t.Errorf("Quit didn't end the loop")
+	}
+}
+
+func TestXBoardLevel(t *testing.T) {
+	tests := []struct {
+		command         string
+		movesPerSession int64
+		timeLeft        int64
+		increment       int64
+		malformed       bool
+	}{
+		{"level 40 5 0", 40, 5 * 60 * 1000, 0, false},
+		{"level 0 2:30 1.5", 0, 150 * 1000, 1500, false},
+		{"level 0 0.5 12", 0, 30 * 1000, 12000, false},
+		{"level 40 5", DefaultMovesPerSession, DefaultSessionTime, NoValue, true},
+	}
+
+	for _, test := range tests {
+		inter, output := newTestXBoard()
+		sendXBoardCommands(inter, "st 10", test.command)
+
+		if inter.movesPerSession != test.movesPerSession || inter.timeLeft != test.timeLeft || inter.increment != test.increment {
+			t.Errorf(
+				"%q set %d moves in %dms with a %dms increment instead of %d moves in %dms with a %dms increment",
+				test.command, inter.movesPerSession, inter.timeLeft, inter.increment,
+				test.movesPerSession, test.timeLeft, test.increment,
+			)
+		}
+
+		// A malformed command leaves the time control alone.
+		if responses := output.Take(); test.malformed != strings.HasPrefix(responses, "Error (malformed command)") {
+			t.Errorf("%q responded with %q", test.command, responses)
+		}
+		if !test.malformed && inter.timeForMove != NoValue {
+			t.Errorf("%q kept the fixed time for each move", test.command)
+		}
+	}
+}
+
+// In force mode, the moves given should be played without the engine
+// replying, and taking them back should restore the positions before them.
+func TestXBoardForceAndTakeBack(t *testing.T) {
+	inter, output := newTestXBoard()
+
+	sendXBoardCommands(inter, "force", "usermove e2e4", "usermove e7e5", "usermove g1g3", "usermove g1f3")
+	if inter.thinking != nil {
+		t.Errorf("Engine is thinking in force mode")
+	}
+	if responses := output.Take(); responses != "Illegal move: g1g3\n" {
+		t.Errorf("Moves responded with %q", responses)
+	}
+
+	expected := "rnbqkbnr/pppp1ppp/8/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2"
+	if fen := inter.Search.Pos.GenFEN(); fen != expected || len(inter.moves) != 3 {
+		t.Fatalf("Position after the moves is %s after %v", fen, inter.moves)
+	}
+
+	sendXBoardCommands(inter, "undo")
+	expected = "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 1"
+	if fen := inter.Search.Pos.GenFEN(); fen != expected || len(inter.moves) != 2 {
+		t.Errorf("Position after undo is %s after %v", fen, inter.moves)
+	}
+
+	// Taking back more moves than were played goes back to the start.
+	sendXBoardCommands(inter, "remove", "remove")
+	if fen := inter.Search.Pos.GenFEN(); fen != FENStartPosition || len(inter.moves) != 0 {
+		t.Errorf("Position after removing every move is %s after %v", fen, inter.moves)
+	}
+}
+
+// The engine should reply to the user's moves with the color it was told to
+// play, and be able to switch sides.
+func TestXBoardPlaysMoves(t *testing.T) {
+	inter, output := newTestXBoard()
+	sendXBoardCommands(inter, "sd 2", "usermove e2e4")
+	waitForXBoardSearch(t, inter)
+
+	if len(inter.moves) != 2 || inter.Search.Pos.SideToMove != White {
+		t.Fatalf("Engine didn't reply as Black, the moves are %v", inter.moves)
+	}
+	if responses := output.Take(); !strings.HasSuffix(responses, "move "+inter.moves[1].String()+"\n") {
+		t.Errorf("Engine's reply was %q instead of %v", responses, inter.moves[1])
+	}
+
+	// After playother, the engine plays White once Black has moved.
+	sendXBoardCommands(inter, "force", "usermove d2d4", "playother")
+	if inter.thinking != nil || inter.engineColor != White {
+		t.Fatalf("Engine isn't waiting to play White")
+	}
+
+	sendXBoardCommands(inter, "usermove d7d5")
+	waitForXBoardSearch(t, inter)
+	if len(inter.moves) != 5 || inter.Search.Pos.SideToMove != Black {
+		t.Errorf("Engine didn't reply as White, the moves are %v", inter.moves)
+	}
+
+	// Told to go, the engine plays the side to move.
+	sendXBoardCommands(inter, "go")
+	waitForXBoardSearch(t, inter)
+	if len(inter.moves) != 6 || inter.engineColor != Black {
+		t.Errorf("Engine didn't play Black after go, the moves are %v", inter.moves)
+	}
+}
+
+// Moving now should stop the search and play the best move found so far,
+// while leaving force mode or starting a new game should stop the search
+// and throw its move away.
+func TestXBoardStopThinking(t *testing.T) {
+	inter, output := newTestXBoard()
+	sendXBoardCommands(inter, "st 60", "go", "?")
+	if inter.thinking != nil {
+		t.Fatalf("Engine is still thinking after moving now")
+	}
+
+	if len(inter.moves) != 1 || !strings.HasSuffix(output.Take(), "move "+inter.moves[0].String()+"\n") {
+		t.Fatalf("Engine didn't move now, the moves are %v", inter.moves)
+	}
+
+	for _, command := range []string{"force", "new", "result 1-0 {White resigns}"} {
+		sendXBoardCommands(inter, "go")
+		sendXBoardCommands(inter, command)
+
+		if inter.thinking != nil {
+			t.Errorf("Engine is still thinking after %q", command)
+		}
+		if responses := output.Take(); strings.Contains(responses, "move ") {
+			t.Errorf("Engine played a move after %q: %q", command, responses)
+		}
+	}
+
+	if !inter.forceMode {
+		t.Errorf("Engine isn't in force mode after a result")
+	}
+}
+
+// Analysis should keep running until it's exited, without playing a move,
+// and restart after each move made.
+func TestXBoardAnalyze(t *testing.T) {
+	inter, output := newTestXBoard()
+	sendXBoardCommands(inter, "post", "analyze")
+
+	thinking := inter.thinking
+	sendXBoardCommands(inter, "usermove e2e4")
+	if inter.thinking == nil || inter.thinking == thinking {
+		t.Errorf("Analysis didn't restart after a move")
+	}
+
+	sendXBoardCommands(inter, "exit")
+	if inter.thinking != nil || inter.analyzeMode {
+		t.Errorf("Analysis is still running after exit")
+	}
+
+	if len(inter.moves) != 1 || strings.Contains(output.Take(), "move ") {
+		t.Errorf("Analysis played a move, the moves are %v", inter.moves)
+	}
+}
+
+// When the game is over, or a draw can be claimed, the engine should report
+// the result instead of searching.
+func TestXBoardResults(t *testing.T) {
+	tests := []struct {
+		fen    string
+		result string
+	}{
+		{"rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3", "0-1 {Black mates}"},
+		{"7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", "1/2-1/2 {Stalemate}"},
+		{"4k3/8/8/8/8/8/4P3/R3K3 w - - 100 80", "1/2-1/2 {Draw by fifty-move rule}"},
+		{"4k3/8/8/8/8/8/8/4KN2 w - - 0 1", "1/2-1/2 {Draw by insufficient material}"},
+	}
+
+	for _, test := range tests {
+		inter, output := newTestXBoard()
+		sendXBoardCommands(inter, "setboard "+test.fen, "go")
+
+		if inter.thinking != nil {
+			t.Errorf("Engine is thinking in %s", test.fen)
+		}
+		if responses := output.Take(); responses != test.result+"\n" {
+			t.Errorf("Result of %s is %q instead of %q", test.fen, responses, test.result)
+		}
+	}
+
+	// A position that can't be loaded is reported, and the game goes on
+	// from the position before.
+	inter, output := newTestXBoard()
+	sendXBoardCommands(inter, "sd 2", "usermove e2e4")
+	waitForXBoardSearch(t, inter)
+	output.Take()
+
+	fen := inter.Search.Pos.GenFEN()
+	moves := len(inter.moves)
+	for _, illegalFEN := range []string{"garbage", "8/8/8/8/8/8/8/4K3 w - - 0 1", "4k3/8/8/8/8/8/4R3/4K3 w - - 0 1"} {
+		sendXBoardCommands(inter, "setboard "+illegalFEN)
+		if responses := output.Take(); responses != "tellusererror Illegal position\n" {
+			t.Errorf("Setting up %q responded with %q", illegalFEN, responses)
+		}
+		if inter.Search.Pos.GenFEN() != fen || len(inter.moves) != moves {
+			t.Errorf("Position after setting up %q is %s instead of %s", illegalFEN, inter.Search.Pos.GenFEN(), fen)
+		}
+	}
+}
+
+func TestXBoardThinkingOutput(t *testing.T) {
+	tests := []struct {
+		score    int16
+		expected string
+	}{
+		{35, "5 35 12 1000 e2e4\n"},
+		{Inf - 1, "5 100001 12 1000 e2e4\n"},
+		{Inf - 4, "5 100002 12 1000 e2e4\n"},
+		{-Inf + 2, "5 -100001 12 1000 e2e4\n"},
+	}
+
+	inter, output := newTestXBoard()
+	info := SearchInfo{
+		Depth: 5,
+		Nodes: 1000,
+		Time:  123 * time.Millisecond,
+		PV:    PVLine{Moves: []Move{NewMove(E2, E4, Quiet, NoFlag)}},
+	}
+
+	// Nothing is posted until the engine's been told to.
+	inter.postThinking(info)
+	if responses := output.Take(); responses != "" {
+		t.Errorf("Thinking output was posted without post: %q", responses)
+	}
+
+	sendXBoardCommands(inter, "post")
+	for _, test := range tests {
+		info.Score = test.score
+		inter.postThinking(info)
+		if responses := output.Take(); responses != test.expected {
+			t.Errorf("Score %d was posted as %q instead of %q", test.score, responses, test.expected)
+		}
+	}
+
+	// Only exact scores are posted.
+	info.Bound = LowerBound
+	inter.postThinking(info)
+	if responses := output.Take(); responses != "" {
+		t.Errorf("Lower bound was posted: %q", responses)
+	}
+}
@@ -128,6 +128,19 @@ func (zobrist *_Zobrist) GenHash(pos *Position) (hash uint64) {
 	return hash
 }
 
+// Generate the zobrist hash of only the pawns of the given position
+// from scratch.
+func (zobrist *_Zobrist) GenPawnHash(pos *Position) (hash uint64) {
+	for color := Black; color <= White; color++ {
+		pawns := pos.PieceBB[color][Pawn]
+		for pawns != 0 {
+			sq := pawns.PopBit()
+			hash ^= zobrist.PieceNumber(Pawn, color, sq)
+		}
+	}
+	return hash
+}
+
 // Precomputing all possible en passant file numbers
 // is much more efficent for Blunder than calculating
 // them on the fly.
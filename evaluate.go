@@ -0,0 +1,288 @@
+package main
+
+// evaluate.go implements the /chess/evaluate endpoint, which searches a
+// position with the given limits and reports the best move found along
+// with the statistics of the search.
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"romanziske/engine"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// The engines that can be used to evaluate a position: Blunder's own
+	// search, or the NNUE based search.
+	EngineBlunder = "blunder"
+	EngineNNUE    = "nnue"
+
+	// Whether the results come from an endgame tablebase. No tablebase can
+	// be given to the server yet, so they never do.
+	fromTablebase = false
+)
+
+// The opening book used by /chess/evaluate, if one was given.
+var openingBook map[uint64][]engine.PolyglotEntry
+
+// A struct representing the limits of a search requested through the API.
+type searchLimits struct {
+	moveTime int64
+	depth    uint8
+	nodes    uint64
+	skill    engine.Skill
+}
+
+// Parse the search limits from the query of a request. An error message is
+// returned if a limit is invalid, or no limit was given.
+func parseSearchLimits(c *gin.Context) (limits searchLimits, errMsg string) {
+	limits.moveTime = MaxEngineMoveTime
+	limits.depth = engine.MaxPly
+	limits.nodes = math.MaxUint64
+	limited := false
+
+	if moveTimeStr, ok := c.GetQuery("movetime"); ok {
+		moveTime, err := strconv.ParseInt(moveTimeStr, 10, 64)
+		if err != nil || moveTime < 1 || moveTime > MaxEngineMoveTime {
+			return limits, "movetime parameter must be an integer between 1 and 60000 milliseconds"
+		}
+		limits.moveTime = moveTime
+		limited = true
+	} else if timeStr, ok := c.GetQuery("time"); ok {
+		// The time parameter, in whole seconds, is kept for compatibility.
+		seconds, err := strconv.ParseInt(timeStr, 10, 64)
+		if err != nil || seconds < 1 || seconds*1000 > MaxEngineMoveTime {
+			return limits, "time parameter must be an integer between 1 and 60 seconds"
+		}
+		limits.moveTime = seconds * 1000
+		limited = true
+	}
+
+	if depthStr, ok := c.GetQuery("depth"); ok {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil || depth < 1 || depth > engine.MaxPly {
+			return limits, "depth parameter must be an integer between 1 and " + strconv.Itoa(engine.MaxPly)
+		}
+		limits.depth = uint8(depth)
+		limited = true
+	}
+
+	if nodesStr, ok := c.GetQuery("nodes"); ok {
+		nodes, err := strconv.ParseUint(nodesStr, 10, 64)
+		if err != nil || nodes < 1 {
+			return limits, "nodes parameter must be a positive integer"
+		}
+		limits.nodes = nodes
+		limited = true
+	}
+
+	if levelStr, ok := c.GetQuery("level"); ok {
+		level, err := strconv.Atoi(levelStr)
+		if err != nil || level < engine.MinSkillLevel || level > engine.MaxSkillLevel {
+			return limits, "level parameter must be an integer between 0 and 20"
+		}
+		limits.skill = engine.NewSkill(level)
+	}
+
+	if !limited {
+		return limits, "one of the movetime, depth or nodes parameters must be given"
+	}
+	return limits, ""
+}
+
+func setupEvaluateRoute(r *gin.Engine) {
+	r.GET("/chess/evaluate", func(c *gin.Context) {
+		var pos engine.Position
+		if !loadFENQuery(c, &pos) {
+			return
+		}
+		fenStr := c.Query("fen")
+
+		limits, errMsg := parseSearchLimits(c)
+		if errMsg != "" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": errMsg,
+			})
+			return
+		}
+
+		if !engine.HasLegalMove(&pos) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "there are no legal moves in the position",
+			})
+			return
+		}
+
+		switch c.DefaultQuery("engine", EngineBlunder) {
+		case EngineBlunder:
+			if c.DefaultQuery("book", "true") != "false" {
+				if result, ok := probeBook(&pos); ok {
+					c.JSON(http.StatusOK, result)
+					return
+				}
+			}
+			c.JSON(http.StatusOK, evaluateWithEngine(fenStr, limits))
+		case EngineNNUE:
+			if limits.nodes != math.MaxUint64 || limits.skill.Enabled {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "the nnue engine doesn't support the nodes and level parameters",
+				})
+				return
+			}
+			c.JSON(http.StatusOK, evaluateWithNNUE(fenStr, limits))
+		default:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "engine parameter must be either blunder or nnue",
+			})
+		}
+	})
+}
+
+// Look for a move for the position in the opening book.
+func probeBook(pos *engine.Position) (gin.H, bool) {
+	entries, ok := openingBook[engine.GenPolyglotHash(pos)]
+	if !ok {
+		return nil, false
+	}
+
+	// To allow opening variety, randomly select a move from an entry matching
+	// the current position.
+	entry := entries[rand.Intn(len(entries))]
+	move, legal := engine.ParseLegalMove(pos, entry.Move)
+	if !legal {
+		return nil, false
+	}
+
+	san := engine.ConvertMoveToSAN(pos, move)
+	return gin.H{
+		"bestMove":    move.String(),
+		"bestMoveSan": san,
+		"pv":          []string{move.String()},
+		"pvSan":       []string{san},
+		"book":        true,
+		"tablebase":   fromTablebase,
+	}, true
+}
+
+// Search the position with the engine's own search, and collect the
+// statistics of the last iteration searching the best move found.
+func evaluateWithEngine(fenStr string, limits searchLimits) gin.H {
+	engineSearchMutex.Lock()
+	defer engineSearchMutex.Unlock()
+
+	var lastInfo engine.SearchInfo
+	var totalNodes uint64
+	lines := map[engine.Move]engine.SearchInfo{}
+
+	engineSearch.Report = func(info engine.SearchInfo) {
+		totalNodes += info.Nodes
+		if info.Bound == engine.ExactBound && len(info.PV.Moves) != 0 {
+			lastInfo = info
+			lines[info.PV.GetPVMove()] = info
+		}
+	}
+	defer func() { engineSearch.Report = ignoreSearchInfo }()
+
+	engineSearch.Pos.LoadFEN(fenStr)
+	pos := engineSearch.Pos.Copy()
+
+	startTime := time.Now()
+	bestMove := runEngineSearch(limits)
+	elapsed := time.Since(startTime)
+
+	// At a limited skill level, the move picked might not be the best line
+	// found, so report the line starting with the move picked instead.
+	info, ok := lines[bestMove]
+	if !ok {
+		info = lastInfo
+	}
+
+	pv := info.PV.Moves
+	if len(pv) == 0 || pv[0] != bestMove {
+		pv = []engine.Move{bestMove}
+	}
+
+	pvUCI, pvSAN := make([]string, len(pv)), make([]string, len(pv))
+	for index, move := range pv {
+		pvUCI[index] = move.String()
+		pvSAN[index] = engine.ConvertMoveToSAN(&pos, move)
+		pos.MakeMove(move)
+	}
+
+	whiteScore := info.Score
+	if engineSearch.Pos.SideToMove == engine.Black {
+		whiteScore = -whiteScore
+	}
+
+	return gin.H{
+		"bestMove":    bestMove.String(),
+		"bestMoveSan": pvSAN[0],
+		"score":       scoreToJSON(info.Score),
+		"whiteScore":  scoreToJSON(whiteScore),
+		"pv":          pvUCI,
+		"pvSan":       pvSAN,
+		"depth":       info.Depth,
+		"seldepth":    info.SelDepth,
+		"nodes":       totalNodes,
+		"nps":         engine.NodesPerSecond(totalNodes, elapsed),
+		"hashfull":    info.Hashfull,
+		"timeMs":      elapsed.Milliseconds(),
+		"time":        elapsed.String(),
+		"book":        false,
+		"tablebase":   fromTablebase,
+	}
+}
+
+// Search the position with the NNUE based search, which only reports the
+// best move, its score, and how deep it searched.
+func evaluateWithNNUE(fenStr string, limits searchLimits) gin.H {
+	engineSearchMutex.Lock()
+	defer engineSearchMutex.Unlock()
+
+	var pos engine.Position
+	pos.LoadFEN(fenStr)
+
+	move, score, depth := search(fenStr, limits.moveTime, int(limits.depth))
+	elapsed := time.Since(start)
+
+	whiteScore := score
+	if pos.SideToMove == engine.Black {
+		whiteScore = -whiteScore
+	}
+
+	san := engine.ConvertMoveToSAN(&pos, move)
+	return gin.H{
+		"bestMove":    move.String(),
+		"bestMoveSan": san,
+		"score":       gin.H{"cp": score},
+		"whiteScore":  gin.H{"cp": whiteScore},
+		"pv":          []string{move.String()},
+		"pvSan":       []string{san},
+		"depth":       depth,
+		"nodes":       nodes,
+		"nps":         engine.NodesPerSecond(uint64(nodes), elapsed),
+		"timeMs":      elapsed.Milliseconds(),
+		"time":        elapsed.String(),
+		"book":        false,
+		"tablebase":   fromTablebase,
+	}
+}
+
+// Convert a score into its JSON representation: either a centipawn score,
+// or the number of moves until checkmate, negative if the side the score
+// is for is getting mated.
+func scoreToJSON(score int16) gin.H {
+	if score > engine.Checkmate {
+		return gin.H{"mate": (int(engine.Inf-score) + 1) / 2}
+	}
+
+	if score < -engine.Checkmate {
+		return gin.H{"mate": -(int(engine.Inf+score) + 1) / 2}
+	}
+
+	return gin.H{"cp": score}
+}
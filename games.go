@@ -0,0 +1,504 @@
+package main
+
+// games.go implements the game session endpoints of the HTTP API. A game
+// session keeps the moves played since its starting position, so the rules
+// that depend on the history of a game, like threefold repetition and the
+// fifty-move rule, can be enforced.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"math"
+	"net/http"
+	"romanziske/engine"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// The statuses a game can have.
+	StatusOngoing              = "ongoing"
+	StatusCheckmate            = "checkmate"
+	StatusStalemate            = "stalemate"
+	StatusRepetition           = "repetition"
+	StatusFiftyMove            = "fiftyMove"
+	StatusFivefoldRepetition   = "fivefoldRepetition"
+	StatusSeventyFiveMove      = "seventyFiveMove"
+	StatusInsufficientMaterial = "insufficientMaterial"
+
+	// The default and maximum time, in milliseconds, the engine may use
+	// to pick its move in a game.
+	DefaultEngineMoveTime = 1000
+	MaxEngineMoveTime     = 60000
+
+	// The most games kept at once, and how long a game is kept after it
+	// was last used. Once there are too many games, the game used least
+	// recently is removed to make room for a new one.
+	MaxGames = 1000
+	GameTTL  = 24 * time.Hour
+)
+
+// A struct representing a game session.
+type Game struct {
+	ID       string
+	StartFEN string
+	Moves    []engine.Move
+
+	// The position after the moves played, which keeps the history of
+	// the game the rules depend on, and the draw claimed by a player, if
+	// one has been.
+	pos     engine.Position
+	claimed engine.GameState
+	mutex   sync.Mutex
+
+	// When the game was last used, which is guarded by the store's mutex.
+	lastUsed time.Time
+}
+
+// A struct holding the game sessions currently being played.
+type GameStore struct {
+	games map[string]*Game
+	mutex sync.Mutex
+}
+
+var games = GameStore{games: make(map[string]*Game)}
+
+// Create a new game starting from the given position, and add it to the store.
+func (store *GameStore) Create(fen string) *Game {
+	game := &Game{ID: newGameID(), StartFEN: fen, lastUsed: time.Now()}
+	game.pos.LoadFEN(fen)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.evict(game.lastUsed)
+	store.games[game.ID] = game
+	return game
+}
+
+// Remove the games which haven't been used within the time they're kept,
+// and if there's still no room for another game, the game used least
+// recently. The store's mutex is expected to be held.
+func (store *GameStore) evict(now time.Time) {
+	var oldest *Game
+	for id, game := range store.games {
+		if now.Sub(game.lastUsed) >= GameTTL {
+			delete(store.games, id)
+		} else if oldest == nil || game.lastUsed.Before(oldest.lastUsed) {
+			oldest = game
+		}
+	}
+
+	if len(store.games) >= MaxGames && oldest != nil {
+		delete(store.games, oldest.ID)
+	}
+}
+
+// Get the game with the given ID, unless it's no longer kept.
+func (store *GameStore) Get(id string) (*Game, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	game, ok := store.games[id]
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now()
+	if now.Sub(game.lastUsed) >= GameTTL {
+		delete(store.games, id)
+		return nil, false
+	}
+	game.lastUsed = now
+	return game, true
+}
+
+// Remove the game with the given ID from the store, reporting if it existed.
+func (store *GameStore) Delete(id string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	_, ok := store.games[id]
+	delete(store.games, id)
+	return ok
+}
+
+// Play the given move in the game. The move is expected to be legal.
+func (game *Game) play(move engine.Move) {
+	game.pos.MakeMove(move)
+	game.Moves = append(game.Moves, move)
+}
+
+// Get the state of the game. A draw by threefold repetition or the fifty-move
+// rule only ends the game once a player has claimed it, so until then the
+// game is still ongoing.
+func (game *Game) state() engine.GameState {
+	if game.claimed != engine.StateOngoing {
+		return game.claimed
+	}
+
+	state := game.pos.GameState()
+	if state.IsClaimable() {
+		return engine.StateOngoing
+	}
+	return state
+}
+
+// Get the draw a player could claim in the game, if there's one that hasn't
+// been claimed yet.
+func (game *Game) claimableDraw() (engine.GameState, bool) {
+	if game.claimed != engine.StateOngoing {
+		return engine.StateOngoing, false
+	}
+
+	state := game.pos.GameState()
+	return state, state.IsClaimable()
+}
+
+// The status of a game in each state the rules define.
+var gameStateStatuses = map[engine.GameState]string{
+	engine.StateOngoing:              StatusOngoing,
+	engine.StateCheckmate:            StatusCheckmate,
+	engine.StateStalemate:            StatusStalemate,
+	engine.StateThreefoldRepetition:  StatusRepetition,
+	engine.StateFiftyMoveRule:        StatusFiftyMove,
+	engine.StateFivefoldRepetition:   StatusFivefoldRepetition,
+	engine.StateSeventyFiveMoveRule:  StatusSeventyFiveMove,
+	engine.StateInsufficientMaterial: StatusInsufficientMaterial,
+}
+
+// Get the result, in PGN notation, of a game in the given state that has
+// reached the given position.
+func gameResult(pos *engine.Position, state engine.GameState) string {
+	switch {
+	case !state.IsOver():
+		return "*"
+	case state.IsDraw():
+		return "1/2-1/2"
+	case pos.SideToMove == engine.White:
+		return "0-1"
+	default:
+		return "1-0"
+	}
+}
+
+// Get the JSON representation of the game.
+func (game *Game) toJSON() gin.H {
+	moves := make([]string, len(game.Moves))
+	for index, move := range game.Moves {
+		moves[index] = move.String()
+	}
+
+	// The draw that can be claimed is null if there isn't one.
+	var claimableDraw interface{}
+	if draw, ok := game.claimableDraw(); ok {
+		claimableDraw = gameStateStatuses[draw]
+	}
+
+	state := game.state()
+	return gin.H{
+		"id":            game.ID,
+		"startFen":      game.StartFEN,
+		"fen":           game.pos.GenFEN(),
+		"moves":         moves,
+		"sideToMove":    engine.ColorName(game.pos.SideToMove),
+		"inCheck":       game.pos.InCheck(),
+		"status":        gameStateStatuses[state],
+		"result":        gameResult(&game.pos, state),
+		"claimableDraw": claimableDraw,
+	}
+}
+
+// Create a random ID for a game.
+func newGameID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Determine if the given string is a valid FEN string: six fields, with eight
+// squares on each of the eight ranks and a single king for each side, the
+// castling rights in order, an en passant square behind the pawn of the side
+// which just moved, and numeric move counters.
+func validFEN(fen string) bool {
+	fields := strings.Fields(fen)
+	if len(fields) != 6 || (fields[1] != "w" && fields[1] != "b") {
+		return false
+	}
+
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return false
+	}
+
+	kings := map[rune]int{}
+	for _, rank := range ranks {
+		squares := 0
+		for _, char := range rank {
+			switch {
+			case char >= '1' && char <= '8':
+				squares += int(char - '0')
+			case strings.ContainsRune("pnbrqkPNBRQK", char):
+				kings[char]++
+				squares++
+			default:
+				return false
+			}
+		}
+		if squares != 8 {
+			return false
+		}
+	}
+	if kings['K'] != 1 || kings['k'] != 1 {
+		return false
+	}
+
+	// Each castling right can only be given once, and in the order KQkq.
+	if castling := fields[2]; castling != "-" {
+		order := "KQkq"
+		for _, char := range castling {
+			index := strings.IndexRune(order, char)
+			if index < 0 {
+				return false
+			}
+			order = order[index+1:]
+		}
+	}
+
+	// The en passant square is on the third rank after White moved, and on
+	// the sixth rank after Black moved.
+	if ep := fields[3]; ep != "-" {
+		epRank := byte('6')
+		if fields[1] == "b" {
+			epRank = '3'
+		}
+		if len(ep) != 2 || ep[0] < 'a' || ep[0] > 'h' || ep[1] != epRank {
+			return false
+		}
+	}
+
+	// The full move counter is doubled into the game ply, which must fit
+	// in 16 bits.
+	_, halfMoveErr := strconv.ParseUint(fields[4], 10, 16)
+	_, fullMoveErr := strconv.ParseUint(fields[5], 10, 15)
+	return halfMoveErr == nil && fullMoveErr == nil
+}
+
+// Look up the game named in the request's path, responding with an error
+// if it doesn't exist.
+func lookupGame(c *gin.Context) (*Game, bool) {
+	game, ok := games.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "game not found",
+		})
+	}
+	return game, ok
+}
+
+// Bind the optional JSON body of a request, responding with an error
+// if it's malformed. An empty body is allowed.
+func bindOptionalJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body: " + err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+func setupGameRoutes(r *gin.Engine) {
+	r.POST("/games", func(c *gin.Context) {
+		var request struct {
+			FEN string `json:"fen"`
+		}
+		if !bindOptionalJSON(c, &request) {
+			return
+		}
+
+		fen := engine.FENStartPosition
+		if request.FEN != "" {
+			fen = strings.TrimSpace(request.FEN)
+		}
+
+		if !validFEN(fen) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "fen is not a valid FEN string",
+			})
+			return
+		}
+
+		var pos engine.Position
+		pos.LoadFEN(fen)
+		if err := pos.ValidateLegal(); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "fen is not a legal position: " + err.Error(),
+			})
+			return
+		}
+
+		game := games.Create(fen)
+		c.JSON(http.StatusCreated, game.toJSON())
+	})
+
+	r.GET("/games/:id", func(c *gin.Context) {
+		game, ok := lookupGame(c)
+		if !ok {
+			return
+		}
+
+		game.mutex.Lock()
+		defer game.mutex.Unlock()
+		c.JSON(http.StatusOK, game.toJSON())
+	})
+
+	r.DELETE("/games/:id", func(c *gin.Context) {
+		if !games.Delete(c.Param("id")) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "game not found",
+			})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	r.POST("/games/:id/moves", func(c *gin.Context) {
+		game, ok := lookupGame(c)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			Move string `json:"move" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "request body must contain a move",
+			})
+			return
+		}
+
+		game.mutex.Lock()
+		defer game.mutex.Unlock()
+
+		if game.state().IsOver() {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "game is over",
+			})
+			return
+		}
+
+		move, legal := engine.ParseLegalMove(&game.pos, request.Move)
+		if !legal {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "illegal move " + request.Move,
+			})
+			return
+		}
+
+		game.play(move)
+		c.JSON(http.StatusOK, game.toJSON())
+	})
+
+	r.POST("/games/:id/engine-move", func(c *gin.Context) {
+		game, ok := lookupGame(c)
+		if !ok {
+			return
+		}
+
+		request := struct {
+			MoveTime int  `json:"movetime"`
+			Level    *int `json:"level"`
+		}{MoveTime: DefaultEngineMoveTime}
+		if !bindOptionalJSON(c, &request) {
+			return
+		}
+
+		if request.MoveTime <= 0 || request.MoveTime > MaxEngineMoveTime {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "movetime must be between 1 and 60000 milliseconds",
+			})
+			return
+		}
+
+		skill := engine.Skill{}
+		if request.Level != nil {
+			if *request.Level < engine.MinSkillLevel || *request.Level > engine.MaxSkillLevel {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "level must be an integer between 0 and 20",
+				})
+				return
+			}
+			skill = engine.NewSkill(*request.Level)
+		}
+
+		game.mutex.Lock()
+		defer game.mutex.Unlock()
+
+		if game.state().IsOver() {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "game is over",
+			})
+			return
+		}
+
+		move := searchGame(game, int64(request.MoveTime), skill)
+		game.play(move)
+
+		response := game.toJSON()
+		response["move"] = move.String()
+		c.JSON(http.StatusOK, response)
+	})
+
+	r.POST("/games/:id/claim-draw", func(c *gin.Context) {
+		game, ok := lookupGame(c)
+		if !ok {
+			return
+		}
+
+		game.mutex.Lock()
+		defer game.mutex.Unlock()
+
+		if game.state().IsOver() {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "game is over",
+			})
+			return
+		}
+
+		draw, ok := game.claimableDraw()
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "no draw can be claimed",
+			})
+			return
+		}
+
+		game.claimed = draw
+		c.JSON(http.StatusOK, game.toJSON())
+	})
+}
+
+// Search for the engine's move in the given game, which must still be ongoing.
+// The whole game is replayed so the search knows which positions have already
+// been reached.
+func searchGame(game *Game, moveTime int64, skill engine.Skill) engine.Move {
+	engineSearchMutex.Lock()
+	defer engineSearchMutex.Unlock()
+
+	engineSearch.Pos.LoadFEN(game.StartFEN)
+	for _, move := range game.Moves {
+		engineSearch.Pos.MakeMove(move)
+	}
+
+	return runEngineSearch(searchLimits{
+		moveTime: moveTime,
+		depth:    engine.MaxPly,
+		nodes:    math.MaxUint64,
+		skill:    skill,
+	})
+}
@@ -0,0 +1,313 @@
+package lichess
+
+// bot.go implements a bot which plays games on Lichess using Blunder's
+// search. The bot accepts challenges matching its rules, plays one game
+// at a time, and uses the opening book if it's been given one.
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"romanziske/engine"
+	"strings"
+	"sync"
+)
+
+const (
+	// The reasons Lichess accepts for declining a challenge.
+	DeclineGeneric     = "generic"
+	DeclineLater       = "later"
+	DeclineTooFast     = "tooFast"
+	DeclineTooSlow     = "tooSlow"
+	DeclineTimeControl = "timeControl"
+	DeclineRated       = "rated"
+	DeclineCasual      = "casual"
+	DeclineStandard    = "standard"
+
+	// The status of a game which is still being played.
+	StatusStarted = "started"
+)
+
+// A struct representing the rules a challenge must satisfy to be accepted.
+// Only standard chess with a real time clock is played, and the initial
+// time of the clock, in seconds, must be between the limits given. A
+// maximum of zero means there's no maximum.
+type ChallengeRules struct {
+	MinInitial   int
+	MaxInitial   int
+	AcceptRated  bool
+	AcceptCasual bool
+}
+
+// The default rules, accepting any rated or casual game with a clock.
+var DefaultChallengeRules = ChallengeRules{
+	AcceptRated:  true,
+	AcceptCasual: true,
+}
+
+// The default time, in milliseconds, the bot keeps in reserve for each move,
+// to make up for the delay of sending the move to Lichess.
+const DefaultMoveOverhead int64 = 100
+
+// A struct representing a bot playing on Lichess.
+type Bot struct {
+	Client *Client
+	Rules  ChallengeRules
+	Book   map[uint64][]engine.PolyglotEntry
+	Logger *log.Logger
+
+	// The time in milliseconds kept in reserve for each move.
+	MoveOverhead int64
+
+	search  engine.Search
+	account User
+	playing bool
+	mutex   sync.Mutex
+	games   sync.WaitGroup
+}
+
+// Create a bot playing with the given client, using a transposition table
+// of the given size in megabytes.
+func NewBot(client *Client, hashSize uint64) *Bot {
+	bot := &Bot{Client: client, Rules: DefaultChallengeRules, MoveOverhead: DefaultMoveOverhead}
+	bot.search.TT.Resize(hashSize)
+	bot.search.Report = func(info engine.SearchInfo) {}
+	return bot
+}
+
+// Run the bot, responding to challenges and playing the games started until
+// the event stream ends or the context is cancelled. Run waits for the game
+// being played to finish before returning.
+func (bot *Bot) Run(ctx context.Context) error {
+	defer bot.games.Wait()
+
+	account, err := bot.Client.Account(ctx)
+	if err != nil {
+		return err
+	}
+	bot.account = account
+	bot.logf("playing as %s", account.Username)
+
+	return bot.Client.StreamEvents(ctx, func(event Event) {
+		switch event.Type {
+		case "challenge":
+			bot.handleChallenge(ctx, event.Challenge)
+		case "gameStart":
+			bot.handleGameStart(ctx, event.Game.GameID)
+		}
+	})
+}
+
+// Get the reason a challenge should be declined, or an empty string if
+// it should be accepted.
+func (rules *ChallengeRules) DeclineReason(challenge *Challenge) string {
+	if challenge.Variant.Key != "standard" {
+		return DeclineStandard
+	}
+
+	if challenge.TimeControl.Type != "clock" {
+		return DeclineTimeControl
+	}
+
+	if challenge.TimeControl.Limit < rules.MinInitial {
+		return DeclineTooFast
+	}
+
+	if rules.MaxInitial != 0 && challenge.TimeControl.Limit > rules.MaxInitial {
+		return DeclineTooSlow
+	}
+
+	if challenge.Rated && !rules.AcceptRated {
+		return DeclineCasual
+	}
+
+	if !challenge.Rated && !rules.AcceptCasual {
+		return DeclineRated
+	}
+
+	return ""
+}
+
+// Accept or decline a challenge sent to the bot. Challenges sent by the
+// bot itself are ignored.
+func (bot *Bot) handleChallenge(ctx context.Context, challenge *Challenge) {
+	if challenge == nil || challenge.Challenger.ID == bot.account.ID {
+		return
+	}
+
+	reason := bot.Rules.DeclineReason(challenge)
+	if reason == "" && bot.isPlaying() {
+		reason = DeclineLater
+	}
+
+	var err error
+	if reason == "" {
+		bot.logf("accepting challenge %s from %s", challenge.ID, challenge.Challenger.Username)
+		err = bot.Client.AcceptChallenge(ctx, challenge.ID)
+	} else {
+		bot.logf("declining challenge %s from %s: %s", challenge.ID, challenge.Challenger.Username, reason)
+		err = bot.Client.DeclineChallenge(ctx, challenge.ID, reason)
+	}
+
+	if err != nil {
+		bot.logf("challenge %s: %v", challenge.ID, err)
+	}
+}
+
+// Start playing a game in the background, unless we're already
+// playing one.
+func (bot *Bot) handleGameStart(ctx context.Context, gameID string) {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+
+	if bot.playing {
+		bot.logf("ignoring game %s, since a game is already being played", gameID)
+		return
+	}
+
+	bot.playing = true
+	bot.games.Add(1)
+
+	go func() {
+		defer bot.games.Done()
+		defer bot.setPlaying(false)
+
+		bot.logf("starting game %s", gameID)
+		if err := bot.playGame(ctx, gameID); err != nil {
+			bot.logf("game %s: %v", gameID, err)
+		}
+		bot.logf("finished game %s", gameID)
+	}()
+}
+
+// Play the game with the given ID until it's over.
+func (bot *Bot) playGame(ctx context.Context, gameID string) error {
+	bot.search.TT.Clear()
+	bot.search.ClearHistoryTable()
+
+	var color uint8
+	var initialFEN string
+	var playErr error
+
+	err := bot.Client.StreamGame(ctx, gameID, func(event GameEvent) bool {
+		state := event.GameState
+
+		switch event.Type {
+		case "gameFull":
+			color = engine.Black
+			if event.White.ID == bot.account.ID {
+				color = engine.White
+			}
+
+			initialFEN = event.InitialFen
+			if initialFEN == "" || initialFEN == "startpos" {
+				initialFEN = engine.FENStartPosition
+			}
+			state = event.State
+		case "gameState":
+		default:
+			return true
+		}
+
+		if state.Status != StatusStarted {
+			bot.logf("game %s ended: %s", gameID, state.Status)
+			return false
+		}
+
+		if !bot.setupPosition(initialFEN, state.Moves) {
+			bot.logf("game %s: couldn't replay moves %q", gameID, state.Moves)
+			return false
+		}
+
+		if bot.search.Pos.SideToMove != color {
+			return true
+		}
+
+		move := bot.findMove(&state, color)
+		if move == engine.NullMove {
+			return true
+		}
+
+		if playErr = bot.Client.MakeMove(ctx, gameID, move.String()); playErr != nil {
+			return false
+		}
+		return true
+	})
+
+	if playErr != nil {
+		return playErr
+	}
+	return err
+}
+
+// Setup the position of the game from its initial position and the moves,
+// in UCI format, played since. False is returned if one of the moves isn't
+// legal.
+func (bot *Bot) setupPosition(initialFEN, moves string) bool {
+	pos := &bot.search.Pos
+	pos.LoadFEN(initialFEN)
+
+	for _, moveAsString := range strings.Fields(moves) {
+		move, legal := engine.ParseLegalMove(pos, moveAsString)
+		if !legal {
+			return false
+		}
+		pos.MakeMove(move)
+	}
+	return true
+}
+
+// Find the move to play in the current position, using the opening book if
+// it has a move for the position, or searching with the time left on the
+// clock otherwise. If there are no legal moves, a null move is returned.
+func (bot *Bot) findMove(state *GameState, color uint8) engine.Move {
+	pos := &bot.search.Pos
+
+	if entries, ok := bot.Book[engine.GenPolyglotHash(pos)]; ok {
+		// To allow opening variety, randomly select a move from an entry matching
+		// the current position.
+		entry := entries[rand.Intn(len(entries))]
+		if move, legal := engine.ParseLegalMove(pos, entry.Move); legal {
+			return move
+		}
+	}
+
+	if !engine.HasLegalMove(pos) {
+		return engine.NullMove
+	}
+
+	timeLeft, increment := state.WTime, state.WInc
+	if color == engine.Black {
+		timeLeft, increment = state.BTime, state.BInc
+	}
+
+	bot.search.Timer.SetHardTimeForMove(engine.NoValue)
+	bot.search.Timer.TimeLeft = timeLeft
+	bot.search.Timer.Increment = increment
+	bot.search.Timer.MovesToGo = engine.NoValue
+	bot.search.Timer.MoveOverhead = bot.MoveOverhead
+	bot.search.SpecifiedDepth = engine.MaxPly
+	bot.search.SpecifiedNodes = math.MaxUint64
+
+	return bot.search.Search()
+}
+
+func (bot *Bot) isPlaying() bool {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+	return bot.playing
+}
+
+func (bot *Bot) setPlaying(playing bool) {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+	bot.playing = playing
+}
+
+// Log a message about what the bot is doing, if it's been given a logger.
+func (bot *Bot) logf(format string, args ...interface{}) {
+	if bot.Logger != nil {
+		bot.Logger.Printf(format, args...)
+	}
+}
@@ -0,0 +1,295 @@
+package lichess
+
+// bot_test.go tests the bot against a fake Lichess server running in the
+// same process, which sends challenges, starts a game, and plays against
+// the bot by replying with the first legal move it finds.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"romanziske/engine"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeToken      = "lip_test"
+	fakeBotID      = "blunderbot"
+	fakeGameID     = "abcd1234"
+	fakeGameLength = 4
+)
+
+// A struct representing a fake Lichess server.
+type fakeServer struct {
+	t *testing.T
+
+	mutex    sync.Mutex
+	accepted []string
+	declined map[string]string
+	botMoves []string
+	moves    []string
+	pos      engine.Position
+
+	acceptedGame chan struct{}
+	states       chan GameState
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	server := &fakeServer{
+		t:            t,
+		declined:     make(map[string]string),
+		acceptedGame: make(chan struct{}),
+		states:       make(chan GameState, fakeGameLength+1),
+	}
+	server.pos.LoadFEN(engine.FENStartPosition)
+	return server
+}
+
+func (server *fakeServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/account", server.handleAccount)
+	mux.HandleFunc("/api/stream/event", server.handleEventStream)
+	mux.HandleFunc("/api/challenge/", server.handleChallenge)
+	mux.HandleFunc("/api/bot/game/stream/", server.handleGameStream)
+	mux.HandleFunc("/api/bot/game/", server.handleMove)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeToken {
+			http.Error(w, `{"error":"No such token"}`, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (server *fakeServer) handleAccount(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(User{ID: fakeBotID, Username: "BlunderBot", Title: "BOT"})
+}
+
+// Send a few challenges, and once the acceptable one has been accepted,
+// start the game.
+func (server *fakeServer) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	writeLine(w, "")
+	for _, challenge := range []string{
+		fakeChallenge("variant", "chess960", "clock", 300, true),
+		fakeChallenge("correspondence", "standard", "correspondence", 0, true),
+		fakeChallenge("bullet", "standard", "clock", 30, true),
+		fakeChallenge("good", "standard", "clock", 300, true),
+	} {
+		writeLine(w, `{"type":"challenge","challenge":`+challenge+`}`)
+	}
+
+	select {
+	case <-server.acceptedGame:
+	case <-time.After(5 * time.Second):
+		server.t.Error("the acceptable challenge was never accepted")
+		return
+	}
+
+	writeLine(w, `{"type":"gameStart","game":{"id":"`+fakeGameID+`","gameId":"`+fakeGameID+`"}}`)
+}
+
+func (server *fakeServer) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/challenge/"), "/")
+	id, action := parts[0], parts[1]
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	switch action {
+	case "accept":
+		server.accepted = append(server.accepted, id)
+		if id == "good" {
+			close(server.acceptedGame)
+		}
+	case "decline":
+		server.declined[id] = r.FormValue("reason")
+	}
+	writeLine(w, `{"ok":true}`)
+}
+
+// Send the full game, with the bot playing white, and then every update
+// of the game's state until it's over.
+func (server *fakeServer) handleGameStream(w http.ResponseWriter, r *http.Request) {
+	writeLine(w, `{"type":"gameFull","id":"`+fakeGameID+`",`+
+		`"white":{"id":"`+fakeBotID+`","name":"BlunderBot"},`+
+		`"black":{"id":"opponent","name":"Opponent"},"initialFen":"startpos",`+
+		`"state":{"type":"gameState","moves":"","wtime":2000,"btime":2000,"winc":0,"binc":0,"status":"started"}}`)
+
+	for {
+		select {
+		case state := <-server.states:
+			line, _ := json.Marshal(state)
+			writeLine(w, string(line))
+			if state.Status != StatusStarted {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-time.After(10 * time.Second):
+			server.t.Error("timed out waiting for the bot to move")
+			return
+		}
+	}
+}
+
+// Check the move made by the bot is legal, and reply with the first
+// legal move, until enough moves have been played to end the game.
+func (server *fakeServer) handleMove(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/bot/game/"+fakeGameID+"/move/")
+	if r.Method != http.MethodPost || path == r.URL.Path {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.pos.SideToMove != engine.White {
+		server.t.Errorf("bot moved %s when it wasn't its turn", path)
+		http.Error(w, `{"error":"Not your turn"}`, http.StatusBadRequest)
+		return
+	}
+
+	if !server.play(path) {
+		server.t.Errorf("bot played illegal move %s", path)
+		http.Error(w, `{"error":"Illegal move"}`, http.StatusBadRequest)
+		return
+	}
+	server.botMoves = append(server.botMoves, path)
+
+	state := GameState{Type: "gameState", WTime: 2000, BTime: 2000, Status: StatusStarted}
+	if len(server.botMoves) == fakeGameLength {
+		state.Status, state.Winner = "resign", "white"
+	} else {
+		moves := engine.GenMoves(&server.pos)
+		for index := 0; index < int(moves.Count); index++ {
+			reply := moves.Moves[index].String()
+			if server.play(reply) {
+				break
+			}
+		}
+	}
+
+	state.Moves = strings.Join(server.moves, " ")
+	server.states <- state
+	writeLine(w, `{"ok":true}`)
+}
+
+// Play the given move in the game if it's legal.
+func (server *fakeServer) play(moveAsString string) bool {
+	move, legal := engine.ParseLegalMove(&server.pos, moveAsString)
+	if !legal {
+		return false
+	}
+
+	server.pos.MakeMove(move)
+	server.moves = append(server.moves, moveAsString)
+	return true
+}
+
+func fakeChallenge(id, variant, timeControl string, limit int, rated bool) string {
+	return fmt.Sprintf(
+		`{"id":"%s","challenger":{"id":"%s-user","name":"%s"},"rated":%v,`+
+			`"variant":{"key":"%s"},"timeControl":{"type":"%s","limit":%d,"increment":0}}`,
+		id, id, id, rated, variant, timeControl, limit,
+	)
+}
+
+func writeLine(w http.ResponseWriter, line string) {
+	fmt.Fprintln(w, line)
+	w.(http.Flusher).Flush()
+}
+
+func TestBotPlaysGame(t *testing.T) {
+	server := newFakeServer(t)
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	client := NewClient(fakeToken)
+	client.BaseURL = httpServer.URL
+	client.HTTP = httpServer.Client()
+
+	bot := NewBot(client, 1)
+	bot.Rules.MinInitial = 60
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := bot.Run(ctx); err != nil {
+		t.Fatalf("bot stopped with error: %v", err)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if len(server.accepted) != 1 || server.accepted[0] != "good" {
+		t.Errorf("accepted challenges %v, expected [good]", server.accepted)
+	}
+
+	expectedReasons := map[string]string{
+		"variant":        DeclineStandard,
+		"correspondence": DeclineTimeControl,
+		"bullet":         DeclineTooFast,
+	}
+	for id, expected := range expectedReasons {
+		if reason := server.declined[id]; reason != expected {
+			t.Errorf("challenge %s declined with reason %q, expected %q", id, reason, expected)
+		}
+	}
+
+	if len(server.botMoves) != fakeGameLength {
+		t.Errorf("bot played %d moves (%v), expected %d", len(server.botMoves), server.botMoves, fakeGameLength)
+	}
+}
+
+func TestDeclineReason(t *testing.T) {
+	rules := ChallengeRules{MinInitial: 60, MaxInitial: 600, AcceptRated: false, AcceptCasual: true}
+
+	tests := []struct {
+		challenge string
+		reason    string
+	}{
+		{fakeChallenge("a", "standard", "clock", 300, false), ""},
+		{fakeChallenge("b", "standard", "clock", 300, true), DeclineCasual},
+		{fakeChallenge("c", "standard", "clock", 30, false), DeclineTooFast},
+		{fakeChallenge("d", "standard", "clock", 900, false), DeclineTooSlow},
+		{fakeChallenge("e", "standard", "unlimited", 0, false), DeclineTimeControl},
+		{fakeChallenge("f", "atomic", "clock", 300, false), DeclineStandard},
+	}
+
+	for _, test := range tests {
+		var challenge Challenge
+		if err := json.Unmarshal([]byte(test.challenge), &challenge); err != nil {
+			t.Fatal(err)
+		}
+
+		if reason := rules.DeclineReason(&challenge); reason != test.reason {
+			t.Errorf("challenge %s: got decline reason %q, expected %q", challenge.ID, reason, test.reason)
+		}
+	}
+}
+
+func TestClientError(t *testing.T) {
+	server := newFakeServer(t)
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	client := NewClient("wrong token")
+	client.BaseURL = httpServer.URL
+	client.HTTP = httpServer.Client()
+
+	if _, err := client.Account(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected an unauthorized error, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package lichess
+
+// client.go implements a client for the parts of the Lichess bot API needed
+// to play games:
+//
+// https://lichess.org/api#tag/Bot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// The base URL of the Lichess API.
+	DefaultBaseURL = "https://lichess.org"
+)
+
+// An interface for the HTTP client used to send requests, so a client
+// other than http.DefaultClient can be used, e.g. in tests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// A struct representing a Lichess user.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Title    string `json:"title"`
+	Rating   int    `json:"rating"`
+}
+
+// A struct representing a challenge sent to the bot.
+type Challenge struct {
+	ID         string `json:"id"`
+	Challenger User   `json:"challenger"`
+	Rated      bool   `json:"rated"`
+	Speed      string `json:"speed"`
+	Color      string `json:"color"`
+	Variant    struct {
+		Key string `json:"key"`
+	} `json:"variant"`
+	TimeControl struct {
+		Type      string `json:"type"`
+		Limit     int    `json:"limit"`
+		Increment int    `json:"increment"`
+	} `json:"timeControl"`
+}
+
+// A struct representing an event from the bot's event stream. Depending on the
+// type of the event, either the challenge or game field is set.
+type Event struct {
+	Type      string     `json:"type"`
+	Challenge *Challenge `json:"challenge"`
+	Game      *struct {
+		ID     string `json:"id"`
+		GameID string `json:"gameId"`
+	} `json:"game"`
+}
+
+// A struct representing the state of a game: the moves played so far in UCI
+// format, the clocks and increments in milliseconds, and the game status.
+type GameState struct {
+	Type   string `json:"type"`
+	Moves  string `json:"moves"`
+	WTime  int64  `json:"wtime"`
+	BTime  int64  `json:"btime"`
+	WInc   int64  `json:"winc"`
+	BInc   int64  `json:"binc"`
+	Status string `json:"status"`
+	Winner string `json:"winner"`
+}
+
+// A struct representing an event from a game stream. The first event is
+// always a "gameFull" event, containing the players, the initial position
+// and the state of the game. Every event after is a "gameState" or "chatLine"
+// event.
+type GameEvent struct {
+	GameState
+	ID         string    `json:"id"`
+	White      User      `json:"white"`
+	Black      User      `json:"black"`
+	InitialFen string    `json:"initialFen"`
+	State      GameState `json:"state"`
+}
+
+// A struct representing a client of the Lichess API, authenticated with
+// the API token of a bot account.
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    HTTPClient
+}
+
+// Create a client for the Lichess API using the given token.
+func NewClient(token string) *Client {
+	return &Client{BaseURL: DefaultBaseURL, Token: token, HTTP: http.DefaultClient}
+}
+
+// Get the account of the bot.
+func (client *Client) Account(ctx context.Context) (user User, err error) {
+	resp, err := client.request(ctx, http.MethodGet, "/api/account", nil)
+	if err != nil {
+		return user, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	return user, err
+}
+
+// Stream the events of the bot, calling the handler with each event, until
+// the stream ends or the context is cancelled.
+func (client *Client) StreamEvents(ctx context.Context, handler func(event Event)) error {
+	return client.stream(ctx, "/api/stream/event", func(line []byte) error {
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return err
+		}
+		handler(event)
+		return nil
+	})
+}
+
+// Stream the events of the game with the given ID, calling the handler with each
+// event, until the stream ends, the context is cancelled, or the handler returns false.
+func (client *Client) StreamGame(ctx context.Context, gameID string, handler func(event GameEvent) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	return client.stream(ctx, "/api/bot/game/stream/"+gameID, func(line []byte) error {
+		var event GameEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return err
+		}
+
+		if !handler(event) {
+			cancel()
+		}
+		return nil
+	})
+}
+
+// Play the given move, in UCI format, in the game with the given ID.
+func (client *Client) MakeMove(ctx context.Context, gameID, move string) error {
+	return client.post(ctx, fmt.Sprintf("/api/bot/game/%s/move/%s", gameID, move), nil)
+}
+
+// Accept the challenge with the given ID.
+func (client *Client) AcceptChallenge(ctx context.Context, challengeID string) error {
+	return client.post(ctx, fmt.Sprintf("/api/challenge/%s/accept", challengeID), nil)
+}
+
+// Decline the challenge with the given ID, for the given reason.
+func (client *Client) DeclineChallenge(ctx context.Context, challengeID, reason string) error {
+	form := url.Values{"reason": {reason}}
+	return client.post(ctx, fmt.Sprintf("/api/challenge/%s/decline", challengeID), form)
+}
+
+// Send a POST request with the given form, discarding the response body.
+func (client *Client) post(ctx context.Context, path string, form url.Values) error {
+	resp, err := client.request(ctx, http.MethodPost, path, form)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Read a stream of newline delimited JSON, calling the handler with each line.
+// Empty lines are sent by Lichess to keep the connection alive, and are skipped.
+func (client *Client) stream(ctx context.Context, path string, handler func(line []byte) error) error {
+	resp, err := client.request(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for ctx.Err() == nil && scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := handler(line); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// Send an authenticated request to the API, returning an error if the
+// request doesn't succeed.
+func (client *Client) request(ctx context.Context, method, path string, form url.Values) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, client.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+client.Token)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := client.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+// perft.go implements the /chess/perft endpoint, which counts the positions
+// reachable from a position in a number of moves, so move generators can be
+// checked against the engine's. The moves at the root are split between a
+// goroutine for each CPU, which share a hash table, so only one perft is run
+// at a time.
+
+import (
+	"net/http"
+	"romanziske/engine"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The maximum depth of a perft requested through the API, which keeps a
+// request from tying up the server for long.
+const MaxAPIPerftDepth = 5
+
+// The hash table shared by the perfts requested through the API. It's only
+// allocated once the first perft is requested.
+var perftTable *engine.PerftTable
+var perftTableOnce sync.Once
+
+// Holds a value while a perft requested through the API is running, so only
+// one runs at a time, on every CPU.
+var perftRunning = make(chan struct{}, 1)
+
+func setupPerftRoute(r *gin.Engine) {
+	r.GET("/chess/perft", func(c *gin.Context) {
+		var pos engine.Position
+		if !loadFENQuery(c, &pos) {
+			return
+		}
+
+		depth, err := strconv.Atoi(c.Query("depth"))
+		if err != nil || depth < 1 || depth > MaxAPIPerftDepth {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "depth parameter must be an integer between 1 and " + strconv.Itoa(MaxAPIPerftDepth),
+			})
+			return
+		}
+
+		select {
+		case perftRunning <- struct{}{}:
+			defer func() { <-perftRunning }()
+		default:
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "another perft is already running",
+			})
+			return
+		}
+
+		perftTableOnce.Do(func() {
+			perftTable = engine.NewPerftTable(engine.DefaultPerftTableSize)
+		})
+
+		startTime := time.Now()
+		results, err := engine.ParallelDividePerft(&pos, uint8(depth), perftTable, 0)
+		elapsed := time.Since(startTime)
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "perft failed: " + err.Error(),
+			})
+			return
+		}
+
+		var nodes uint64
+		divide := map[string]uint64{}
+		for _, result := range results {
+			nodes += result.Nodes
+			divide[result.Move.String()] = result.Nodes
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"fen":    pos.GenFEN(),
+			"depth":  depth,
+			"nodes":  nodes,
+			"divide": divide,
+			"timeMs": elapsed.Milliseconds(),
+		})
+	})
+}
@@ -0,0 +1,217 @@
+package main
+
+// positions.go implements the position inspection endpoints of the HTTP API,
+// so frontends can list the legal moves of a position, play moves, and find
+// out if the game is over, without needing a move generator of their own. A
+// breakdown of how the engine evaluates a position can also be requested.
+
+import (
+	"net/http"
+	"romanziske/engine"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Load the position given by the fen parameter of a request, responding
+// with an error if it's missing, invalid, or not a legal position.
+func loadFENQuery(c *gin.Context, pos *engine.Position) bool {
+	fenStr, ok := c.GetQuery("fen")
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "fen parameter is missing",
+		})
+		return false
+	}
+
+	if !validFEN(fenStr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "fen parameter is not a valid FEN string",
+		})
+		return false
+	}
+
+	pos.LoadFEN(fenStr)
+	if err := pos.ValidateLegal(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "fen parameter is not a legal position: " + err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// Get the JSON representation of each legal move in the position.
+func legalMovesToJSON(pos *engine.Position) []gin.H {
+	legalMoves := []gin.H{}
+
+	moves := engine.GenLegalMoves(pos)
+	for index := 0; index < int(moves.Count); index++ {
+		move := moves.Moves[index]
+		moved := pos.Squares[move.FromSq()]
+		captured := pos.Squares[move.ToSq()]
+
+		coords := move.String()
+		var promotion interface{}
+		if move.MoveType() == engine.Promotion {
+			promotion = coords[4:]
+		}
+
+		san := engine.ConvertMoveToSAN(pos, move)
+		check := strings.HasSuffix(san, "+") || strings.HasSuffix(san, "#")
+		legalMoves = append(legalMoves, gin.H{
+			"uci":       coords,
+			"san":       san,
+			"from":      coords[0:2],
+			"to":        coords[2:4],
+			"piece":     string("pnbrqk"[moved.Type]),
+			"capture":   move.MoveType() == engine.Attack || captured.Type != engine.NoType,
+			"check":     check,
+			"checkmate": strings.HasSuffix(san, "#"),
+			"promotion": promotion,
+			"castle":    move.MoveType() == engine.Castle,
+		})
+	}
+
+	return legalMoves
+}
+
+// Get the JSON representation of the status of a game that has reached the
+// given position, by playing the game's moves in it.
+func statusToJSON(pos *engine.Position) gin.H {
+	state := pos.GameState()
+	status := gameStateStatuses[state]
+	repetitions := pos.RepetitionCount()
+	return gin.H{
+		"fen":                  pos.GenFEN(),
+		"sideToMove":           engine.ColorName(pos.SideToMove),
+		"inCheck":              pos.InCheck(),
+		"checkmate":            status == StatusCheckmate,
+		"stalemate":            status == StatusStalemate,
+		"insufficientMaterial": pos.InsufficientMaterial(),
+		"fiftyMove":            pos.Rule50 >= engine.FiftyMoveRulePlies,
+		"seventyFiveMove":      pos.Rule50 >= engine.SeventyFiveMoveRulePlies,
+		"repetition":           repetitions >= 3,
+		"fivefoldRepetition":   repetitions >= 5,
+		"repetitions":          repetitions,
+		"drawClaimable":        state.IsClaimable(),
+		"status":               status,
+		"result":               gameResult(pos, state),
+	}
+}
+
+// Get the JSON representation of a trace of the evaluation: the middle-game
+// and end-game scores of each term for each side, the king safety points of
+// each side, and how the scores are blended into the final score.
+func evalTraceToJSON(trace *engine.EvalTrace) gin.H {
+	terms := []gin.H{}
+	for term := uint8(0); term < engine.NumEvalTerms; term++ {
+		mg, eg := trace.MGScores[term], trace.EGScores[term]
+		terms = append(terms, gin.H{
+			"name":  engine.EvalTermNames[term],
+			"white": gin.H{"mg": mg[engine.White], "eg": eg[engine.White]},
+			"black": gin.H{"mg": mg[engine.Black], "eg": eg[engine.Black]},
+			"total": gin.H{"mg": mg[engine.White] - mg[engine.Black], "eg": eg[engine.White] - eg[engine.Black]},
+		})
+	}
+
+	kingSafety := gin.H{}
+	for _, color := range []uint8{engine.White, engine.Black} {
+		points := trace.KingSafety[color]
+		kingSafety[engine.ColorName(color)] = gin.H{
+			"attackers":          points.Attackers,
+			"squarePoints":       points.SquarePoints,
+			"zoneAttackPoints":   points.ZoneAttackPoints,
+			"semiOpenFilePoints": points.SemiOpenFilePoints,
+			"points":             points.Points,
+		}
+	}
+
+	return gin.H{
+		"terms":        terms,
+		"kingSafety":   kingSafety,
+		"mg":           trace.MGScore,
+		"eg":           trace.EGScore,
+		"phase":        trace.Phase,
+		"endgame":      trace.Endgame,
+		"exactEndgame": trace.ExactEndgame,
+		"scaleFactor":  trace.ScaleFactor,
+		"score":        trace.Score,
+		"whiteScore":   trace.WhiteScore(),
+	}
+}
+
+func setupPositionRoutes(r *gin.Engine) {
+	r.GET("/chess/moves", func(c *gin.Context) {
+		var pos engine.Position
+		if !loadFENQuery(c, &pos) {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"fen":   pos.GenFEN(),
+			"moves": legalMovesToJSON(&pos),
+		})
+	})
+
+	r.GET("/chess/status", func(c *gin.Context) {
+		var pos engine.Position
+		if !loadFENQuery(c, &pos) {
+			return
+		}
+
+		c.JSON(http.StatusOK, statusToJSON(&pos))
+	})
+
+	r.GET("/chess/eval-trace", func(c *gin.Context) {
+		var pos engine.Position
+		if !loadFENQuery(c, &pos) {
+			return
+		}
+
+		trace := engine.TraceEvaluation(&pos)
+		response := evalTraceToJSON(&trace)
+		response["fen"] = pos.GenFEN()
+		response["sideToMove"] = engine.ColorName(pos.SideToMove)
+		c.JSON(http.StatusOK, response)
+	})
+
+	r.GET("/chess/apply", func(c *gin.Context) {
+		var pos engine.Position
+		if !loadFENQuery(c, &pos) {
+			return
+		}
+
+		// The moves can be separated by either commas or spaces.
+		movesStr := strings.Replace(c.Query("moves"), ",", " ", -1)
+		san := []string{}
+
+		for index, moveAsString := range strings.Fields(movesStr) {
+			// A draw which has to be claimed doesn't stop the moves
+			// from being played.
+			if state := pos.GameState(); state.IsOver() && !state.IsClaimable() {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "game is already over (" + gameStateStatuses[state] + ") before move " + moveAsString,
+					"index": index,
+				})
+				return
+			}
+
+			move, legal := engine.ParseLegalMove(&pos, moveAsString)
+			if !legal {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "illegal move " + moveAsString,
+					"index": index,
+				})
+				return
+			}
+
+			san = append(san, engine.ConvertMoveToSAN(&pos, move))
+			pos.MakeMove(move)
+		}
+
+		response := statusToJSON(&pos)
+		response["san"] = san
+		c.JSON(http.StatusOK, response)
+	})
+}
@@ -0,0 +1,97 @@
+package render
+
+// pieces.go defines the shapes the pieces are drawn with. Each piece is made
+// up of a few polygons, given in a 100 by 100 unit square with its origin in
+// the top left corner, which are filled and outlined in turn, so later shapes
+// are drawn over the earlier ones.
+
+import "math"
+
+// The shapes making up each type of piece, indexed by the piece type.
+var pieceShapes [6][][]point
+
+// Get a polygon approximating the circle with the given center and radius.
+func circle(x, y, radius float64) []point {
+	const segments = 24
+	points := make([]point, segments)
+	for index := range points {
+		angle := 2 * math.Pi * float64(index) / segments
+		points[index] = point{x + radius*math.Cos(angle), y + radius*math.Sin(angle)}
+	}
+	return points
+}
+
+// Get the distance between two points.
+func distance(p1, p2 point) float64 {
+	return math.Hypot(p2.X-p1.X, p2.Y-p1.Y)
+}
+
+func init() {
+	// The base every piece stands on.
+	base := []point{{22, 78}, {78, 78}, {80, 90}, {20, 90}}
+
+	pieceShapes = [6][][]point{
+		// Pawn
+		{
+			{{36, 78}, {40, 56}, {34, 50}, {66, 50}, {60, 56}, {64, 78}},
+			circle(50, 38, 13),
+			base,
+		},
+
+		// Knight
+		{
+			{
+				{30, 78}, {34, 64}, {46, 52}, {44, 46}, {34, 52}, {26, 54},
+				{20, 48}, {24, 38}, {38, 22}, {42, 12}, {48, 18}, {58, 18},
+				{68, 28}, {74, 46}, {74, 78},
+			},
+			circle(35, 34, 2.5),
+			base,
+		},
+
+		// Bishop
+		{
+			{{36, 78}, {40, 58}, {32, 46}, {50, 20}, {68, 46}, {60, 58}, {64, 78}},
+			circle(50, 15, 6),
+			{{48, 32}, {52, 32}, {52, 48}, {48, 48}},
+			base,
+		},
+
+		// Rook
+		{
+			{{32, 78}, {34, 40}, {66, 40}, {68, 78}},
+			{
+				{24, 16}, {34, 16}, {34, 24}, {44, 24}, {44, 16}, {56, 16},
+				{56, 24}, {66, 24}, {66, 16}, {76, 16}, {76, 40}, {24, 40},
+			},
+			base,
+		},
+
+		// Queen
+		{
+			circle(20, 28, 5),
+			circle(35, 20, 5),
+			circle(50, 16, 5),
+			circle(65, 20, 5),
+			circle(80, 28, 5),
+			{
+				{20, 28}, {34, 56}, {35, 20}, {45, 52}, {50, 16}, {55, 52},
+				{65, 20}, {66, 56}, {80, 28}, {70, 78}, {30, 78},
+			},
+			base,
+		},
+
+		// King
+		{
+			{
+				{46, 8}, {54, 8}, {54, 16}, {62, 16}, {62, 24}, {54, 24},
+				{54, 38}, {46, 38}, {46, 24}, {38, 24}, {38, 16}, {46, 16},
+			},
+			{
+				{30, 78}, {22, 50}, {28, 40}, {40, 38}, {50, 46}, {60, 38},
+				{72, 40}, {78, 50}, {70, 78},
+			},
+			base,
+		},
+	}
+}
@@ -0,0 +1,205 @@
+package render
+
+// png.go implements a canvas which rasterizes what's drawn on it into an
+// image, using only the standard image packages. Polygons are filled with
+// anti-aliasing by sampling each row of pixels at several heights, and
+// measuring how much of each pixel the spans inside the polygon cover. Text
+// is drawn with a small bitmap font, which only has the characters needed
+// for the coordinates.
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"romanziske/engine"
+	"sort"
+)
+
+// The number of heights each row of pixels is sampled at when filling a
+// polygon.
+const samplesPerRow = 4
+
+// The glyphs of the bitmap font, three pixels wide and five pixels high. Each
+// row of a glyph is given by the three lowest bits of a byte, with the
+// leftmost pixel in the highest bit.
+var glyphs = map[rune][5]uint8{
+	'a': {0, 6, 1, 7, 7},
+	'b': {4, 4, 6, 5, 6},
+	'c': {0, 3, 4, 4, 3},
+	'd': {1, 1, 3, 5, 3},
+	'e': {2, 5, 7, 4, 3},
+	'f': {1, 2, 7, 2, 2},
+	'g': {3, 5, 3, 1, 6},
+	'h': {4, 4, 6, 5, 5},
+	'1': {2, 6, 2, 2, 7},
+	'2': {6, 1, 2, 4, 7},
+	'3': {6, 1, 2, 1, 6},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 6, 1, 6},
+	'6': {3, 4, 6, 5, 2},
+	'7': {7, 1, 2, 2, 2},
+	'8': {2, 5, 2, 5, 2},
+}
+
+// A canvas drawing onto an image.
+type imageCanvas struct {
+	img *image.RGBA
+}
+
+func newImageCanvas(size int) *imageCanvas {
+	return &imageCanvas{img: image.NewRGBA(image.Rect(0, 0, size, size))}
+}
+
+// Blend the given color over the pixel at the given point, with the given
+// coverage of the pixel, between zero and one.
+func (c *imageCanvas) blend(x, y int, fill color.RGBA, coverage float64) {
+	if coverage <= 0 || !(image.Point{x, y}.In(c.img.Rect)) {
+		return
+	}
+	if coverage > 1 {
+		coverage = 1
+	}
+
+	alpha := coverage * float64(fill.A) / 255
+	offset := c.img.PixOffset(x, y)
+	pix := c.img.Pix[offset : offset+4 : offset+4]
+	pix[0] = uint8(float64(fill.R)*alpha + float64(pix[0])*(1-alpha) + 0.5)
+	pix[1] = uint8(float64(fill.G)*alpha + float64(pix[1])*(1-alpha) + 0.5)
+	pix[2] = uint8(float64(fill.B)*alpha + float64(pix[2])*(1-alpha) + 0.5)
+	pix[3] = uint8(255*alpha + float64(pix[3])*(1-alpha) + 0.5)
+}
+
+func (c *imageCanvas) fillRect(x, y, width, height float64, fill color.RGBA) {
+	c.fillPolygon([]point{{x, y}, {x + width, y}, {x + width, y + height}, {x, y + height}}, fill, fill, 0)
+}
+
+func (c *imageCanvas) fillPolygon(points []point, fill color.RGBA, stroke color.RGBA, strokeWidth float64) {
+	c.fillShape(points, fill)
+	if strokeWidth == 0 {
+		return
+	}
+
+	// The outline is drawn as a quadrilateral along each edge, with a
+	// circle at each corner joining the edges.
+	half := strokeWidth / 2
+	for index, p1 := range points {
+		p2 := points[(index+1)%len(points)]
+		length := distance(p1, p2)
+		if length == 0 {
+			continue
+		}
+
+		nx, ny := -(p2.Y-p1.Y)/length*half, (p2.X-p1.X)/length*half
+		c.fillShape([]point{
+			{p1.X + nx, p1.Y + ny}, {p2.X + nx, p2.Y + ny},
+			{p2.X - nx, p2.Y - ny}, {p1.X - nx, p1.Y - ny},
+		}, stroke)
+		c.fillShape(circle(p1.X, p1.Y, half), stroke)
+	}
+}
+
+// Fill the polygon with the given color, using the even-odd rule. Only the
+// pixels within the polygon's bounding box are sampled and blended.
+func (c *imageCanvas) fillShape(points []point, fill color.RGBA) {
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+
+	bounds := c.img.Rect
+	firstRow := int(math.Max(math.Floor(minY), float64(bounds.Min.Y)))
+	lastRow := int(math.Min(math.Ceil(maxY), float64(bounds.Max.Y-1)))
+	firstCol := int(math.Max(math.Floor(minX), float64(bounds.Min.X)))
+	lastCol := int(math.Min(math.Ceil(maxX), float64(bounds.Max.X-1)))
+	if firstRow > lastRow || firstCol > lastCol {
+		return
+	}
+
+	coverage := make([]float64, lastCol-firstCol+1)
+	var crossings []float64
+	for row := firstRow; row <= lastRow; row++ {
+		for x := range coverage {
+			coverage[x] = 0
+		}
+
+		for sample := 0; sample < samplesPerRow; sample++ {
+			y := float64(row) + (float64(sample)+0.5)/samplesPerRow
+
+			// Find where the edges cross the sample's height, and fill
+			// the spans between each pair of crossings.
+			crossings = crossings[:0]
+			for index, p1 := range points {
+				p2 := points[(index+1)%len(points)]
+				if (p1.Y <= y) == (p2.Y <= y) {
+					continue
+				}
+				crossings = append(crossings, p1.X+(y-p1.Y)*(p2.X-p1.X)/(p2.Y-p1.Y))
+			}
+			sort.Float64s(crossings)
+
+			for index := 0; index+1 < len(crossings); index += 2 {
+				addSpan(coverage, crossings[index]-float64(firstCol), crossings[index+1]-float64(firstCol))
+			}
+		}
+
+		for x, covered := range coverage {
+			c.blend(firstCol+x, row, fill, covered/samplesPerRow)
+		}
+	}
+}
+
+// Add how much of each pixel the span from x1 to x2 covers to the coverage
+// of the pixels. Only the pixels at either end of the span can be partly
+// covered.
+func addSpan(coverage []float64, x1, x2 float64) {
+	x1 = math.Max(x1, 0)
+	x2 = math.Min(x2, float64(len(coverage)))
+	if x1 >= x2 {
+		return
+	}
+
+	first, last := int(x1), int(x2)
+	if first == last {
+		coverage[first] += x2 - x1
+		return
+	}
+
+	coverage[first] += float64(first+1) - x1
+	for x := first + 1; x < last; x++ {
+		coverage[x]++
+	}
+	if last < len(coverage) {
+		coverage[last] += x2 - float64(last)
+	}
+}
+
+func (c *imageCanvas) text(x, y, size float64, str string, fill color.RGBA) {
+	pixel := size / 5
+	for _, char := range str {
+		glyph := glyphs[char]
+		for row, bits := range glyph {
+			for col := 0; col < 3; col++ {
+				if bits&(4>>uint(col)) != 0 {
+					c.fillRect(x+float64(col)*pixel, y+float64(row)*pixel, pixel, pixel, fill)
+				}
+			}
+		}
+		x += 4 * pixel
+	}
+}
+
+// Render the position as an image.
+func Image(pos *engine.Position, opts Options) *image.RGBA {
+	c := newImageCanvas(opts.size())
+	drawBoard(c, pos, opts)
+	return c.img
+}
+
+// Render the position as a PNG image.
+func PNG(w io.Writer, pos *engine.Position, opts Options) error {
+	return png.Encode(w, Image(pos, opts))
+}
@@ -0,0 +1,273 @@
+package render
+
+// render.go implements the drawing of a position as a board diagram, with the
+// coordinates along its edges, the last move and a king in check highlighted,
+// and arrows for the moves of a principal variation. The board is drawn on a
+// canvas, which is either turned into an SVG document, or rasterized into an
+// image that can be encoded as a PNG.
+
+import (
+	"image/color"
+	"io"
+	"romanziske/engine"
+)
+
+const (
+	// The default and the smallest and largest sizes, in pixels, of a
+	// rendered board.
+	DefaultSize = 400
+	MinSize     = 80
+	MaxSize     = 1000
+
+	// The most arrows drawn on a board.
+	MaxArrows = 8
+)
+
+// The colors a board is drawn with.
+var (
+	LightSquareColor = color.RGBA{240, 217, 181, 255}
+	DarkSquareColor  = color.RGBA{181, 136, 99, 255}
+	LastMoveColor    = color.RGBA{155, 199, 0, 105}
+	CheckColor       = color.RGBA{230, 20, 20, 170}
+
+	WhitePieceColor = color.RGBA{255, 255, 255, 255}
+	BlackPieceColor = color.RGBA{34, 34, 34, 255}
+	OutlineColor    = color.RGBA{0, 0, 0, 255}
+
+	// The colors of the arrows for the moves of the side to move, and for
+	// the moves of the other side.
+	OwnArrowColor   = color.RGBA{21, 120, 27, 255}
+	EnemyArrowColor = color.RGBA{0, 48, 136, 255}
+)
+
+// The options for rendering a board.
+type Options struct {
+	// The width and height of the board, in pixels. Zero means the
+	// default size.
+	Size int
+
+	// Whether the board is seen from Black's side, and whether the
+	// coordinates are drawn along its edges.
+	Flipped     bool
+	Coordinates bool
+
+	// The move which led to the position, which is highlighted if it's
+	// not a null move.
+	LastMove engine.Move
+
+	// The moves of a principal variation starting in the position, which
+	// are drawn as arrows that fade as the line goes on.
+	Arrows []engine.Move
+}
+
+// The default options for rendering a board: the default size, seen from
+// White's side, with coordinates.
+func DefaultOptions() Options {
+	return Options{Size: DefaultSize, Coordinates: true}
+}
+
+// A point on a canvas.
+type point struct {
+	X, Y float64
+}
+
+// A surface a board can be drawn on.
+type canvas interface {
+	// Fill a rectangle with the given color.
+	fillRect(x, y, width, height float64, fill color.RGBA)
+
+	// Fill a polygon with the given color, and then stroke its outline with
+	// the given width, if the width isn't zero.
+	fillPolygon(points []point, fill color.RGBA, stroke color.RGBA, strokeWidth float64)
+
+	// Draw text of the given height, with its top left corner at the given
+	// point. Only the characters of board coordinates need to be supported.
+	text(x, y, size float64, str string, fill color.RGBA)
+}
+
+// Get the size of the board to draw, given the options.
+func (opts Options) size() int {
+	switch {
+	case opts.Size == 0:
+		return DefaultSize
+	case opts.Size < MinSize:
+		return MinSize
+	case opts.Size > MaxSize:
+		return MaxSize
+	}
+	return opts.Size
+}
+
+// A board being drawn on a canvas.
+type board struct {
+	canvas     canvas
+	opts       Options
+	squareSize float64
+}
+
+// Get the column and row of the given square, counting from the top left
+// corner of the board as it's drawn.
+func (b *board) squareCell(sq uint8) (col, row float64) {
+	file, rank := engine.FileOf(sq), engine.RankOf(sq)
+	if b.opts.Flipped {
+		return float64(7 - file), float64(rank)
+	}
+	return float64(file), float64(7 - rank)
+}
+
+// Get the top left corner of the given square.
+func (b *board) squareCorner(sq uint8) (x, y float64) {
+	col, row := b.squareCell(sq)
+	return col * b.squareSize, row * b.squareSize
+}
+
+// Get the center of the given square.
+func (b *board) squareCenter(sq uint8) point {
+	x, y := b.squareCorner(sq)
+	return point{x + b.squareSize/2, y + b.squareSize/2}
+}
+
+// Highlight the given square with the given color.
+func (b *board) highlight(sq uint8, fill color.RGBA) {
+	x, y := b.squareCorner(sq)
+	b.canvas.fillRect(x, y, b.squareSize, b.squareSize, fill)
+}
+
+// Draw the position on the canvas: first the squares and their highlights,
+// then the coordinates and the pieces, and finally the arrows over them.
+func drawBoard(c canvas, pos *engine.Position, opts Options) {
+	b := board{canvas: c, opts: opts, squareSize: float64(opts.size()) / 8}
+
+	for sq := uint8(0); sq < 64; sq++ {
+		fill := LightSquareColor
+		if (engine.FileOf(sq)+engine.RankOf(sq))%2 == 0 {
+			fill = DarkSquareColor
+		}
+		b.highlight(sq, fill)
+	}
+
+	if opts.LastMove != engine.NullMove {
+		b.highlight(opts.LastMove.FromSq(), LastMoveColor)
+		b.highlight(opts.LastMove.ToSq(), LastMoveColor)
+	}
+
+	if pos.InCheck() {
+		b.highlight(pos.PieceBB[pos.SideToMove][engine.King].Msb(), CheckColor)
+	}
+
+	if opts.Coordinates {
+		b.drawCoordinates()
+	}
+
+	for sq := uint8(0); sq < 64; sq++ {
+		if piece := pos.Squares[sq]; piece.Type != engine.NoType {
+			b.drawPiece(sq, piece)
+		}
+	}
+
+	arrows := opts.Arrows
+	if len(arrows) > MaxArrows {
+		arrows = arrows[:MaxArrows]
+	}
+	for index, move := range arrows {
+		fill := OwnArrowColor
+		if index%2 == 1 {
+			fill = EnemyArrowColor
+		}
+		fill.A = uint8(210 - 150*index/MaxArrows)
+		b.drawArrow(move, fill)
+	}
+}
+
+// Draw the files along the bottom edge of the board, and the ranks along its
+// left edge, in the color of the other kind of square so they stand out.
+func (b *board) drawCoordinates() {
+	size := b.squareSize * 0.2
+	for index := uint8(0); index < 8; index++ {
+		fileSq, rankSq := index, index*8
+		if b.opts.Flipped {
+			fileSq, rankSq = 56+index, index*8+7
+		}
+
+		x, y := b.squareCorner(fileSq)
+		b.canvas.text(
+			x+b.squareSize-size*0.8, y+b.squareSize-size*1.15, size,
+			string(rune('a'+index)), b.coordinateColor(fileSq),
+		)
+
+		x, y = b.squareCorner(rankSq)
+		b.canvas.text(
+			x+size*0.25, y+size*0.2, size,
+			string(rune('1'+index)), b.coordinateColor(rankSq),
+		)
+	}
+}
+
+// Get the color of the coordinates drawn on the given square.
+func (b *board) coordinateColor(sq uint8) color.RGBA {
+	if (engine.FileOf(sq)+engine.RankOf(sq))%2 == 0 {
+		return LightSquareColor
+	}
+	return DarkSquareColor
+}
+
+// Draw the given piece on the given square, scaling the shapes making up the
+// piece from the unit square they're defined in.
+func (b *board) drawPiece(sq uint8, piece engine.Piece) {
+	x, y := b.squareCorner(sq)
+	fill := WhitePieceColor
+	if piece.Color == engine.Black {
+		fill = BlackPieceColor
+	}
+
+	for _, shape := range pieceShapes[piece.Type] {
+		points := make([]point, len(shape))
+		for index, p := range shape {
+			points[index] = point{x + p.X*b.squareSize/100, y + p.Y*b.squareSize/100}
+		}
+		b.canvas.fillPolygon(points, fill, OutlineColor, b.squareSize*0.03)
+	}
+}
+
+// Draw an arrow from the center of the move's from square to the center of
+// its to square, as a single polygon so a translucent arrow is drawn evenly.
+func (b *board) drawArrow(move engine.Move, fill color.RGBA) {
+	from, to := b.squareCenter(move.FromSq()), b.squareCenter(move.ToSq())
+	length := distance(from, to)
+	if length == 0 {
+		return
+	}
+
+	// The direction of the arrow, and the direction across it.
+	dx, dy := (to.X-from.X)/length, (to.Y-from.Y)/length
+	nx, ny := -dy, dx
+
+	shaft := b.squareSize * 0.09
+	headLength := b.squareSize * 0.4
+	headWidth := b.squareSize * 0.24
+	if headLength > length {
+		headLength = length
+	}
+
+	// Stop the arrow a little before the center of the square, so the
+	// piece there can still be seen.
+	tip := point{to.X - dx*b.squareSize*0.1, to.Y - dy*b.squareSize*0.1}
+	base := point{tip.X - dx*headLength, tip.Y - dy*headLength}
+
+	b.canvas.fillPolygon([]point{
+		{from.X + nx*shaft, from.Y + ny*shaft},
+		{base.X + nx*shaft, base.Y + ny*shaft},
+		{base.X + nx*headWidth, base.Y + ny*headWidth},
+		tip,
+		{base.X - nx*headWidth, base.Y - ny*headWidth},
+		{base.X - nx*shaft, base.Y - ny*shaft},
+		{from.X - nx*shaft, from.Y - ny*shaft},
+	}, fill, fill, 0)
+}
+
+// Render the position as an SVG document.
+func SVG(w io.Writer, pos *engine.Position, opts Options) error {
+	c := newSVGCanvas(opts.size())
+	drawBoard(c, pos, opts)
+	return c.writeTo(w)
+}
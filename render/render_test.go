@@ -0,0 +1,152 @@
+package render
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"romanziske/engine"
+	"strings"
+	"testing"
+)
+
+// The position after 1. e4 e5 2. Qh5 Nc6 3. Bc4 Nf6 4. Qxf7#, with Black in
+// check.
+const scholarsMate = "r1bqkb1r/pppp1Qpp/2n2n2/4p3/2B1P3/8/PPPP1PPP/RNB1K1NR b KQkq - 0 4"
+
+func loadPosition(fen string) *engine.Position {
+	var pos engine.Position
+	pos.LoadFEN(fen)
+	return &pos
+}
+
+// Get the color of the pixel at the given point of the image.
+func pixelAt(t *testing.T, data []byte, x, y int) color.RGBA {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode PNG: %v", err)
+	}
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
+
+func TestSVG(t *testing.T) {
+	pos := loadPosition(scholarsMate)
+	opts := DefaultOptions()
+	opts.LastMove = engine.NewMove(engine.CoordinateToPos("h5"), engine.CoordinateToPos("f7"), engine.Attack, engine.NoFlag)
+
+	var buffer bytes.Buffer
+	if err := SVG(&buffer, pos, opts); err != nil {
+		t.Fatalf("Failed to render SVG: %v", err)
+	}
+	svg := buffer.String()
+
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="400"`) {
+		t.Errorf("SVG doesn't start with the expected root element: %.80s", svg)
+	}
+
+	// 64 squares, two last move highlights and the check highlight.
+	if count := strings.Count(svg, "<rect "); count != 67 {
+		t.Errorf("SVG has %d rectangles instead of 67", count)
+	}
+
+	// The check highlight is drawn on e8, in the top row.
+	if !strings.Contains(svg, `<rect x="200.00" y="0.00" width="50.00" height="50.00" fill="#e61414"`) {
+		t.Errorf("SVG doesn't highlight the king in check on e8")
+	}
+
+	if count := strings.Count(svg, "<text "); count != 16 {
+		t.Errorf("SVG has %d coordinates instead of 16", count)
+	}
+}
+
+func TestSVGFlipped(t *testing.T) {
+	pos := loadPosition(scholarsMate)
+	opts := Options{Size: 800, Flipped: true}
+
+	var buffer bytes.Buffer
+	if err := SVG(&buffer, pos, opts); err != nil {
+		t.Fatalf("Failed to render SVG: %v", err)
+	}
+	svg := buffer.String()
+
+	// Seen from Black's side, e8 is in the bottom row, fourth from the left.
+	if !strings.Contains(svg, `<rect x="300.00" y="700.00" width="100.00" height="100.00" fill="#e61414"`) {
+		t.Errorf("Flipped SVG doesn't highlight the king in check on e8")
+	}
+	if strings.Contains(svg, "<text ") {
+		t.Errorf("SVG has coordinates when they're disabled")
+	}
+}
+
+func TestArrows(t *testing.T) {
+	pos := loadPosition(engine.FENStartPosition)
+	opts := DefaultOptions()
+	for _, moveAsString := range []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1b5", "a7a6", "b5a4", "g8f6", "e1g1", "f8e7"} {
+		opts.Arrows = append(opts.Arrows, engine.MoveFromCoord(pos, moveAsString))
+	}
+
+	var buffer bytes.Buffer
+	if err := SVG(&buffer, pos, opts); err != nil {
+		t.Fatalf("Failed to render SVG: %v", err)
+	}
+
+	// Arrows are the only polygons which aren't outlined, and only the
+	// first MaxArrows moves are drawn.
+	svg := buffer.String()
+	if count := strings.Count(svg, "<polygon ") - strings.Count(svg, "stroke-width"); count != MaxArrows {
+		t.Errorf("SVG has %d arrows instead of %d", count, MaxArrows)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	pos := loadPosition(scholarsMate)
+	opts := Options{Size: 160, Coordinates: true}
+
+	var buffer bytes.Buffer
+	if err := PNG(&buffer, pos, opts); err != nil {
+		t.Fatalf("Failed to render PNG: %v", err)
+	}
+
+	config, err := png.DecodeConfig(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode PNG: %v", err)
+	}
+	if config.Width != 160 || config.Height != 160 {
+		t.Errorf("PNG is %dx%d instead of 160x160", config.Width, config.Height)
+	}
+
+	// The corners of empty squares, away from the coordinates, show the
+	// color of the square: d4 is dark, e4 would be light but has a pawn in
+	// the middle, and d5 is light.
+	tests := []struct {
+		x, y     int
+		expected color.RGBA
+	}{
+		{61, 81, DarkSquareColor},
+		{61, 61, LightSquareColor},
+		{81, 81, LightSquareColor},
+	}
+	for _, test := range tests {
+		if pixel := pixelAt(t, buffer.Bytes(), test.x, test.y); pixel != test.expected {
+			t.Errorf("Pixel at (%d, %d) is %v instead of %v", test.x, test.y, pixel, test.expected)
+		}
+	}
+
+	// The king in check is highlighted, and drawn in black over the
+	// highlight.
+	if pixel := pixelAt(t, buffer.Bytes(), 81, 1); pixel.R < 200 || pixel.G > 100 {
+		t.Errorf("Corner of e8 is %v instead of highlighted in red", pixel)
+	}
+	if pixel := pixelAt(t, buffer.Bytes(), 90, 13); pixel != BlackPieceColor {
+		t.Errorf("Center of e8 is %v instead of the black king", pixel)
+	}
+}
+
+func TestSizeClamped(t *testing.T) {
+	pos := loadPosition(engine.FENStartPosition)
+	for _, test := range []struct{ size, expected int }{{0, DefaultSize}, {10, MinSize}, {5000, MaxSize}} {
+		img := Image(pos, Options{Size: test.size})
+		if img.Bounds().Dx() != test.expected || img.Bounds().Dy() != test.expected {
+			t.Errorf("Board of size %d drawn as %v instead of %d pixels wide", test.size, img.Bounds(), test.expected)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package render
+
+// svg.go implements a canvas which collects what's drawn on it as the
+// elements of an SVG document.
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// A canvas building an SVG document.
+type svgCanvas struct {
+	size     int
+	elements strings.Builder
+}
+
+func newSVGCanvas(size int) *svgCanvas {
+	return &svgCanvas{size: size}
+}
+
+// Get the attributes setting the given fill color, and its opacity if it's
+// translucent.
+func svgFill(attribute string, fill color.RGBA) string {
+	attrs := fmt.Sprintf(`%s="#%02x%02x%02x"`, attribute, fill.R, fill.G, fill.B)
+	if fill.A != 255 {
+		attrs += fmt.Sprintf(` %s-opacity="%.3g"`, attribute, float64(fill.A)/255)
+	}
+	return attrs
+}
+
+func (c *svgCanvas) fillRect(x, y, width, height float64, fill color.RGBA) {
+	fmt.Fprintf(
+		&c.elements, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" %s/>`+"\n",
+		x, y, width, height, svgFill("fill", fill),
+	)
+}
+
+func (c *svgCanvas) fillPolygon(points []point, fill color.RGBA, stroke color.RGBA, strokeWidth float64) {
+	coords := make([]string, len(points))
+	for index, p := range points {
+		coords[index] = fmt.Sprintf("%.2f,%.2f", p.X, p.Y)
+	}
+
+	fmt.Fprintf(&c.elements, `<polygon points="%s" %s`, strings.Join(coords, " "), svgFill("fill", fill))
+	if strokeWidth != 0 {
+		fmt.Fprintf(
+			&c.elements, ` %s stroke-width="%.2f" stroke-linejoin="round"`,
+			svgFill("stroke", stroke), strokeWidth,
+		)
+	}
+	c.elements.WriteString("/>\n")
+}
+
+func (c *svgCanvas) text(x, y, size float64, str string, fill color.RGBA) {
+	// The text is positioned by its baseline, which is roughly its height
+	// below its top.
+	fmt.Fprintf(
+		&c.elements,
+		`<text x="%.2f" y="%.2f" font-family="sans-serif" font-weight="bold" font-size="%.2f" %s>%s</text>`+"\n",
+		x, y+size, size*1.3, svgFill("fill", fill), str,
+	)
+}
+
+// Write the SVG document to the given writer.
+func (c *svgCanvas) writeTo(w io.Writer) error {
+	_, err := fmt.Fprintf(
+		w,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n%s</svg>\n",
+		c.size, c.size, c.size, c.size, c.elements.String(),
+	)
+	return err
+}
@@ -29,7 +29,7 @@ func NewTranspositionTable() *TranspositionTable {
 func (tt *TranspositionTable) ReadEntry(hash uint64, depth, alpha, beta int) (int, bool) {
 	entry := &tt.entries[hash%tt.size]
 
-	if entry.Hash >= hash {
+	if entry.Hash == hash {
 		ttVal := entry.Value
 		ttFlag := entry.Flag
 